@@ -0,0 +1,149 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"gopkg.in/mgo.v2"
+)
+
+// AddressFamily identifies the IP address family of a member address.
+type AddressFamily int
+
+const (
+	// AnyFamily leaves addresses as they are given.
+	AnyFamily AddressFamily = iota
+
+	// IPv4 requires IPv4 addresses.
+	IPv4
+
+	// IPv6 requires IPv6 addresses.
+	IPv6
+)
+
+// String implements fmt.Stringer.
+func (f AddressFamily) String() string {
+	switch f {
+	case IPv4:
+		return "IPv4"
+	case IPv6:
+		return "IPv6"
+	}
+	return "any"
+}
+
+// InitiateOptions holds options that change the behaviour of
+// InitiateWithOptions. The zero value gives the same behaviour as Initiate.
+type InitiateOptions struct {
+	// Family, if not AnyFamily, causes the seed address to be put in
+	// canonical form for the given address family: a host name is
+	// resolved to its first address of that family, and an IP address
+	// must belong to the family. IPv6 addresses are always bracketed.
+	Family AddressFamily
+
+	// WaitCommitted, if true, causes InitiateWithOptions to wait until
+	// the initial config has been committed to a majority of the
+	// members, as WaitForConfigCommitted does, so that an initiate that
+	// is lost to a storage failure is reported as an error rather than
+	// appearing to succeed. replSetInitiate itself does not accept a
+	// write concern, so this is how a majority acknowledgement of the
+	// initial config is obtained.
+	WaitCommitted bool
+
+	// WaitTimeout holds the maximum amount of time spent waiting when
+	// WaitCommitted is true. If zero, defaultWaitWritableTimeout is used.
+	WaitTimeout time.Duration
+}
+
+// InitiateWithOptions is like Initiate but also takes options that change
+// its behaviour.
+func InitiateWithOptions(session *mgo.Session, address, name string, tags map[string]string, opts InitiateOptions) error {
+	if opts.Family != AnyFamily {
+		var err error
+		address, err = canonicalAddress(address, opts.Family)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := Initiate(session, address, name, tags); err != nil {
+		return err
+	}
+	if !opts.WaitCommitted {
+		return nil
+	}
+	timeout := opts.WaitTimeout
+	if timeout == 0 {
+		timeout = defaultWaitWritableTimeout
+	}
+	return errors.Annotate(WaitForConfigCommitted(session, timeout), "initial config")
+}
+
+// canonicalAddress returns the given address in canonical form for the
+// given address family, resolving its host if it is not an IP address.
+func canonicalAddress(address string, family AddressFamily) (string, error) {
+	host, port, err := net.SplitHostPort(formatIPv6AddressWithBrackets(address))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ipFamily(ip) != family {
+			return "", errors.NotValidf("%s address %q", ipFamily(ip), address)
+		}
+		return net.JoinHostPort(ip.String(), port), nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultResolveTimeout)
+	defer cancel()
+	addrs, err := lookupHost(ctx, host)
+	if err != nil {
+		return "", errors.Annotatef(err, "cannot resolve %q", address)
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ipFamily(ip) == family {
+			return net.JoinHostPort(ip.String(), port), nil
+		}
+	}
+	return "", errors.NotFoundf("%s address for %q", family, address)
+}
+
+// ipFamily returns the address family of the given IP address.
+func ipFamily(ip net.IP) AddressFamily {
+	if ip.To4() != nil {
+		return IPv4
+	}
+	return IPv6
+}
+
+// checkAddressFamilies returns an error satisfying errors.IsNotValid if the
+// given members use both IPv4 and IPv6 addresses, or if any of them has an
+// IPv6 address without brackets. Host names are not checked.
+func checkAddressFamilies(members []Member) error {
+	var first *Member
+	var firstFamily AddressFamily
+	for i, member := range members {
+		if strings.Count(member.Address, ":") >= 2 && !strings.HasPrefix(member.Address, "[") {
+			return errors.NotValidf("unbracketed IPv6 address %q", member.Address)
+		}
+		host, _, err := net.SplitHostPort(member.Address)
+		if err != nil {
+			continue
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			continue
+		}
+		if first == nil {
+			first, firstFamily = &members[i], ipFamily(ip)
+			continue
+		}
+		if ipFamily(ip) != firstFamily {
+			return errors.NotValidf("mixed IPv4 and IPv6 addresses %q and %q", first.Address, member.Address)
+		}
+	}
+	return nil
+}
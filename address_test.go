@@ -0,0 +1,94 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"context"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+func (s *MongoSuite) TestAddRejectsMixedAddresses(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	members := []Member{{Address: "127.0.0.1:37017"}, {Address: "[::1]:37018"}}
+	err := AddWithOptions(session, ReconfigOptions{RejectMixedAddresses: true}, members...)
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+
+	cfg, err := CurrentConfig(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(cfg.Version, gc.Equals, 1)
+}
+
+type addressSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&addressSuite{})
+
+func (s *addressSuite) TestCanonicalAddress(c *gc.C) {
+	s.PatchValue(&lookupHost, func(ctx context.Context, host string) ([]string, error) {
+		if host != "db.example.com" {
+			return nil, errors.New("no such host")
+		}
+		return []string{"10.0.0.1", "fd00::1"}, nil
+	})
+	for i, test := range []struct {
+		address  string
+		family   AddressFamily
+		expected string
+		err      string
+	}{
+		{address: "127.0.0.1:37017", family: IPv4, expected: "127.0.0.1:37017"},
+		{address: "[0:0::1]:37017", family: IPv6, expected: "[::1]:37017"},
+		{address: "::1:37017", family: IPv6, expected: "[::1]:37017"},
+		{address: "db.example.com:37017", family: IPv4, expected: "10.0.0.1:37017"},
+		{address: "db.example.com:37017", family: IPv6, expected: "[fd00::1]:37017"},
+		{address: "127.0.0.1:37017", family: IPv6, err: `IPv4 address "127.0.0.1:37017" not valid`},
+		{address: "other.example.com:37017", family: IPv4, err: `cannot resolve "other.example.com:37017": no such host`},
+	} {
+		c.Logf("test %d: %s %s", i, test.address, test.family)
+		address, err := canonicalAddress(test.address, test.family)
+		if test.err != "" {
+			c.Check(err, gc.ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(address, gc.Equals, test.expected)
+	}
+}
+
+func (s *addressSuite) TestCheckAddressFamilies(c *gc.C) {
+	for i, test := range []struct {
+		addresses []string
+		err       string
+	}{
+		{addresses: []string{"127.0.0.1:37017", "10.0.0.1:37017", "localhost:37017"}},
+		{addresses: []string{"[::1]:37017", "[fd00::1]:37017", "localhost:37017"}},
+		{
+			addresses: []string{"127.0.0.1:37017", "localhost:37017", "[::1]:37018"},
+			err:       `mixed IPv4 and IPv6 addresses "127.0.0.1:37017" and "\[::1\]:37018" not valid`,
+		}, {
+			addresses: []string{"[::1]:37017", "::1:37018"},
+			err:       `unbracketed IPv6 address "::1:37018" not valid`,
+		},
+	} {
+		c.Logf("test %d: %v", i, test.addresses)
+		var members []Member
+		for _, address := range test.addresses {
+			members = append(members, Member{Address: address})
+		}
+		err := checkAddressFamilies(members)
+		if test.err == "" {
+			c.Check(err, jc.ErrorIsNil)
+		} else {
+			c.Check(err, gc.ErrorMatches, test.err)
+			c.Check(err, jc.Satisfies, errors.IsNotValid)
+		}
+	}
+}
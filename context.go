@@ -0,0 +1,127 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/errors"
+	"gopkg.in/mgo.v2"
+)
+
+// The functions in this file are variants of the package functions that
+// run on a copy of the session whose socket and sync timeouts are bounded
+// by the context's deadline. If the operation fails once the context is
+// done, the returned error's cause is the context's error.
+//
+// mgo cannot interrupt a command once it has been sent, so the functions
+// always wait for the operation to finish: a context without a deadline
+// is only checked before the operation starts. A reconfig that fails
+// because the deadline passed may still have taken effect; callers should
+// read the config again before retrying.
+//
+// Only the functions that run a single command have a variant here. The
+// functions that retry or poll the replica set, such as Initiate,
+// WaitForPrimary or ScaleTo, would not check the context between attempts,
+// so they have none.
+
+// AddWithContext is like Add, but is bounded by ctx.
+func AddWithContext(ctx context.Context, session *mgo.Session, members ...Member) error {
+	return withContext(ctx, session, func(session *mgo.Session) error {
+		return Add(session, members...)
+	})
+}
+
+// RemoveWithContext is like Remove, but is bounded by ctx.
+func RemoveWithContext(ctx context.Context, session *mgo.Session, addrs ...string) error {
+	return withContext(ctx, session, func(session *mgo.Session) error {
+		return Remove(session, addrs...)
+	})
+}
+
+// SetWithContext is like Set, but is bounded by ctx.
+func SetWithContext(ctx context.Context, session *mgo.Session, members []Member) error {
+	return withContext(ctx, session, func(session *mgo.Session) error {
+		return Set(session, members)
+	})
+}
+
+// StepDownPrimaryWithContext is like StepDownPrimary, but is bounded by
+// ctx.
+func StepDownPrimaryWithContext(ctx context.Context, session *mgo.Session) error {
+	return withContext(ctx, session, func(session *mgo.Session) error {
+		return StepDownPrimary(session)
+	})
+}
+
+// CurrentConfigWithContext is like CurrentConfig, but is bounded by ctx.
+func CurrentConfigWithContext(ctx context.Context, session *mgo.Session) (*Config, error) {
+	var config *Config
+	err := withContext(ctx, session, func(session *mgo.Session) error {
+		var err error
+		config, err = CurrentConfig(session)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// CurrentStatusWithContext is like CurrentStatus, but is bounded by ctx.
+func CurrentStatusWithContext(ctx context.Context, session *mgo.Session) (*Status, error) {
+	var status *Status
+	err := withContext(ctx, session, func(session *mgo.Session) error {
+		var err error
+		status, err = CurrentStatus(session)
+		return err
+	})
+	if err != nil && !IsPartialStatus(err) {
+		return nil, err
+	}
+	return status, err
+}
+
+// withContext calls f with a copy of session whose timeouts are bounded by
+// the deadline of ctx, if any, and returns its error.
+func withContext(ctx context.Context, session *mgo.Session, f func(session *mgo.Session) error) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Trace(err)
+	}
+	session = session.Copy()
+	defer session.Close()
+	if timeout, ok := contextTimeout(ctx); ok {
+		session.SetSocketTimeout(timeout)
+		session.SetSyncTimeout(timeout)
+	}
+	return contextError(ctx, f(session))
+}
+
+// contextTimeout returns the time left until the deadline of ctx, and
+// whether ctx has a deadline.
+func contextTimeout(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		// A zero timeout means no timeout to mgo.
+		timeout = time.Nanosecond
+	}
+	return timeout, true
+}
+
+// contextError returns err, annotated so that its cause is the context's
+// error if ctx is done.
+func contextError(ctx context.Context, err error) error {
+	if err == nil || IsPartialStatus(err) {
+		return err
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return errors.Annotatef(ctxErr, "%v", err)
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2"
+)
+
+type contextSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&contextSuite{})
+
+func (s *contextSuite) TestContextTimeout(c *gc.C) {
+	_, ok := contextTimeout(context.Background())
+	c.Check(ok, jc.IsFalse)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	timeout, ok := contextTimeout(ctx)
+	c.Check(ok, jc.IsTrue)
+	c.Check(timeout > 0 && timeout <= time.Minute, jc.IsTrue)
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	timeout, ok = contextTimeout(ctx)
+	c.Check(ok, jc.IsTrue)
+	c.Check(timeout, gc.Equals, time.Nanosecond)
+}
+
+func (s *contextSuite) TestContextError(c *gc.C) {
+	err := contextError(context.Background(), errors.New("boom"))
+	c.Check(err, gc.ErrorMatches, "boom")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Check(contextError(ctx, nil), jc.ErrorIsNil)
+	err = contextError(ctx, errors.New("i/o timeout"))
+	c.Check(err, gc.ErrorMatches, "i/o timeout: context canceled")
+	c.Check(errors.Cause(err), gc.Equals, context.Canceled)
+}
+
+func (s *contextSuite) TestWithContextAlreadyDone(c *gc.C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := withContext(ctx, nil, func(*mgo.Session) error {
+		c.Fatalf("unexpected call")
+		return nil
+	})
+	c.Check(errors.Cause(err), gc.Equals, context.Canceled)
+}
+
+func (s *MongoSuite) TestCurrentStatusWithContext(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	status, err := CurrentStatusWithContext(context.Background(), session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(status.Name, gc.Equals, rsName)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = CurrentStatusWithContext(ctx, session)
+	c.Check(errors.Cause(err), gc.Equals, context.Canceled)
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// maxPriority is the highest priority that mongo accepts for a member.
+const maxPriority = 1000
+
+// MemberOption sets an optional attribute of a member created by
+// NewMember.
+type MemberOption func(*Member)
+
+// WithTags sets the tags of the member.
+func WithTags(tags map[string]string) MemberOption {
+	return func(m *Member) {
+		m.Tags = tags
+	}
+}
+
+// WithPriority sets the priority of the member.
+func WithPriority(priority float64) MemberOption {
+	return func(m *Member) {
+		m.Priority = &priority
+	}
+}
+
+// WithVotes sets the number of votes of the member, which must be 0 or 1.
+func WithVotes(votes int) MemberOption {
+	return func(m *Member) {
+		m.Votes = &votes
+	}
+}
+
+// AsHidden makes the member hidden.
+func AsHidden() MemberOption {
+	return func(m *Member) {
+		hidden := true
+		m.Hidden = &hidden
+	}
+}
+
+// AsArbiter makes the member an arbiter.
+func AsArbiter() MemberOption {
+	return func(m *Member) {
+		arbiter := true
+		m.Arbiter = &arbiter
+	}
+}
+
+// WithDelay sets how far behind the primary the member should lag. It is
+// rounded up to the nearest second.
+func WithDelay(delay time.Duration) MemberOption {
+	return func(m *Member) {
+		seconds := (delay + time.Second - 1) / time.Second
+		m.SlaveDelay = &seconds
+	}
+}
+
+// NewMember returns a member with the given address and options, checking
+// that the options are consistent with each other. Members that cannot
+// become primary (hidden, delayed and non-voting members, and arbiters)
+// are given a priority of 0 unless another priority was explicitly set,
+// in which case an error satisfying errors.IsNotValid is returned.
+//
+// The Id of the returned member is left as 0, so that it is assigned
+// automatically by Add or Set.
+func NewMember(addr string, opts ...MemberOption) (Member, error) {
+	m := Member{Address: addr}
+	for _, opt := range opts {
+		opt(&m)
+	}
+
+	isArbiter := m.Arbiter != nil && *m.Arbiter
+	isHidden := m.Hidden != nil && *m.Hidden
+	isDelayed := m.SlaveDelay != nil && *m.SlaveDelay > 0
+	isNonVoting := m.Votes != nil && *m.Votes == 0
+
+	if m.Votes != nil && *m.Votes != 0 && *m.Votes != 1 {
+		return Member{}, errors.NotValidf("%d votes", *m.Votes)
+	}
+	if m.Priority != nil && (*m.Priority < 0 || *m.Priority > maxPriority) {
+		return Member{}, errors.NotValidf("priority %v", *m.Priority)
+	}
+	if isArbiter {
+		switch {
+		case isHidden:
+			return Member{}, errors.NotValidf("hidden arbiter")
+		case isDelayed:
+			return Member{}, errors.NotValidf("delayed arbiter")
+		case isNonVoting:
+			return Member{}, errors.NotValidf("non-voting arbiter")
+		}
+	}
+
+	var reason string
+	switch {
+	case isArbiter:
+		reason = "arbiter"
+	case isHidden:
+		reason = "hidden member"
+	case isDelayed:
+		reason = "delayed member"
+	case isNonVoting:
+		reason = "non-voting member"
+	}
+	if reason != "" {
+		if m.Priority != nil && *m.Priority != 0 {
+			return Member{}, errors.NotValidf("%s with priority %v", reason, *m.Priority)
+		}
+		priority := 0.0
+		m.Priority = &priority
+	}
+	return m, nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type memberSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&memberSuite{})
+
+func (s *memberSuite) TestNewMember(c *gc.C) {
+	zero := 0.0
+	two := 2.0
+	one := 1
+	yes := true
+	delay := time.Duration(3600)
+	for i, test := range []struct {
+		about    string
+		opts     []MemberOption
+		expected Member
+	}{{
+		about:    "no options",
+		expected: Member{Address: "1.2.3.4:37017"},
+	}, {
+		about: "tags, priority and votes",
+		opts: []MemberOption{
+			WithTags(map[string]string{"dc": "east"}),
+			WithPriority(2),
+			WithVotes(1),
+		},
+		expected: Member{
+			Address:  "1.2.3.4:37017",
+			Tags:     map[string]string{"dc": "east"},
+			Priority: &two,
+			Votes:    &one,
+		},
+	}, {
+		about:    "hidden implies priority 0",
+		opts:     []MemberOption{AsHidden()},
+		expected: Member{Address: "1.2.3.4:37017", Hidden: &yes, Priority: &zero},
+	}, {
+		about:    "arbiter implies priority 0",
+		opts:     []MemberOption{AsArbiter()},
+		expected: Member{Address: "1.2.3.4:37017", Arbiter: &yes, Priority: &zero},
+	}, {
+		about: "delay is rounded up to seconds",
+		opts:  []MemberOption{AsHidden(), WithDelay(time.Hour - time.Millisecond)},
+		expected: Member{
+			Address:    "1.2.3.4:37017",
+			Hidden:     &yes,
+			SlaveDelay: &delay,
+			Priority:   &zero,
+		},
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		member, err := NewMember("1.2.3.4:37017", test.opts...)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(member, jc.DeepEquals, test.expected)
+	}
+}
+
+func (s *memberSuite) TestNewMemberInvalid(c *gc.C) {
+	for i, test := range []struct {
+		opts []MemberOption
+		err  string
+	}{{
+		opts: []MemberOption{WithVotes(2)},
+		err:  "2 votes not valid",
+	}, {
+		opts: []MemberOption{WithPriority(-1)},
+		err:  "priority -1 not valid",
+	}, {
+		opts: []MemberOption{AsHidden(), WithPriority(2)},
+		err:  "hidden member with priority 2 not valid",
+	}, {
+		opts: []MemberOption{WithDelay(time.Hour), WithPriority(1)},
+		err:  "delayed member with priority 1 not valid",
+	}, {
+		opts: []MemberOption{WithVotes(0), WithPriority(1)},
+		err:  "non-voting member with priority 1 not valid",
+	}, {
+		opts: []MemberOption{AsArbiter(), AsHidden()},
+		err:  "hidden arbiter not valid",
+	}, {
+		opts: []MemberOption{AsArbiter(), WithVotes(0)},
+		err:  "non-voting arbiter not valid",
+	}} {
+		c.Logf("test %d: %s", i, test.err)
+		_, err := NewMember("1.2.3.4:37017", test.opts...)
+		c.Check(err, gc.ErrorMatches, test.err)
+		c.Check(err, jc.Satisfies, errors.IsNotValid)
+	}
+}
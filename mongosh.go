@@ -0,0 +1,96 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/juju/errors"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ToMongoshReconfig returns an rs.reconfig() command that an operator can
+// paste into mongosh to apply the config by hand, with its version bumped
+// so that the server accepts it. The config is rendered with all of its
+// member fields and settings in the order they are stored, as indented
+// JSON. Values that JSON cannot represent, such as the replicaSetId
+// setting, are rendered with the mongosh helpers, for example
+// ObjectId("...").
+func (cfg *Config) ToMongoshReconfig() (string, error) {
+	next := *cfg
+	next.Version++
+	data, err := bson.Marshal(&next)
+	if err != nil {
+		return "", errors.Annotate(err, "cannot marshal config")
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return "", errors.Annotate(err, "cannot unmarshal config")
+	}
+	var buf bytes.Buffer
+	buf.WriteString("rs.reconfig(")
+	if err := writeMongoshValue(&buf, doc, ""); err != nil {
+		return "", errors.Trace(err)
+	}
+	buf.WriteString(")")
+	return buf.String(), nil
+}
+
+// writeMongoshValue writes the given value, as decoded from bson, to buf in
+// mongosh syntax, indenting nested lines by indent.
+func writeMongoshValue(buf *bytes.Buffer, value interface{}, indent string) error {
+	switch value := value.(type) {
+	case bson.D:
+		if len(value) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{\n")
+		for i, elem := range value {
+			name, err := json.Marshal(elem.Name)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			fmt.Fprintf(buf, "%s  %s: ", indent, name)
+			if err := writeMongoshValue(buf, elem.Value, indent+"  "); err != nil {
+				return errors.Annotatef(err, "field %q", elem.Name)
+			}
+			if i < len(value)-1 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "%s}", indent)
+	case []interface{}:
+		if len(value) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, elem := range value {
+			buf.WriteString(indent + "  ")
+			if err := writeMongoshValue(buf, elem, indent+"  "); err != nil {
+				return errors.Trace(err)
+			}
+			if i < len(value)-1 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "%s]", indent)
+	case bson.ObjectId:
+		fmt.Fprintf(buf, "ObjectId(%q)", value.Hex())
+	case bson.MongoTimestamp:
+		fmt.Fprintf(buf, "Timestamp({t: %d, i: %d})", int64(value)>>32, int64(value)&0xffffffff)
+	default:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		buf.Write(data)
+	}
+	return nil
+}
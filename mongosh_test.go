@@ -0,0 +1,103 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type mongoshSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&mongoshSuite{})
+
+func (s *mongoshSuite) TestToMongoshReconfig(c *gc.C) {
+	zero := 0
+	noPriority := 0.0
+	yes := true
+	timeout := 5000
+	config := &Config{
+		Name:            "juju",
+		ProtocolVersion: 1,
+		Version:         4,
+		Members: []Member{{
+			Id:      1,
+			Address: "1.2.3.4:37017",
+			Tags:    map[string]string{"dc": "east"},
+		}, {
+			Id:       2,
+			Address:  "[2001:db8::2]:37017",
+			Votes:    &zero,
+			Priority: &noPriority,
+			Hidden:   &yes,
+		}},
+		Settings: &ReplicaSetSettings{ElectionTimeoutMillis: &timeout},
+	}
+	cmd, err := config.ToMongoshReconfig()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(strings.HasPrefix(cmd, "rs.reconfig({\n"), jc.IsTrue)
+	c.Assert(strings.HasSuffix(cmd, "})"), jc.IsTrue)
+
+	var doc struct {
+		Name     string                   `json:"_id"`
+		Version  int                      `json:"version"`
+		Members  []map[string]interface{} `json:"members"`
+		Settings map[string]interface{}   `json:"settings"`
+	}
+	err = json.Unmarshal([]byte(strings.TrimSuffix(strings.TrimPrefix(cmd, "rs.reconfig("), ")")), &doc)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(doc.Name, gc.Equals, "juju")
+	c.Check(doc.Version, gc.Equals, 5)
+	c.Check(doc.Settings, jc.DeepEquals, map[string]interface{}{"electionTimeoutMillis": 5000.0})
+	c.Assert(doc.Members, gc.HasLen, 2)
+	c.Check(doc.Members[0]["host"], gc.Equals, "1.2.3.4:37017")
+	c.Check(doc.Members[0]["tags"], jc.DeepEquals, map[string]interface{}{"dc": "east"})
+	c.Check(doc.Members[1], jc.DeepEquals, map[string]interface{}{
+		"_id":      2.0,
+		"host":     "[2001:db8::2]:37017",
+		"hidden":   true,
+		"priority": 0.0,
+		"votes":    0.0,
+	})
+
+	// The config itself is left unchanged.
+	c.Check(config.Version, gc.Equals, 4)
+}
+
+func (s *mongoshSuite) TestToMongoshReconfigSecondaryDelay(c *gc.C) {
+	data, err := bson.Marshal(bson.M{
+		"_id":     "juju",
+		"version": 2,
+		"members": []bson.M{{"_id": 1, "host": "1.2.3.4:37017", "secondaryDelaySecs": 3600}},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	var config Config
+	err = bson.Unmarshal(data, &config)
+	c.Assert(err, jc.ErrorIsNil)
+
+	cmd, err := config.ToMongoshReconfig()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(cmd, jc.Contains, `"secondaryDelaySecs": 3600`)
+	c.Check(strings.Contains(cmd, "slaveDelay"), jc.IsFalse)
+}
+
+func (s *mongoshSuite) TestToMongoshReconfigObjectId(c *gc.C) {
+	id := bson.ObjectIdHex("5f1e8c3a9d3b2a0001a2b3c4")
+	config := &Config{
+		Name:     "juju",
+		Version:  1,
+		Members:  []Member{{Id: 1, Address: "1.2.3.4:37017"}},
+		Settings: &ReplicaSetSettings{ReplicaSetId: id},
+	}
+	cmd, err := config.ToMongoshReconfig()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(cmd, jc.Contains, `"replicaSetId": ObjectId("5f1e8c3a9d3b2a0001a2b3c4")`)
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// oplogCollection is the name of the oplog collection in the local
+// database.
+const oplogCollection = "oplog.rs"
+
+// OplogStats holds information about the size of the oplog of a replica
+// set member and the time window covered by its entries.
+type OplogStats struct {
+	// MaxSize holds the configured maximum size of the oplog, in bytes.
+	MaxSize int64
+
+	// Size holds the current size of the oplog entries, in bytes.
+	Size int64
+
+	// First holds the time of the oldest entry in the oplog. It is zero
+	// if the oplog is empty.
+	First time.Time
+
+	// Last holds the time of the newest entry in the oplog. It is zero
+	// if the oplog is empty.
+	Last time.Time
+}
+
+// Window returns the time span covered by the entries in the oplog.
+func (s *OplogStats) Window() time.Duration {
+	return s.Last.Sub(s.First)
+}
+
+// Utilization returns the fraction of the configured oplog size that is
+// currently in use.
+func (s *OplogStats) Utilization() float64 {
+	if s.MaxSize == 0 {
+		return 0
+	}
+	return float64(s.Size) / float64(s.MaxSize)
+}
+
+// CurrentOplogStats returns the size and time window of the oplog of the
+// member that the given session is connected to, as reported by
+// db.getReplicationInfo() in the mongo shell. Use a direct session to get
+// the stats of a specific member.
+func CurrentOplogStats(session *mgo.Session) (*OplogStats, error) {
+	local := session.DB("local")
+	var collStats struct {
+		MaxSize int64 `bson:"maxSize"`
+		Size    int64 `bson:"size"`
+	}
+	if err := local.Run(bson.D{{"collStats", oplogCollection}}, &collStats); err != nil {
+		return nil, errors.Annotate(err, "cannot get oplog stats")
+	}
+	stats := &OplogStats{
+		MaxSize: collStats.MaxSize,
+		Size:    collStats.Size,
+	}
+	oplog := local.C(oplogCollection)
+	var err error
+	if stats.First, err = oplogEntryTime(oplog, "$natural"); err != nil {
+		return nil, errors.Annotate(err, "cannot get first oplog entry")
+	}
+	if stats.Last, err = oplogEntryTime(oplog, "-$natural"); err != nil {
+		return nil, errors.Annotate(err, "cannot get last oplog entry")
+	}
+	return stats, nil
+}
+
+// oplogEntryTime returns the time of the first entry of the oplog in the
+// given sort order, or the zero time if the oplog is empty.
+func oplogEntryTime(oplog *mgo.Collection, sort string) (time.Time, error) {
+	var entry struct {
+		Timestamp bson.MongoTimestamp `bson:"ts"`
+	}
+	err := oplog.Find(nil).Sort(sort).Limit(1).One(&entry)
+	if err == mgo.ErrNotFound {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return timestampTime(entry.Timestamp), nil
+}
+
+// timestampTime returns the time held in the seconds part of a mongo
+// timestamp.
+func timestampTime(ts bson.MongoTimestamp) time.Time {
+	return time.Unix(int64(ts>>32), 0).UTC()
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *MongoSuite) TestCurrentOplogStats(c *gc.C) {
+	session := s.root.MustDialDirect()
+	defer session.Close()
+
+	stats, err := CurrentOplogStats(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(stats.MaxSize, jc.GreaterThan, int64(0))
+	c.Check(stats.Size, jc.GreaterThan, int64(0))
+	c.Check(stats.Utilization() > 0 && stats.Utilization() <= 1, jc.IsTrue)
+	c.Check(stats.First.IsZero(), jc.IsFalse)
+	c.Check(stats.Last.Before(stats.First), jc.IsFalse)
+	c.Check(stats.Window() >= 0, jc.IsTrue)
+}
+
+type oplogSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&oplogSuite{})
+
+func (s *oplogSuite) TestTimestampTime(c *gc.C) {
+	ts := bson.MongoTimestamp(1500000000<<32 | 7)
+	c.Check(timestampTime(ts), gc.Equals, time.Unix(1500000000, 0).UTC())
+}
+
+func (s *oplogSuite) TestWindowAndUtilization(c *gc.C) {
+	first := time.Unix(1500000000, 0).UTC()
+	stats := &OplogStats{
+		MaxSize: 1000,
+		Size:    250,
+		First:   first,
+		Last:    first.Add(time.Hour),
+	}
+	c.Check(stats.Window(), gc.Equals, time.Hour)
+	c.Check(stats.Utilization(), gc.Equals, 0.25)
+	c.Check((&OplogStats{}).Utilization(), gc.Equals, 0.0)
+}
@@ -0,0 +1,491 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils"
+	"gopkg.in/mgo.v2"
+)
+
+// ScaleTo changes the members of the session's replica set to the desired
+// members, adding or removing a single member per reconfig and waiting
+// for the replica set to be ready between each step, as recommended by
+// MongoDB to avoid long elections. Members are matched by address;
+// members that are added keep their Id if it is > 0, and are otherwise
+// given one automatically as with Add.
+//
+// When both adding and removing members, new members are added before
+// old ones are removed, as long as there is room for them. Once the
+// membership matches, any differences in the settings of the remaining
+// members (those set in the desired members) are applied in a final
+// reconfig; settings left unspecified keep their current values.
+//
+// The timeout applies to the whole operation.
+func ScaleTo(session *mgo.Session, desired []Member, timeout time.Duration) error {
+	return ScaleToWithProgress(session, desired, timeout, nil)
+}
+
+// ScaleToWithProgress is like ScaleTo, but also calls progress, if it is
+// not nil, each time it polls the replica set while waiting for it to be
+// ready after a step.
+func ScaleToWithProgress(session *mgo.Session, desired []Member, timeout time.Duration, progress ProgressFunc) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		current, err := CurrentMembers(session)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		toAdd, toRemove, toUpdate := scaleSteps(current, desired)
+		if len(toAdd) == 0 && len(toRemove) == 0 && !toUpdate {
+			return nil
+		}
+		// The server may normalise settings so that they never match the
+		// desired ones, so give up rather than reconfigure forever.
+		if time.Now().After(deadline) {
+			return errors.Errorf("timed out after %v scaling the replica set", timeout)
+		}
+		switch {
+		case len(toAdd) > 0 && (len(current) < MaxPeers || len(toRemove) == 0):
+			logger.Infof("ScaleTo: adding %s", toAdd[0].Address)
+			err = Add(session, toAdd[0])
+		case len(toRemove) > 0:
+			logger.Infof("ScaleTo: removing %s", toRemove[0])
+			err = Remove(session, toRemove[0])
+		case toUpdate:
+			logger.Infof("ScaleTo: updating member settings")
+			opts := ReconfigOptions{KeepMemberSettings: true}
+			err = SetWithOptions(session, opts, append([]Member(nil), desired...))
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err := waitUntilReadyBy(session, deadline, progress); err != nil {
+			return errors.Trace(err)
+		}
+	}
+}
+
+// RollingRestart restarts the members with the given addresses one at a
+// time, in order. For each member, it first steps the member down if it is
+// the primary and waits for it to become a secondary, then calls onNode
+// with the member's address to perform the restart, and finally waits for
+// the member to be a secondary again before moving on to the next one.
+//
+// The member is not put in maintenance mode before onNode is called, as
+// maintenance mode does not survive a restart; onNode is expected to stop
+// the member straight away.
+//
+// The timeout applies to the whole operation.
+func RollingRestart(session *mgo.Session, order []string, onNode func(addr string) error, timeout time.Duration) error {
+	return RollingRestartWithProgress(session, order, onNode, timeout, nil)
+}
+
+// RollingRestartWithProgress is like RollingRestart, but also calls
+// progress, if it is not nil, each time it polls the replica set while
+// waiting for a member to become a secondary.
+func RollingRestartWithProgress(session *mgo.Session, order []string, onNode func(addr string) error, timeout time.Duration, progress ProgressFunc) error {
+	deadline := time.Now().Add(timeout)
+	for _, addr := range order {
+		addr = formatIPv6AddressWithBrackets(addr)
+		status, err := getCurrentStatus(session)
+		if err != nil && !IsPartialStatus(err) {
+			return errors.Trace(err)
+		}
+		member := findMemberStatus(status, addr)
+		if member == nil {
+			return errors.NotFoundf("replica set member %q", addr)
+		}
+		if member.State == PrimaryState {
+			logger.Infof("RollingRestart: stepping down %s", addr)
+			if err := stepDownPrimary(session); err != nil {
+				return errors.Annotatef(err, "cannot step down %s", addr)
+			}
+			if err := waitForMemberStateBy(session, addr, SecondaryState, deadline, progress); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		logger.Infof("RollingRestart: restarting %s", addr)
+		if err := onNode(addr); err != nil {
+			return errors.Annotatef(err, "cannot restart %s", addr)
+		}
+		if err := waitForMemberStateBy(session, addr, SecondaryState, deadline, progress); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// CanRemove reports whether the member with the given address can safely
+// be removed from the session's replica set right now. If it cannot, the
+// reason is returned too. A member cannot be removed if:
+//
+//   - the healthy data-bearing members left afterwards would not hold a
+//     majority of the votes;
+//   - a custom write concern of the replica set could no longer be
+//     satisfied, because the member holds a tag value that no other
+//     member has;
+//   - another member is replicating from it.
+//
+// An error satisfying errors.IsNotFound is returned if there is no member
+// with the given address.
+func CanRemove(session *mgo.Session, addr string) (bool, string, error) {
+	config, err := CurrentConfig(session)
+	if err != nil {
+		return false, "", errors.Trace(err)
+	}
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return false, "", errors.Trace(err)
+	}
+	return canRemove(config, status, formatIPv6AddressWithBrackets(addr))
+}
+
+// canRemove implements CanRemove for the given config and status.
+func canRemove(config *Config, status *Status, addr string) (bool, string, error) {
+	var remaining []Member
+	found := false
+	for _, member := range config.Members {
+		if member.Address == addr {
+			found = true
+			continue
+		}
+		remaining = append(remaining, member)
+	}
+	if !found {
+		return false, "", errors.NotFoundf("replica set member %q", addr)
+	}
+	if err := checkWritableMajority(status, remaining); err != nil {
+		return false, err.Error(), nil
+	}
+	if config.Settings != nil {
+		modes := make([]string, 0, len(config.Settings.CustomWriteConcerns))
+		for mode := range config.Settings.CustomWriteConcerns {
+			modes = append(modes, mode)
+		}
+		sort.Strings(modes)
+		for _, mode := range modes {
+			tags := make([]string, 0, len(config.Settings.CustomWriteConcerns[mode]))
+			for tag := range config.Settings.CustomWriteConcerns[mode] {
+				tags = append(tags, tag)
+			}
+			sort.Strings(tags)
+			for _, tag := range tags {
+				needed := config.Settings.CustomWriteConcerns[mode][tag]
+				values := make(map[string]bool)
+				for _, member := range remaining {
+					if value, ok := member.Tags[tag]; ok {
+						values[value] = true
+					}
+				}
+				if len(values) < needed {
+					return false, fmt.Sprintf("write concern %q needs %d values of tag %q, %d would be left",
+						mode, needed, tag, len(values)), nil
+				}
+			}
+		}
+	}
+	var dependents []string
+	for member, source := range syncTopology(status) {
+		if source == addr {
+			dependents = append(dependents, member)
+		}
+	}
+	if len(dependents) > 0 {
+		sort.Strings(dependents)
+		return false, fmt.Sprintf("%s replicate from it", strings.Join(dependents, ", ")), nil
+	}
+	return true, "", nil
+}
+
+// CompactMemberIDs reassigns the Ids of the members of the session's
+// replica set to the dense range 1..N, keeping their order and all their
+// other settings, and returns the new Id of each member keyed by address.
+//
+// It is potentially disruptive and must only be used deliberately: mongo
+// does not allow the Id of a member to change while it stays in the
+// config, so each member whose Id changes is removed and then added back
+// with its new Id, in two reconfigs, waiting for the replica set to be
+// ready after each one. While a member is out of the config it does not
+// serve reads or count towards majorities, so a removal that would leave
+// the healthy data-bearing members without a majority of the votes is
+// refused. The Id of the primary cannot be changed this way; step it
+// down first. Tooling that caches member Ids must be updated afterwards.
+func CompactMemberIDs(session *mgo.Session) (mapping map[string]int, err error) {
+	config, err := CurrentConfig(session)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	mapping = compactIDs(config.Members)
+	for _, member := range config.Members {
+		newId := mapping[member.Address]
+		if newId == member.Id {
+			continue
+		}
+		status, err := getCurrentStatus(session)
+		if err != nil && !IsPartialStatus(err) {
+			return nil, errors.Trace(err)
+		}
+		if memberStatus := findMemberStatus(status, member.Address); memberStatus != nil && memberStatus.State == PrimaryState {
+			return nil, errors.Errorf("cannot change the Id of primary %s; step it down first", member.Address)
+		}
+		current, err := CurrentMembers(session)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err := checkWritableMajority(status, replaceMembers(current, []string{member.Address}, nil)); err != nil {
+			return nil, errors.Annotatef(err, "cannot remove %s", member.Address)
+		}
+
+		logger.Infof("CompactMemberIDs: changing the Id of %s from %d to %d", member.Address, member.Id, newId)
+		if err := Remove(session, member.Address); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err := waitUntilReadyBy(session, time.Now().Add(defaultWaitWritableTimeout), nil); err != nil {
+			return nil, errors.Trace(err)
+		}
+		member.Id = newId
+		member.NewlyAdded = nil
+		if err := Add(session, member); err != nil {
+			return nil, errors.Annotatef(err, "cannot add %s back", member.Address)
+		}
+		if err := waitUntilReadyBy(session, time.Now().Add(defaultWaitWritableTimeout), nil); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return mapping, nil
+}
+
+// compactIDs returns the Ids in the range 1..N that the given members,
+// which must be sorted by Id, would have once compacted, keyed by address.
+// As each Id can only decrease, the members can be moved to their new Id
+// one by one in order without clashing.
+func compactIDs(members []Member) map[string]int {
+	mapping := make(map[string]int, len(members))
+	for i, member := range members {
+		mapping[member.Address] = i + 1
+	}
+	return mapping
+}
+
+// maxElectableLag is the largest replication lag behind the most up to
+// date member with which IsElectable considers a member electable.
+const maxElectableLag = 10 * time.Second
+
+// IsElectable reports whether the member with the given address is
+// currently eligible to become primary, and if not, why. An eligible
+// member is a healthy secondary with votes and a priority above 0 that is
+// neither hidden nor an arbiter, and that lags the most up to date member
+// (the primary if there is one) by no more than 10 seconds.
+//
+// An error satisfying errors.IsNotFound is returned if there is no member
+// with the given address.
+func IsElectable(session *mgo.Session, addr string) (bool, string, error) {
+	config, err := CurrentConfig(session)
+	if err != nil {
+		return false, "", errors.Trace(err)
+	}
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return false, "", errors.Trace(err)
+	}
+	return isElectable(config, status, formatIPv6AddressWithBrackets(addr))
+}
+
+// isElectable implements IsElectable for the given config and status.
+func isElectable(config *Config, status *Status, addr string) (bool, string, error) {
+	i := findMember(config.Members, addr)
+	if i < 0 {
+		return false, "", errors.NotFoundf("replica set member %q", addr)
+	}
+	member := config.Members[i]
+	switch {
+	case member.Arbiter != nil && *member.Arbiter:
+		return false, "member is an arbiter", nil
+	case memberVotes(member) == 0:
+		return false, "member has no votes", nil
+	case member.Hidden != nil && *member.Hidden:
+		return false, "member is hidden", nil
+	case member.Priority != nil && *member.Priority == 0:
+		return false, "priority is 0", nil
+	}
+	memberStatus := findMemberStatus(status, addr)
+	switch {
+	case memberStatus == nil:
+		return false, "member is not in the replica set status", nil
+	case !memberStatus.Healthy:
+		return false, "member is not healthy", nil
+	case memberStatus.State != SecondaryState:
+		return false, fmt.Sprintf("state is %v", memberStatus.State), nil
+	}
+	var latest time.Time
+	for _, other := range status.Members {
+		if other.State == PrimaryState {
+			latest = other.OptimeApplied
+			break
+		}
+		if other.Healthy && other.OptimeApplied.After(latest) {
+			latest = other.OptimeApplied
+		}
+	}
+	if lag := latest.Sub(memberStatus.OptimeApplied); lag > maxElectableLag {
+		return false, fmt.Sprintf("lag %v exceeds threshold %v", lag, maxElectableLag), nil
+	}
+	return true, "", nil
+}
+
+// findMemberStatus returns the status of the member with the given
+// address, or nil if there is no such member.
+func findMemberStatus(status *Status, addr string) *MemberStatus {
+	for i, member := range status.Members {
+		if member.Address == addr {
+			return &status.Members[i]
+		}
+	}
+	return nil
+}
+
+// waitForMemberStateBy waits until the member with the given address is
+// healthy and in the given state, giving up at the given deadline. If
+// progress is not nil, it is called with each status polled.
+func waitForMemberStateBy(session *mgo.Session, addr string, state MemberState, deadline time.Time, progress ProgressFunc) error {
+	phase := fmt.Sprintf("waiting for %s to become %s", addr, state)
+	attempts := utils.AttemptStrategy{
+		Delay: memberStateAttemptDelay,
+		Total: time.Until(deadline),
+	}
+	for a := attempts.Start(); a.Next(); {
+		status, err := getCurrentStatus(session)
+		if isConnectionNotAvailable(err) {
+			logger.Errorf("DB connection dropped so reconnecting")
+			session.Refresh()
+			continue
+		}
+		if err != nil && !IsPartialStatus(err) {
+			return errors.Trace(err)
+		}
+		member := findMemberStatus(status, addr)
+		if member != nil && member.Healthy && member.State == state {
+			return nil
+		}
+		if progress != nil {
+			progress(phase, status)
+		}
+	}
+	return errors.Errorf("timed out %s", phase)
+}
+
+// waitUntilReadyBy waits until all members of the replicaset are ready,
+// giving up at the given deadline. If progress is not nil, it is called
+// with the current status each time the members are found not to be ready.
+func waitUntilReadyBy(session *mgo.Session, deadline time.Time, progress ProgressFunc) error {
+	remaining := time.Until(deadline)
+	if remaining < 0 {
+		remaining = 0
+	}
+	if progress == nil {
+		return WaitUntilReady(session, int((remaining+time.Second-1)/time.Second))
+	}
+	attempts := utils.AttemptStrategy{
+		Delay: 10 * time.Second,
+		Total: remaining,
+	}
+	for a := attempts.Start(); a.Next(); {
+		ready, err := isReady(session)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if ready {
+			return nil
+		}
+		reportProgress(session, progress, readyPhase)
+	}
+	return errors.Errorf("timed out after %v %s", remaining, readyPhase)
+}
+
+// ProgressFunc is called by the long-running helpers each time they poll
+// the replica set, with a description of what they are waiting for, such
+// as "waiting for commit", and the latest status. The status is nil if it
+// could not be retrieved.
+type ProgressFunc func(phase string, status *Status)
+
+// Phases reported to a ProgressFunc.
+const (
+	readyPhase    = "waiting for members to be ready"
+	commitPhase   = "waiting for commit"
+	writablePhase = "waiting for writable"
+)
+
+// reportProgress calls progress, if it is not nil, with the given phase and
+// the current status of the session's replica set.
+func reportProgress(session *mgo.Session, progress ProgressFunc, phase string) {
+	if progress == nil {
+		return
+	}
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		logger.Debugf("cannot get status to report progress: %v", err)
+		status = nil
+	}
+	progress(phase, status)
+}
+
+// scaleSteps compares the current members of a replica set with the
+// desired ones, returning the members to add, the addresses of the members
+// to remove and whether any of the remaining members has settings that
+// differ from the desired ones.
+func scaleSteps(current, desired []Member) (toAdd []Member, toRemove []string, toUpdate bool) {
+	currentByAddress := make(map[string]Member)
+	for _, member := range current {
+		currentByAddress[formatIPv6AddressWithBrackets(member.Address)] = member
+	}
+	desiredAddresses := make(map[string]bool)
+	for _, member := range desired {
+		address := formatIPv6AddressWithBrackets(member.Address)
+		desiredAddresses[address] = true
+		existing, ok := currentByAddress[address]
+		if !ok {
+			toAdd = append(toAdd, member)
+			continue
+		}
+		if memberNeedsUpdate(existing, member) {
+			toUpdate = true
+		}
+	}
+	for _, member := range current {
+		if !desiredAddresses[formatIPv6AddressWithBrackets(member.Address)] {
+			toRemove = append(toRemove, member.Address)
+		}
+	}
+	return toAdd, toRemove, toUpdate
+}
+
+// memberNeedsUpdate reports whether any of the optional settings specified
+// in desired differ from those of current. Settings left unspecified in
+// desired are ignored.
+func memberNeedsUpdate(current, desired Member) bool {
+	switch {
+	case desired.Arbiter != nil && (current.Arbiter == nil || *current.Arbiter != *desired.Arbiter):
+		return true
+	case desired.BuildIndexes != nil && (current.BuildIndexes == nil || *current.BuildIndexes != *desired.BuildIndexes):
+		return true
+	case desired.Hidden != nil && (current.Hidden == nil || *current.Hidden != *desired.Hidden):
+		return true
+	case desired.Priority != nil && (current.Priority == nil || *current.Priority != *desired.Priority):
+		return true
+	case desired.SlaveDelay != nil && (current.SlaveDelay == nil || *current.SlaveDelay != *desired.SlaveDelay):
+		return true
+	case desired.Votes != nil && (current.Votes == nil || *current.Votes != *desired.Votes):
+		return true
+	case desired.Tags != nil && !reflect.DeepEqual(current.Tags, desired.Tags):
+		return true
+	}
+	return false
+}
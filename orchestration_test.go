@@ -0,0 +1,447 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type orchestrationSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&orchestrationSuite{})
+
+func (s *orchestrationSuite) TestScaleSteps(c *gc.C) {
+	two := 2.0
+	current := []Member{
+		{Id: 1, Address: "1.2.3.4:37017"},
+		{Id: 2, Address: "1.2.3.5:37017"},
+		{Id: 3, Address: "1.2.3.6:37017"},
+	}
+	desired := []Member{
+		{Address: "1.2.3.4:37017"},
+		{Address: "1.2.3.5:37017", Priority: &two},
+		{Address: "1.2.3.7:37017"},
+		{Address: "1.2.3.8:37017"},
+	}
+	toAdd, toRemove, toUpdate := scaleSteps(current, desired)
+	c.Check(toAdd, jc.DeepEquals, desired[2:])
+	c.Check(toRemove, jc.DeepEquals, []string{"1.2.3.6:37017"})
+	c.Check(toUpdate, jc.IsTrue)
+
+	toAdd, toRemove, toUpdate = scaleSteps(current, current)
+	c.Check(toAdd, gc.HasLen, 0)
+	c.Check(toRemove, gc.HasLen, 0)
+	c.Check(toUpdate, jc.IsFalse)
+}
+
+// rollingStatus returns a status where the members with the given
+// addresses are healthy secondaries, except for the primary and for down,
+// which is unhealthy unless empty.
+func rollingStatus(addrs []string, primary, down string) *Status {
+	status := &Status{Name: rsName}
+	for i, addr := range addrs {
+		member := MemberStatus{Id: i + 1, Address: addr, Healthy: true, State: SecondaryState}
+		switch addr {
+		case primary:
+			member.State = PrimaryState
+		case down:
+			member.Healthy = false
+			member.State = DownState
+		}
+		status.Members = append(status.Members, member)
+	}
+	return status
+}
+
+func (s *orchestrationSuite) TestRollingRestart(c *gc.C) {
+	addrs := []string{"1.2.3.4:37017", "1.2.3.5:37017", "1.2.3.6:37017"}
+	primary := addrs[0]
+	down := ""
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		status := rollingStatus(addrs, primary, down)
+		// The restarted member comes back up once it has been seen down.
+		down = ""
+		return status, nil
+	})
+	s.PatchValue(&stepDownPrimary, func(session *mgo.Session) error {
+		if primary == addrs[0] {
+			primary = addrs[1]
+		} else {
+			primary = addrs[0]
+		}
+		return nil
+	})
+	var restarted []string
+	onNode := func(addr string) error {
+		c.Check(addr, gc.Not(gc.Equals), primary)
+		restarted = append(restarted, addr)
+		down = addr
+		return nil
+	}
+	err := RollingRestart(nil, addrs, onNode, time.Minute)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(restarted, jc.DeepEquals, addrs)
+}
+
+func (s *orchestrationSuite) TestRollingRestartWithProgress(c *gc.C) {
+	addrs := []string{"1.2.3.4:37017", "1.2.3.5:37017"}
+	down := ""
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		status := rollingStatus(addrs, "", down)
+		down = ""
+		return status, nil
+	})
+	onNode := func(addr string) error {
+		down = addr
+		return nil
+	}
+	var phases []string
+	progress := func(phase string, status *Status) {
+		c.Check(status, gc.NotNil)
+		phases = append(phases, phase)
+	}
+	err := RollingRestartWithProgress(nil, addrs, onNode, time.Minute, progress)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(phases, jc.DeepEquals, []string{
+		"waiting for 1.2.3.4:37017 to become SECONDARY",
+		"waiting for 1.2.3.5:37017 to become SECONDARY",
+	})
+}
+
+func (s *orchestrationSuite) TestRollingRestartCallbackError(c *gc.C) {
+	addrs := []string{"1.2.3.4:37017", "1.2.3.5:37017"}
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return rollingStatus(addrs, addrs[1], ""), nil
+	})
+	err := RollingRestart(nil, addrs, func(addr string) error {
+		return errors.New("boom")
+	}, time.Minute)
+	c.Check(err, gc.ErrorMatches, `cannot restart 1.2.3.4:37017: boom`)
+}
+
+func (s *orchestrationSuite) TestRollingRestartUnknownMember(c *gc.C) {
+	addrs := []string{"1.2.3.4:37017"}
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return rollingStatus(addrs, addrs[0], ""), nil
+	})
+	err := RollingRestart(nil, []string{"1.2.3.9:37017"}, func(addr string) error {
+		c.Fatalf("unexpected restart of %s", addr)
+		return nil
+	}, time.Minute)
+	c.Check(err, jc.Satisfies, errors.IsNotFound)
+}
+
+func (s *orchestrationSuite) TestCanRemove(c *gc.C) {
+	members := []Member{
+		{Id: 1, Address: "1.2.3.4:37017", Tags: map[string]string{"dc": "east"}},
+		{Id: 2, Address: "1.2.3.5:37017", Tags: map[string]string{"dc": "east"}},
+		{Id: 3, Address: "1.2.3.6:37017", Tags: map[string]string{"dc": "west"}},
+		{Id: 4, Address: "1.2.3.7:37017"},
+		{Id: 5, Address: "1.2.3.8:37017"},
+	}
+	healthy := func(ids ...int) *Status {
+		status := &Status{}
+		for _, member := range members {
+			memberStatus := MemberStatus{Id: member.Id, Address: member.Address, State: SecondaryState}
+			for _, id := range ids {
+				if id == member.Id {
+					memberStatus.Healthy = true
+				}
+			}
+			status.Members = append(status.Members, memberStatus)
+		}
+		return status
+	}
+	for i, test := range []struct {
+		about    string
+		settings *ReplicaSetSettings
+		status   *Status
+		addr     string
+		ok       bool
+		reason   string
+	}{{
+		about:  "healthy member can be removed",
+		status: healthy(1, 2, 3, 4, 5),
+		addr:   "1.2.3.8:37017",
+		ok:     true,
+	}, {
+		about:  "removal would lose the majority",
+		status: healthy(1, 2, 5),
+		addr:   "1.2.3.8:37017",
+		reason: "new config would leave 2 of 4 votes on healthy data-bearing members, need 3",
+	}, {
+		about: "sole holder of a tag value",
+		settings: &ReplicaSetSettings{CustomWriteConcerns: map[string]map[string]int{
+			"multiDC": {"dc": 2},
+		}},
+		status: healthy(1, 2, 3, 4, 5),
+		addr:   "1.2.3.6:37017",
+		reason: `write concern "multiDC" needs 2 values of tag "dc", 1 would be left`,
+	}, {
+		about: "tag value held by another member",
+		settings: &ReplicaSetSettings{CustomWriteConcerns: map[string]map[string]int{
+			"multiDC": {"dc": 2},
+		}},
+		status: healthy(1, 2, 3, 4, 5),
+		addr:   "1.2.3.5:37017",
+		ok:     true,
+	}, {
+		about: "sync source of other members",
+		status: func() *Status {
+			status := healthy(1, 2, 3, 4, 5)
+			status.Members[3].SyncSource = "1.2.3.5:37017"
+			status.Members[4].SyncSource = "1.2.3.5:37017"
+			return status
+		}(),
+		addr:   "1.2.3.5:37017",
+		reason: "1.2.3.7:37017, 1.2.3.8:37017 replicate from it",
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		config := &Config{Name: "juju", Members: members, Settings: test.settings}
+		ok, reason, err := canRemove(config, test.status, test.addr)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(ok, gc.Equals, test.ok)
+		c.Check(reason, gc.Equals, test.reason)
+	}
+}
+
+func (s *orchestrationSuite) TestCanRemoveNotFound(c *gc.C) {
+	config := &Config{Name: "juju", Members: []Member{{Id: 1, Address: "1.2.3.4:37017"}}}
+	_, _, err := canRemove(config, &Status{}, "1.2.3.5:37017")
+	c.Check(err, gc.ErrorMatches, `replica set member "1.2.3.5:37017" not found`)
+	c.Check(err, jc.Satisfies, errors.IsNotFound)
+}
+
+func (s *orchestrationSuite) TestIsElectable(c *gc.C) {
+	yes := true
+	zero := 0
+	noPriority := 0.0
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	config := &Config{Name: "juju", Members: []Member{
+		{Id: 1, Address: "1.2.3.1:37017"},
+		{Id: 2, Address: "1.2.3.2:37017"},
+		{Id: 3, Address: "1.2.3.3:37017", Arbiter: &yes},
+		{Id: 4, Address: "1.2.3.4:37017", Votes: &zero, Priority: &noPriority},
+		{Id: 5, Address: "1.2.3.5:37017", Hidden: &yes, Priority: &noPriority},
+		{Id: 6, Address: "1.2.3.6:37017", Priority: &noPriority},
+		{Id: 7, Address: "1.2.3.7:37017"},
+		{Id: 8, Address: "1.2.3.8:37017"},
+		{Id: 9, Address: "1.2.3.9:37017"},
+	}}
+	status := &Status{Members: []MemberStatus{
+		{Id: 1, Address: "1.2.3.1:37017", Healthy: true, State: PrimaryState, OptimeApplied: now},
+		{Id: 2, Address: "1.2.3.2:37017", Healthy: true, State: SecondaryState, OptimeApplied: now.Add(-time.Second)},
+		{Id: 3, Address: "1.2.3.3:37017", Healthy: true, State: ArbiterState},
+		{Id: 4, Address: "1.2.3.4:37017", Healthy: true, State: SecondaryState, OptimeApplied: now},
+		{Id: 5, Address: "1.2.3.5:37017", Healthy: true, State: SecondaryState, OptimeApplied: now},
+		{Id: 6, Address: "1.2.3.6:37017", Healthy: true, State: SecondaryState, OptimeApplied: now},
+		{Id: 7, Address: "1.2.3.7:37017", Healthy: false, State: DownState},
+		{Id: 8, Address: "1.2.3.8:37017", Healthy: true, State: RecoveringState, OptimeApplied: now},
+		{Id: 9, Address: "1.2.3.9:37017", Healthy: true, State: SecondaryState, OptimeApplied: now.Add(-45 * time.Second)},
+	}}
+	for i, test := range []struct {
+		addr   string
+		ok     bool
+		reason string
+	}{
+		{"1.2.3.2:37017", true, ""},
+		{"1.2.3.1:37017", false, "state is PRIMARY"},
+		{"1.2.3.3:37017", false, "member is an arbiter"},
+		{"1.2.3.4:37017", false, "member has no votes"},
+		{"1.2.3.5:37017", false, "member is hidden"},
+		{"1.2.3.6:37017", false, "priority is 0"},
+		{"1.2.3.7:37017", false, "member is not healthy"},
+		{"1.2.3.8:37017", false, "state is RECOVERING"},
+		{"1.2.3.9:37017", false, "lag 45s exceeds threshold 10s"},
+	} {
+		c.Logf("test %d: %s", i, test.addr)
+		ok, reason, err := isElectable(config, status, test.addr)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(ok, gc.Equals, test.ok)
+		c.Check(reason, gc.Equals, test.reason)
+	}
+
+	_, _, err := isElectable(config, status, "1.2.3.10:37017")
+	c.Check(err, jc.Satisfies, errors.IsNotFound)
+}
+
+func (s *orchestrationSuite) TestCompactIDs(c *gc.C) {
+	mapping := compactIDs([]Member{
+		{Id: 1, Address: "1.2.3.4:37017"},
+		{Id: 10, Address: "1.2.3.5:37017"},
+		{Id: 11, Address: "1.2.3.6:37017"},
+	})
+	c.Check(mapping, jc.DeepEquals, map[string]int{
+		"1.2.3.4:37017": 1,
+		"1.2.3.5:37017": 2,
+		"1.2.3.6:37017": 3,
+	})
+}
+
+func (s *commandSuite) TestCompactMemberIDs(c *gc.C) {
+	two := 2.0
+	current := &Config{Name: rsName, Version: 1, Members: []Member{
+		{Id: 1, Address: "1.2.3.4:37017"},
+		{Id: 10, Address: "1.2.3.5:37017", Priority: &two},
+		{Id: 11, Address: "1.2.3.6:37017", Tags: map[string]string{"dc": "east"}},
+	}}
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		config := *current
+		config.Members = append([]Member(nil), current.Members...)
+		normalizeConfig(&config)
+		return &config, nil
+	})
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		status := &Status{}
+		for _, member := range current.Members {
+			state := MemberState(SecondaryState)
+			if member.Id == 1 {
+				state = PrimaryState
+			}
+			status.Members = append(status.Members, MemberStatus{
+				Id: member.Id, Address: member.Address, Healthy: true, State: state,
+			})
+		}
+		return status, nil
+	})
+	s.PatchValue(&isReady, func(session *mgo.Session) (bool, error) {
+		return true, nil
+	})
+	var versions []int
+	s.PatchValue(&runCommand, func(session Runner, cmd interface{}, result interface{}) error {
+		if doc, ok := cmd.(bson.D); ok && doc[0].Name == "replSetReconfig" {
+			current = doc[0].Value.(*Config)
+			versions = append(versions, current.Version)
+		}
+		return nil
+	})
+
+	mapping, err := CompactMemberIDs(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(mapping, jc.DeepEquals, map[string]int{
+		"1.2.3.4:37017": 1,
+		"1.2.3.5:37017": 2,
+		"1.2.3.6:37017": 3,
+	})
+	// Each moved member is removed and added back.
+	c.Check(versions, jc.DeepEquals, []int{2, 3, 4, 5})
+
+	members, err := CurrentMembers(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(members, gc.HasLen, 3)
+	for i, member := range members {
+		c.Check(member.Id, gc.Equals, i+1)
+	}
+	c.Check(*members[1].Priority, gc.Equals, 2.0)
+	c.Check(members[2].Tags, jc.DeepEquals, map[string]string{"dc": "east"})
+}
+
+func (s *commandSuite) TestCompactMemberIDsPrimary(c *gc.C) {
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{Name: rsName, Version: 1, Members: []Member{
+			{Id: 3, Address: "1.2.3.4:37017"},
+			{Id: 4, Address: "1.2.3.5:37017"},
+		}}, nil
+	})
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return &Status{Members: []MemberStatus{
+			{Id: 3, Address: "1.2.3.4:37017", Healthy: true, State: PrimaryState},
+			{Id: 4, Address: "1.2.3.5:37017", Healthy: true, State: SecondaryState},
+		}}, nil
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	_, err := CompactMemberIDs(nil)
+	c.Check(err, gc.ErrorMatches, `cannot change the Id of primary 1.2.3.4:37017; step it down first`)
+	c.Check(s.commands, gc.HasLen, 0)
+}
+
+// patchScaleServer patches the commands of the given suite so that
+// reconfigs replace the config returned by CurrentConfig, after passing
+// each member through normalise, and so that the replica set is always
+// ready.
+func patchScaleServer(c *gc.C, s *commandSuite, config *Config, normalise func(*Member)) {
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		copied := *config
+		copied.Members = append([]Member(nil), config.Members...)
+		return &copied, nil
+	})
+	s.PatchValue(&isReady, func(session *mgo.Session) (bool, error) {
+		return true, nil
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	record := runCommand
+	s.PatchValue(&runCommand, func(session Runner, cmd interface{}, result interface{}) error {
+		if doc, ok := cmd.(bson.D); ok && doc[0].Name == "replSetReconfig" {
+			applied := *doc[0].Value.(*Config)
+			applied.Members = append([]Member(nil), applied.Members...)
+			for i := range applied.Members {
+				normalise(&applied.Members[i])
+			}
+			*config = applied
+		}
+		return record(session, cmd, result)
+	})
+}
+
+func (s *commandSuite) TestScaleTo(c *gc.C) {
+	config := &Config{Name: rsName, Version: 1, Members: []Member{
+		{Id: 1, Address: "1.2.3.4:37017"},
+		{Id: 2, Address: "1.2.3.5:37017"},
+	}}
+	patchScaleServer(c, s, config, func(*Member) {})
+	two := 2.0
+	desired := []Member{
+		{Address: "1.2.3.4:37017", Priority: &two},
+		{Address: "1.2.3.6:37017"},
+	}
+	err := ScaleTo(nil, desired, time.Minute)
+	c.Assert(err, jc.ErrorIsNil)
+	// One member is added, one removed and the settings updated.
+	c.Check(config.Version, gc.Equals, 4)
+	c.Assert(config.Members, gc.HasLen, 2)
+	c.Check(config.Members[0].Address, gc.Equals, "1.2.3.4:37017")
+	c.Check(*config.Members[0].Priority, gc.Equals, 2.0)
+	c.Check(config.Members[1].Address, gc.Equals, "1.2.3.6:37017")
+}
+
+func (s *commandSuite) TestScaleToKeepsMemberSettings(c *gc.C) {
+	config := &Config{Name: rsName, Version: 1, Members: []Member{
+		{Id: 1, Address: "1.2.3.4:37017", Tags: map[string]string{"region": "eu"}},
+	}}
+	patchScaleServer(c, s, config, func(*Member) {})
+	two := 2.0
+	err := ScaleTo(nil, []Member{{Address: "1.2.3.4:37017", Priority: &two}}, time.Minute)
+	c.Assert(err, jc.ErrorIsNil)
+	// Only the priority is changed; the tags are left as they were.
+	c.Check(config.Version, gc.Equals, 2)
+	c.Assert(config.Members, gc.HasLen, 1)
+	c.Check(*config.Members[0].Priority, gc.Equals, 2.0)
+	c.Check(config.Members[0].Tags, jc.DeepEquals, map[string]string{"region": "eu"})
+}
+
+func (s *commandSuite) TestScaleToNeverConverges(c *gc.C) {
+	config := &Config{Name: rsName, Version: 1, Members: []Member{
+		{Id: 1, Address: "1.2.3.4:37017"},
+	}}
+	// The server drops the priority, so the settings never match.
+	patchScaleServer(c, s, config, func(m *Member) {
+		m.Priority = nil
+	})
+	two := 2.0
+	err := ScaleTo(nil, []Member{{Address: "1.2.3.4:37017", Priority: &two}}, 50*time.Millisecond)
+	c.Check(err, gc.ErrorMatches, "timed out after 50ms scaling the replica set")
+}
@@ -0,0 +1,145 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"github.com/juju/errors"
+	"gopkg.in/mgo.v2"
+)
+
+// ReadCheck identifies a check made by this package on a read of the
+// replica set config or status.
+//
+// These are not MongoDB read concerns: replSetGetConfig and
+// replSetGetStatus do not accept a readConcern, so the checks are made on
+// the reply, after the read. They narrow the window in which a stale or
+// uncommitted view is returned, but they do not give the guarantees of
+// the read concerns of the same purpose. In particular, a primary that
+// passes ReadCheckPrimaryMajority may lose its majority as soon as it has
+// replied.
+type ReadCheck string
+
+const (
+	// ReadCheckNone reads from the member the session uses without any
+	// check, so the config or status may not have reached, or may never
+	// reach, a majority of the members. It is the default.
+	ReadCheckNone ReadCheck = "none"
+
+	// ReadCheckCommitted only returns a config that the member read from
+	// reports as committed to a majority of the members, as
+	// WaitForConfigCommitted does.
+	ReadCheckCommitted ReadCheck = "committed"
+
+	// ReadCheckPrimaryMajority reads from the primary, and only returns
+	// if the primary sees the members holding a majority of the votes as
+	// healthy when it replies. With the config, this is in addition to
+	// the check made by ReadCheckCommitted.
+	ReadCheckPrimaryMajority ReadCheck = "primary-majority"
+)
+
+// ErrConfigNotCommitted is returned when a read of the replica set config
+// requires a majority commitment that the config does not have yet.
+var ErrConfigNotCommitted = errors.New("replica set config not committed")
+
+// Validate returns an error satisfying errors.IsNotValid if the read
+// check is not known. The empty read check is valid and means
+// ReadCheckNone.
+func (rc ReadCheck) Validate() error {
+	switch rc {
+	case "", ReadCheckNone, ReadCheckCommitted, ReadCheckPrimaryMajority:
+		return nil
+	}
+	return errors.NotValidf("read check %q", string(rc))
+}
+
+// CurrentConfigWithCheck is like CurrentConfig but checks the config that
+// was read as required by rc. With ReadCheckCommitted or
+// ReadCheckPrimaryMajority, an error with ErrConfigNotCommitted as its
+// cause is returned if the config has not been committed to a majority of
+// the members.
+func CurrentConfigWithCheck(session *mgo.Session, rc ReadCheck) (*Config, error) {
+	if err := rc.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	switch rc {
+	case "", ReadCheckNone:
+		return CurrentConfig(session)
+	case ReadCheckPrimaryMajority:
+		strongSession := session.Clone()
+		defer strongSession.Close()
+		strongSession.SetMode(mgo.Strong, true)
+		session = strongSession
+	}
+	config, committed, err := getConfigWithCommitment(session)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if committed == nil {
+		committed, err = majorityHasConfigVersion(session, config.Version)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if !*committed {
+		return nil, errors.Annotatef(ErrConfigNotCommitted, "%s read of config version %d", rc, config.Version)
+	}
+	return config, nil
+}
+
+// CurrentStatusWithCheck is like CurrentStatus but checks the status that
+// was read as required by rc. The status is not data that is replicated,
+// so ReadCheckCommitted is not supported for it; with
+// ReadCheckPrimaryMajority the status is read from the primary, with the
+// votes of its members as CurrentStatusWithVoting reports them, and an
+// error is returned unless the primary sees a majority of the votes as
+// healthy.
+func CurrentStatusWithCheck(session *mgo.Session, rc ReadCheck) (*Status, error) {
+	if err := rc.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	switch rc {
+	case ReadCheckCommitted:
+		return nil, errors.NotSupportedf("%s read check for replica set status", rc)
+	case ReadCheckPrimaryMajority:
+		strongSession := session.Clone()
+		defer strongSession.Close()
+		strongSession.SetMode(mgo.Strong, true)
+		status, err := CurrentStatusWithVoting(strongSession)
+		if err != nil && !IsPartialStatus(err) {
+			return nil, err
+		}
+		if err := checkPrimaryHasMajority(status); err != nil {
+			return nil, errors.Annotatef(err, "%s read of replica set status", rc)
+		}
+		return status, err
+	}
+	return CurrentStatus(session)
+}
+
+// checkPrimaryHasMajority returns an error unless the given status was
+// reported by the primary and the healthy members hold a majority of the
+// votes.
+func checkPrimaryHasMajority(status *Status) error {
+	total, healthy := 0, 0
+	self := false
+	for _, member := range status.Members {
+		total += member.Votes
+		if member.Healthy {
+			healthy += member.Votes
+		}
+		if member.Self {
+			if member.State != PrimaryState {
+				return errors.Errorf("status reported by %s member %s", member.State, member.Address)
+			}
+			self = true
+		}
+	}
+	if !self {
+		return errors.New("status does not include the reporting member")
+	}
+	if healthy <= total/2 {
+		return errors.Errorf("primary sees only %d of %d votes as healthy", healthy, total)
+	}
+	return nil
+}
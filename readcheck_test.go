@@ -0,0 +1,103 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2"
+)
+
+func (s *commandSuite) TestCurrentConfigWithCheckInvalid(c *gc.C) {
+	_, err := CurrentConfigWithCheck(nil, "snapshot")
+	c.Check(err, gc.ErrorMatches, `read check "snapshot" not valid`)
+	c.Check(err, jc.Satisfies, errors.IsNotValid)
+}
+
+func (s *commandSuite) TestCurrentConfigWithCheckCommitted(c *gc.C) {
+	committed := false
+	s.PatchValue(&getConfigWithCommitment, func(session *mgo.Session) (*Config, *bool, error) {
+		return &Config{Name: rsName, Version: 3}, &committed, nil
+	})
+	_, err := CurrentConfigWithCheck(nil, ReadCheckCommitted)
+	c.Check(err, gc.ErrorMatches, `committed read of config version 3: replica set config not committed`)
+	c.Check(errors.Cause(err), gc.Equals, ErrConfigNotCommitted)
+
+	committed = true
+	config, err := CurrentConfigWithCheck(nil, ReadCheckCommitted)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(config.Version, gc.Equals, 3)
+}
+
+func (s *commandSuite) TestCurrentConfigWithCheckCommittedOldServer(c *gc.C) {
+	s.PatchValue(&getConfigWithCommitment, func(session *mgo.Session) (*Config, *bool, error) {
+		return &Config{Name: rsName, Version: 3}, nil, nil
+	})
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return &Status{Members: []MemberStatus{
+			{Id: 1, Healthy: true, ConfigVersion: 3},
+			{Id: 2, Healthy: true, ConfigVersion: 2},
+			{Id: 3, Healthy: false},
+		}}, nil
+	})
+	_, err := CurrentConfigWithCheck(nil, ReadCheckCommitted)
+	c.Check(errors.Cause(err), gc.Equals, ErrConfigNotCommitted)
+}
+
+func (s *commandSuite) TestCurrentStatusWithCheckCommitted(c *gc.C) {
+	_, err := CurrentStatusWithCheck(nil, ReadCheckCommitted)
+	c.Check(err, gc.ErrorMatches, `committed read check for replica set status not supported`)
+	c.Check(err, jc.Satisfies, errors.IsNotSupported)
+}
+
+func (s *partialStatusSuite) TestCheckPrimaryHasMajority(c *gc.C) {
+	for i, test := range []struct {
+		about   string
+		members []MemberStatus
+		err     string
+	}{{
+		about: "primary with majority",
+		members: []MemberStatus{
+			{Address: "a", Self: true, Healthy: true, State: PrimaryState, Votes: 1},
+			{Address: "b", Healthy: true, State: SecondaryState, Votes: 1},
+			{Address: "c", Healthy: false, State: DownState, Votes: 1},
+		},
+	}, {
+		about: "primary without majority",
+		members: []MemberStatus{
+			{Address: "a", Self: true, Healthy: true, State: PrimaryState, Votes: 1},
+			{Address: "b", Healthy: false, State: DownState, Votes: 1},
+			{Address: "c", Healthy: true, State: SecondaryState, Votes: 0},
+		},
+		err: `primary sees only 1 of 2 votes as healthy`,
+	}, {
+		about: "reported by a secondary",
+		members: []MemberStatus{
+			{Address: "a", Healthy: true, State: PrimaryState, Votes: 1},
+			{Address: "b", Self: true, Healthy: true, State: SecondaryState, Votes: 1},
+		},
+		err: `status reported by SECONDARY member b`,
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		err := checkPrimaryHasMajority(&Status{Members: test.members})
+		if test.err == "" {
+			c.Check(err, jc.ErrorIsNil)
+		} else {
+			c.Check(err, gc.ErrorMatches, test.err)
+		}
+	}
+}
+
+func (s *commandSuite) TestCurrentStatusWithCheckPrimaryMajority(c *gc.C) {
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return &Status{Members: []MemberStatus{
+			{Id: 1, Address: "1.2.3.4:37017", Self: true, Healthy: true, State: PrimaryState},
+		}}, nil
+	})
+	status, err := CurrentStatusWithCheck(nil, ReadCheckPrimaryMajority)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(status.Members, gc.HasLen, 1)
+	c.Check(status.Members[0].Votes, gc.Equals, 1)
+}
@@ -6,10 +6,14 @@
 package replicaset
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -39,6 +43,22 @@ const (
 	// initiateAttemptStatusDelay is the amount of time to sleep between failed
 	// attempts to replSetGetStatus.
 	initiateAttemptStatusDelay = 500 * time.Millisecond
+
+	// configVersionAttemptDelay is the amount of time to sleep between
+	// checks of the members' config versions in WaitForConfigVersion.
+	configVersionAttemptDelay = 500 * time.Millisecond
+
+	// writableAttemptDelay is the amount of time to sleep between checks
+	// of whether the replica set is writable.
+	writableAttemptDelay = 500 * time.Millisecond
+
+	// memberStateAttemptDelay is the amount of time to sleep between
+	// checks of a member's state while waiting for it to change.
+	memberStateAttemptDelay = 500 * time.Millisecond
+
+	// clearHoldsTimeout is the timeout used when dialing and running
+	// commands on each member in ClearHolds.
+	clearHoldsTimeout = 10 * time.Second
 )
 
 var logger = loggo.GetLogger("juju.replicaset")
@@ -46,15 +66,50 @@ var logger = loggo.GetLogger("juju.replicaset")
 var (
 	getCurrentStatus = CurrentStatus
 	isReady          = IsReady
+	isWritable       = IsWritable
+	stepDownPrimary  = StepDownPrimary
+
+	getConfigWithCommitment = CurrentConfigWithCommitment
 )
 
+// runCommand runs the given command against the admin database of the
+// given session, unmarshalling the reply into result. All replica set
+// commands go through it, so that tests can patch it to check the
+// commands sent and to simulate replies and errors without a server.
+var runCommand = func(session Runner, cmd interface{}, result interface{}) error {
+	return session.Run(cmd, result)
+}
+
+// ErrAlreadyInitiated is returned by Initiate when the replica set has
+// already been initiated.
+var ErrAlreadyInitiated = errors.New("replica set already initiated")
+
+// alreadyInitializedCode is the code of the error returned by mongo when
+// replSetInitiate is run against an initiated replica set.
+const alreadyInitializedCode = 23
+
+// isAlreadyInitialized reports whether the given error was returned by
+// replSetInitiate because the replica set is already initiated.
+func isAlreadyInitialized(err error) bool {
+	if queryErr, ok := errors.Cause(err).(*mgo.QueryError); ok && queryErr.Code == alreadyInitializedCode {
+		return true
+	}
+	return err != nil && strings.Contains(err.Error(), "already initialized")
+}
+
 // attemptInitiate will attempt to initiate a mongodb replicaset with each of
-// the given configs, returning as soon as one config is successful.
+// the given configs, returning as soon as one config is successful. It
+// returns ErrAlreadyInitiated as soon as mongo reports that the replica set
+// is already initiated.
 func attemptInitiate(monotonicSession *mgo.Session, cfg []Config) error {
 	var err error
 	for _, c := range cfg {
 		logger.Infof("Initiating replicaset with config: %s", fmtConfigForLog(&c))
-		if err = monotonicSession.Run(bson.D{{"replSetInitiate", c}}, nil); err != nil {
+		if err = runCommand(monotonicSession, bson.D{{"replSetInitiate", c}}, nil); err != nil {
+			if isAlreadyInitialized(err) {
+				return ErrAlreadyInitiated
+			}
+			err = errors.Annotatef(err, "replSetInitiate of replica set %q", c.Name)
 			logger.Infof("Unsuccessful attempt to initiate replicaset: %v", err)
 			continue
 		}
@@ -71,12 +126,58 @@ func attemptInitiate(monotonicSession *mgo.Session, cfg []Config) error {
 // Note that you must set DialWithInfo and set Direct = true when dialing into a
 // specific non-initiated mongo server.
 //
+// If the replica set has already been initiated, ErrAlreadyInitiated is
+// returned and the existing config is left untouched.
+//
 // See http://docs.mongodb.org/manual/reference/method/rs.initiate/ for more
 // details.
 func Initiate(session *mgo.Session, address, name string, tags map[string]string) error {
 	monotonicSession := session.Clone()
 	defer monotonicSession.Close()
 	monotonicSession.SetMode(mgo.Monotonic, true)
+
+	err := initiate(monotonicSession, address, name, tags)
+	if err == ErrAlreadyInitiated {
+		return err
+	}
+
+	// Wait for replSetInitiate to complete. Even if err != nil,
+	// it may be that replSetInitiate is still in progress, so
+	// attempt CurrentStatus.
+	for i := 0; i < maxInitiateStatusAttempts; i++ {
+		monotonicSession.Refresh()
+		var status *Status
+		status, err = getCurrentStatus(monotonicSession)
+		if IsPartialStatus(err) {
+			err = nil
+		}
+		if err != nil {
+			logger.Warningf("Initiate: fetching replication status failed: %v", err)
+		}
+		if err != nil || len(status.Members) == 0 {
+			time.Sleep(initiateAttemptStatusDelay)
+			continue
+		}
+		break
+	}
+	return err
+}
+
+// InitiateNoWait is like Initiate, but returns as soon as replSetInitiate
+// has been accepted, without waiting for the replica set status to become
+// available. Callers that need a primary must wait for it themselves, for
+// instance with WaitUntilReady.
+func InitiateNoWait(session *mgo.Session, address, name string, tags map[string]string) error {
+	monotonicSession := session.Clone()
+	defer monotonicSession.Close()
+	monotonicSession.SetMode(mgo.Monotonic, true)
+
+	return initiate(monotonicSession, address, name, tags)
+}
+
+// initiate issues replSetInitiate for a single member replica set,
+// retrying up to maxInitiateAttempts times.
+func initiate(monotonicSession *mgo.Session, address, name string, tags map[string]string) error {
 	// We don't know mongod's ability to use a correct IPv6 addr format
 	// until the server is started, but we need to know before we can start
 	// it. Try the older, incorrect format, if the correct format fails.
@@ -107,25 +208,12 @@ func Initiate(session *mgo.Session, address, name string, tags map[string]string
 	// Attempt replSetInitiate, with potential retries.
 	for i := 0; i < maxInitiateAttempts; i++ {
 		monotonicSession.Refresh()
-		if err = attemptInitiate(monotonicSession, cfg); err != nil {
-			time.Sleep(initiateAttemptDelay)
-			continue
+		err = attemptInitiate(monotonicSession, cfg)
+		if err == ErrAlreadyInitiated {
+			return err
 		}
-		break
-	}
-
-	// Wait for replSetInitiate to complete. Even if err != nil,
-	// it may be that replSetInitiate is still in progress, so
-	// attempt CurrentStatus.
-	for i := 0; i < maxInitiateStatusAttempts; i++ {
-		monotonicSession.Refresh()
-		var status *Status
-		status, err = getCurrentStatus(monotonicSession)
 		if err != nil {
-			logger.Warningf("Initiate: fetching replication status failed: %v", err)
-		}
-		if err != nil || len(status.Members) == 0 {
-			time.Sleep(initiateAttemptStatusDelay)
+			time.Sleep(initiateAttemptDelay)
 			continue
 		}
 		break
@@ -169,11 +257,113 @@ type Member struct {
 	// SlaveDelay describes the number of seconds behind the master that this
 	// replica set member should lag rounded up to the nearest second.
 	// This value is optional; it defaults to 0.
+	//
+	// MongoDB 5.0 renamed this field to secondaryDelaySecs; either name is
+	// accepted when parsing, and the name used when sending a config is
+	// chosen according to the server version (see delayFieldName).
 	SlaveDelay *time.Duration `bson:"slaveDelay,omitempty"`
 
 	// Votes controls the number of votes a server has in a replica set election.
 	// This value is optional; it defaults to 1.
 	Votes *int `bson:"votes,omitempty"`
+
+	// NewlyAdded holds whether MongoDB 4.4 or later considers the member
+	// newly added: it has been added but has not finished its initial
+	// sync, so it does not vote or count towards the majority yet. It is
+	// set by the server and never sent back to it; it is nil when the
+	// server does not report it.
+	NewlyAdded *bool `bson:"-"`
+
+	// Extra holds the member fields that are not modelled by the fields
+	// above, such as those added by newer versions of mongo, so that they
+	// are preserved when the config is written back.
+	Extra bson.M `bson:",inline"`
+
+	// delayField holds the name of the field used for SlaveDelay when
+	// serializing the member. When empty, slaveDelayField is used.
+	delayField string
+}
+
+const (
+	// slaveDelayField is the name of the member delay field used by
+	// MongoDB before 5.0.
+	slaveDelayField = "slaveDelay"
+
+	// secondaryDelayField is the name of the member delay field used by
+	// MongoDB 5.0 and later.
+	secondaryDelayField = "secondaryDelaySecs"
+
+	// newlyAddedField is the name of the member field that MongoDB 4.4
+	// and later set on members that have not finished their initial sync.
+	newlyAddedField = "newlyAdded"
+)
+
+// delayFieldName returns the name of the member delay field understood by
+// the server with the given build info.
+func delayFieldName(buildInfo mgo.BuildInfo) string {
+	if featureSupported(buildInfo, FeatureSecondaryDelaySecs) {
+		return secondaryDelayField
+	}
+	return slaveDelayField
+}
+
+// plainMember has the same fields as Member but none of its methods, so it
+// can be marshalled and unmarshalled with the default bson behaviour.
+type plainMember Member
+
+// GetBSON implements bson.Getter, renaming the delay field as required by
+// the server the member is being sent to.
+func (m Member) GetBSON() (interface{}, error) {
+	if m.delayField == "" || m.delayField == slaveDelayField {
+		return plainMember(m), nil
+	}
+	data, err := bson.Marshal(plainMember(m))
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	for i := range doc {
+		if doc[i].Name == slaveDelayField {
+			doc[i].Name = m.delayField
+		}
+	}
+	return doc, nil
+}
+
+// SetBSON implements bson.Setter, accepting either name of the delay field
+// and remembering which one was used, so that the member is written back
+// with the same name.
+func (m *Member) SetBSON(raw bson.Raw) error {
+	var member plainMember
+	if err := raw.Unmarshal(&member); err != nil {
+		return err
+	}
+	var delay struct {
+		SecondaryDelay *time.Duration `bson:"secondaryDelaySecs,omitempty"`
+	}
+	if err := raw.Unmarshal(&delay); err != nil {
+		return err
+	}
+	if member.SlaveDelay == nil && delay.SecondaryDelay != nil {
+		member.SlaveDelay = delay.SecondaryDelay
+		member.delayField = secondaryDelayField
+	}
+	// The delay is modelled by SlaveDelay whatever its name.
+	delete(member.Extra, secondaryDelayField)
+	// The newlyAdded flag is managed by the server, which rejects
+	// reconfigs that include it, so it is kept out of Extra.
+	if newlyAdded, ok := member.Extra[newlyAddedField].(bool); ok {
+		member.NewlyAdded = &newlyAdded
+	}
+	delete(member.Extra, newlyAddedField)
+	if len(member.Extra) == 0 {
+		member.Extra = nil
+	}
+	*m = Member(member)
+	return nil
 }
 
 // fmtConfigForLog generates a succinct string suitable for debugging what the Members are up to.
@@ -208,12 +398,40 @@ func fmtConfigForLog(config *Config) string {
 // connection to be dropped. If so, it Refreshes the session and tries to Ping
 // again.
 func applyReplSetConfig(cmd string, session *mgo.Session, oldconfig, newconfig *Config) error {
+	_, err := applyReplSetConfigWithWarnings(cmd, session, oldconfig, newconfig, ReconfigOptions{})
+	return err
+}
+
+// reconfigResult holds the parts of the reply to replSetReconfig that
+// carry non-fatal messages.
+type reconfigResult struct {
+	Warnings []string `bson:"warnings"`
+	Warning  string   `bson:"warning"`
+	Info     string   `bson:"info"`
+}
+
+// messages returns all the messages in the result.
+func (r reconfigResult) messages() []string {
+	messages := append([]string(nil), r.Warnings...)
+	for _, message := range []string{r.Warning, r.Info} {
+		if message != "" {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// applyReplSetConfigWithWarnings is like applyReplSetConfig, but also
+// returns any warnings that mongo included in its reply to
+// replSetReconfig. The warnings are logged too. If opts.MaxTime is not
+// zero, it is passed to replSetReconfig as maxTimeMS.
+func applyReplSetConfigWithWarnings(cmd string, session *mgo.Session, oldconfig, newconfig *Config, opts ReconfigOptions) ([]string, error) {
 	logger.Debugf("%s() changing replica set\nfrom %s\nto %s",
 		cmd, fmtConfigForLog(oldconfig), fmtConfigForLog(newconfig))
 
-	buildInfo, err := session.BuildInfo()
+	buildInfo, err := getBuildInfo(session)
 	if err != nil {
-		return err
+		return nil, errors.Annotatef(err, "%s: buildInfo", cmd)
 	}
 	// https://jira.mongodb.org/browse/SERVER-5436
 	if !buildInfo.VersionAtLeast(2, 7, 4) {
@@ -224,28 +442,376 @@ func applyReplSetConfig(cmd string, session *mgo.Session, oldconfig, newconfig *
 				newconfig.Members[index].Address)
 		}
 	}
-	err = session.Run(bson.D{{"replSetReconfig", newconfig}}, nil)
+	// The members may be shared with the caller, so copy them before
+	// choosing the delay field name.
+	newconfig.Members = append([]Member(nil), newconfig.Members...)
+	delayField := delayFieldName(buildInfo)
+	for index := range newconfig.Members {
+		newconfig.Members[index].delayField = delayField
+	}
+	reconfig := bson.D{{"replSetReconfig", newconfig}}
+	if opts.Force {
+		if err := checkForceReconfig(session, oldconfig, newconfig); err != nil {
+			return nil, errors.Annotate(err, cmd)
+		}
+		reconfig = append(reconfig, bson.DocElem{"force", true})
+	}
+	if opts.MaxTime > 0 {
+		reconfig = append(reconfig, bson.DocElem{"maxTimeMS", int64(opts.MaxTime / time.Millisecond)})
+	}
+	var result reconfigResult
+	err = runCommand(session, reconfig, &result)
 	if err == io.EOF {
 		// If the primary changes due to replSetReconfig, then all
 		// current connections are dropped.
 		// Refreshing should fix us up.
 		logger.Debugf("got EOF while running %s(), calling session.Refresh()", cmd)
 		session.Refresh()
+	} else if isMaxTimeExpired(err) {
+		return nil, errors.Annotate(reconfigTimeoutError(session, newconfig.Version, err), cmd)
 	} else if err != nil {
 		// For all errors that aren't EOF, return immediately
-		return err
+		return nil, errors.Annotatef(err, "%s: replSetReconfig of replica set %q to version %d",
+			cmd, newconfig.Name, newconfig.Version)
+	}
+	warnings := result.messages()
+	for _, warning := range warnings {
+		logger.Warningf("%s(): replSetReconfig: %s", cmd, warning)
+	}
+	if even, warning := newconfig.VotingParityWarning(); even {
+		logger.Warningf("%s(): %s", cmd, warning)
+		warnings = append(warnings, warning)
 	}
 	err = nil
 	// We will only try to Ping 2 times
 	for i := 0; i < 2; i++ {
 		// err was either nil, or EOF and we called Refresh, so Ping to
 		// make sure we're actually connected
-		err = session.Ping()
+		err = runCommand(session, "ping", nil)
 		if err == nil {
 			break
 		}
 	}
-	return err
+	return warnings, errors.Annotatef(err, "%s: ping after replSetReconfig", cmd)
+}
+
+// ErrUnsafeForceReconfig is the cause of the error returned when a forced
+// reconfig is refused because it could split the replica set.
+var ErrUnsafeForceReconfig = errors.New("unsafe forced reconfig")
+
+// ForceReconfigError is returned when a forced reconfig is refused because
+// it could split the replica set. Its cause is ErrUnsafeForceReconfig.
+type ForceReconfigError struct {
+	// Reason describes why the reconfig is unsafe.
+	Reason string
+}
+
+// Error implements error.
+func (e *ForceReconfigError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrUnsafeForceReconfig, e.Reason)
+}
+
+// Cause returns ErrUnsafeForceReconfig, so that errors.Cause can be used
+// to check for it.
+func (e *ForceReconfigError) Cause() error {
+	return ErrUnsafeForceReconfig
+}
+
+// checkForceReconfig checks that forcing the replica set from oldconfig to
+// newconfig cannot split it, according to the status seen by the
+// session's member, returning a *ForceReconfigError if it could.
+func checkForceReconfig(session *mgo.Session, oldconfig, newconfig *Config) error {
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return errors.Annotate(err, "cannot check forced reconfig")
+	}
+	return errors.Trace(forceReconfigRisk(status, oldconfig, newconfig))
+}
+
+// forceReconfigRisk returns a *ForceReconfigError if forcing the replica
+// set with the given status from oldconfig to newconfig could split it.
+func forceReconfigRisk(status *Status, oldconfig, newconfig *Config) error {
+	healthy := make(map[string]bool)
+	for _, member := range status.Members {
+		if !member.Healthy {
+			continue
+		}
+		if member.State == PrimaryState {
+			return &ForceReconfigError{
+				Reason: fmt.Sprintf("%s is primary; use a normal reconfig", member.Address),
+			}
+		}
+		healthy[member.Address] = true
+	}
+	var excluded []string
+	for _, member := range oldconfig.Members {
+		addr := formatIPv6AddressWithBrackets(member.Address)
+		if healthy[addr] && findMember(newconfig.Members, addr) < 0 {
+			excluded = append(excluded, addr)
+		}
+	}
+	if len(excluded) > 0 {
+		sort.Strings(excluded)
+		return &ForceReconfigError{
+			Reason: fmt.Sprintf("members %s are up but left out of the new config", strings.Join(excluded, ", ")),
+		}
+	}
+	votes, healthyVotes := 0, 0
+	for _, member := range newconfig.Members {
+		memberVotes := memberVotes(member)
+		votes += memberVotes
+		if healthy[formatIPv6AddressWithBrackets(member.Address)] {
+			healthyVotes += memberVotes
+		}
+	}
+	if healthyVotes <= votes/2 {
+		return &ForceReconfigError{
+			Reason: fmt.Sprintf("members that are up hold %d of the %d votes in the new config", healthyVotes, votes),
+		}
+	}
+	return nil
+}
+
+// maxTimeExpiredCode is the code of the error returned by mongo when a
+// command is aborted because it ran for longer than its maxTimeMS.
+const maxTimeExpiredCode = 50
+
+// isMaxTimeExpired reports whether the given error was returned by mongo
+// because a command ran for longer than its maxTimeMS.
+func isMaxTimeExpired(err error) bool {
+	queryError, ok := errors.Cause(err).(*mgo.QueryError)
+	return ok && queryError.Code == maxTimeExpiredCode
+}
+
+// ReconfigTimeoutError is returned when replSetReconfig is aborted by
+// mongo because it took longer than ReconfigOptions.MaxTime. The config
+// is read again after the abort, to find out whether the reconfig took
+// effect regardless. Use errors.Cause to get it from a returned error.
+type ReconfigTimeoutError struct {
+	// Version holds the version of the config that was being applied.
+	Version int
+
+	// Applied reports whether the current config had reached Version
+	// when it was read after the abort.
+	Applied bool
+
+	// Err holds the error returned by replSetReconfig.
+	Err error
+}
+
+// Error implements error.
+func (e *ReconfigTimeoutError) Error() string {
+	if e.Applied {
+		return fmt.Sprintf("replSetReconfig to version %d timed out but was applied: %v", e.Version, e.Err)
+	}
+	return fmt.Sprintf("replSetReconfig to version %d timed out and was not applied: %v", e.Version, e.Err)
+}
+
+// reconfigTimeoutError reads the current config of the session's replica
+// set and returns a *ReconfigTimeoutError reporting whether the config
+// with the given version took effect despite the given timeout error. If
+// the config cannot be read, an error saying so is returned instead.
+func reconfigTimeoutError(session *mgo.Session, version int, err error) error {
+	config, readErr := CurrentConfig(session)
+	if readErr != nil {
+		return errors.Annotatef(err, "replSetReconfig to version %d timed out and the config cannot be read to check whether it was applied (%v)", version, readErr)
+	}
+	return &ReconfigTimeoutError{
+		Version: version,
+		Applied: config.Version >= version,
+		Err:     err,
+	}
+}
+
+// ReconfigOptions holds options that change the behaviour of the functions
+// that reconfigure the replica set, such as AddWithOptions and
+// SetWithOptions. The zero value gives the same behaviour as Add and Set.
+type ReconfigOptions struct {
+	// ResolveAddresses, if true, causes the host of each given member
+	// address to be looked up before the reconfig is applied, failing if
+	// any of them cannot be resolved. Addresses holding an IP address are
+	// not looked up.
+	ResolveAddresses bool
+
+	// ResolveTimeout holds the maximum amount of time spent resolving
+	// addresses. If zero, defaultResolveTimeout is used.
+	ResolveTimeout time.Duration
+
+	// RejectMixedAddresses, if true, causes a new config that has both
+	// IPv4 and IPv6 member addresses, or IPv6 addresses without brackets,
+	// to be rejected with an error satisfying errors.IsNotValid. Such
+	// configs confuse some clients, but existing replica sets may rely on
+	// them, so they are allowed by default.
+	RejectMixedAddresses bool
+
+	// WaitWritable, if true, causes the function to wait after the
+	// reconfig has been applied until the replica set is writable, as
+	// reported by IsWritable. A reconfig may cause the primary to step
+	// down briefly, so this ensures that the caller can write as soon as
+	// the function returns.
+	WaitWritable bool
+
+	// WaitCommitted, if true, causes the function to wait after the
+	// reconfig has been applied until the new config has been committed
+	// to a majority of the members, as WaitForConfigCommitted does, so
+	// that another reconfig can safely follow.
+	WaitCommitted bool
+
+	// WaitTimeout holds the maximum amount of time spent in each of the
+	// waits requested by WaitCommitted and WaitWritable. If zero,
+	// defaultWaitWritableTimeout is used.
+	WaitTimeout time.Duration
+
+	// MaxTime, if not zero, limits how long mongo may spend applying the
+	// reconfig; it is passed to replSetReconfig as maxTimeMS. If mongo
+	// aborts the reconfig because it took too long, the config is read
+	// again and a *ReconfigTimeoutError reporting whether the reconfig
+	// took effect anyway is returned.
+	MaxTime time.Duration
+
+	// Force, if true, causes the reconfig to be forced, which mongo allows
+	// on a secondary when there is no primary, for instance to recover
+	// from the loss of a majority of the members by removing the lost
+	// ones. The session should be a direct session to a surviving member.
+	//
+	// Forcing a reconfig while the members it leaves out are still
+	// running can leave the replica set with two primaries, so it is
+	// refused with a *ForceReconfigError if, as seen by the session's
+	// member, there is a primary, a member left out of the new config is
+	// up, or the members that are up do not hold a majority of the votes
+	// in the new config. Members that cannot be reached from the
+	// session's member are assumed to be down; the caller must make sure
+	// that they are.
+	Force bool
+
+	// KeepMemberSettings, if true, causes the members given to
+	// SetWithOptions that are already in the replica set to keep the
+	// settings they are given no value for: their priority, votes,
+	// hidden, arbiterOnly, buildIndexes and delay settings, their tags
+	// and any fields not modelled by Member. To reset a setting to its
+	// default, give its default value explicitly; to remove all tags,
+	// give an empty map. By default, as with Set, the members are applied
+	// as given.
+	KeepMemberSettings bool
+
+	// ExpectedName, if not empty, holds the name that the session's
+	// replica set must have. If it has another name, no reconfig is
+	// attempted and an error with a cause of ErrReplicaSetNameMismatch is
+	// returned. This guards against reconfiguring the wrong replica set.
+	ExpectedName string
+
+	// RequiredTags holds tag keys that every member given to the function,
+	// other than arbiters, must have; members missing any of them are
+	// rejected with an error satisfying errors.IsNotValid.
+	RequiredTags []string
+
+	// Progress, if not nil, is called each time the replica set is polled
+	// during the waits requested by WaitCommitted and WaitWritable.
+	Progress ProgressFunc
+}
+
+// defaultResolveTimeout is the default value of
+// ReconfigOptions.ResolveTimeout.
+const defaultResolveTimeout = 10 * time.Second
+
+// defaultWaitWritableTimeout is the default value of
+// ReconfigOptions.WaitTimeout.
+const defaultWaitWritableTimeout = time.Minute
+
+// lookupHost is used to resolve member addresses.
+var lookupHost = net.DefaultResolver.LookupHost
+
+// check checks that the given members, which are about to be part of a
+// reconfig, satisfy the options.
+func (opts ReconfigOptions) check(members []Member) error {
+	if missing := membersMissingTags(members, opts.RequiredTags); len(missing) > 0 {
+		return errors.NotValidf("members %s without required tags %s",
+			strings.Join(missing, ", "), strings.Join(opts.RequiredTags, ", "))
+	}
+	if opts.ResolveAddresses {
+		timeout := opts.ResolveTimeout
+		if timeout == 0 {
+			timeout = defaultResolveTimeout
+		}
+		if err := resolveAddresses(members, timeout); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// checkNewlyAdded returns an error satisfying errors.IsNotValid if any of
+// the given members is marked as newly added without being so in the
+// current members. The flag is managed by the server, so it can only be
+// carried over from the current config, in which case it is ignored.
+func checkNewlyAdded(current, members []Member) error {
+	newlyAdded := make(map[string]bool)
+	for _, member := range current {
+		if member.NewlyAdded != nil && *member.NewlyAdded {
+			newlyAdded[member.Address] = true
+		}
+	}
+	for _, member := range members {
+		if member.NewlyAdded != nil && *member.NewlyAdded && !newlyAdded[member.Address] {
+			return errors.NotValidf("setting newlyAdded on member %q", member.Address)
+		}
+	}
+	return nil
+}
+
+// checkConfig checks that the given config, which is about to be applied,
+// satisfies the options.
+func (opts ReconfigOptions) checkConfig(config *Config) error {
+	if !opts.RejectMixedAddresses {
+		return nil
+	}
+	return errors.Trace(checkAddressFamilies(config.Members))
+}
+
+// wait waits for the config to be committed and for the replica set to be
+// writable after a reconfig, as required by the options.
+func (opts ReconfigOptions) wait(session *mgo.Session) error {
+	timeout := opts.WaitTimeout
+	if timeout == 0 {
+		timeout = defaultWaitWritableTimeout
+	}
+	if opts.WaitCommitted {
+		if err := waitForConfigCommitted(session, timeout, opts.Progress); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if opts.WaitWritable {
+		if err := waitUntilWritable(session, timeout, opts.Progress); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// resolveAddresses looks up the host of each of the member addresses,
+// returning an error listing those that cannot be resolved within the
+// given timeout.
+func resolveAddresses(members []Member, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var unresolvable []string
+	for _, member := range members {
+		host, _, err := net.SplitHostPort(member.Address)
+		if err != nil {
+			unresolvable = append(unresolvable, fmt.Sprintf("%s (%v)", member.Address, err))
+			continue
+		}
+		if net.ParseIP(host) != nil {
+			continue
+		}
+		if _, err := lookupHost(ctx, host); err != nil {
+			unresolvable = append(unresolvable, fmt.Sprintf("%s (%v)", member.Address, err))
+		}
+	}
+	if len(unresolvable) > 0 {
+		return errors.Errorf("cannot resolve member addresses: %s", strings.Join(unresolvable, ", "))
+	}
+	return nil
 }
 
 // Add adds the given members to the session's replica set.  Duplicates of
@@ -253,11 +819,26 @@ func applyReplSetConfig(cmd string, session *mgo.Session, oldconfig, newconfig *
 //
 // Members will have their Ids set automatically if they are not already > 0
 func Add(session *mgo.Session, members ...Member) error {
+	return AddWithOptions(session, ReconfigOptions{}, members...)
+}
+
+// AddWithOptions is like Add but also takes options that change its
+// behaviour.
+func AddWithOptions(session *mgo.Session, opts ReconfigOptions, members ...Member) error {
+	if err := opts.check(members); err != nil {
+		return err
+	}
 	config, err := CurrentConfig(session)
 	if err != nil {
 		return err
 	}
+	if err := checkReplicaSetName(config, opts.ExpectedName); err != nil {
+		return err
+	}
 
+	if err := checkNewlyAdded(config.Members, members); err != nil {
+		return err
+	}
 	oldconfig := *config
 	config.Version++
 	max := findMaxId(config.Members, members)
@@ -277,63 +858,529 @@ outerLoop:
 		}
 		config.Members = append(config.Members, newMember)
 	}
-	return applyReplSetConfig("Add", session, &oldconfig, config)
+	if err := opts.checkConfig(config); err != nil {
+		return err
+	}
+	if _, err := applyReplSetConfigWithWarnings("Add", session, &oldconfig, config, opts); err != nil {
+		return err
+	}
+	return opts.wait(session)
 }
 
-// Remove removes members with the given addresses from the replica set. It is
-// not an error to remove addresses of non-existent replica set members.
-func Remove(session *mgo.Session, addrs ...string) error {
+// Replace removes the members with the given addresses from the session's
+// replica set and adds the given members, in a single reconfig. Members
+// that are not removed keep their Ids, and the added members have their
+// Ids set as they are with Add. Members to add whose address is already
+// present, and not removed, are skipped.
+//
+// Members that are added must complete an initial sync before they can
+// acknowledge writes, so Replace fails without reconfiguring the replica
+// set unless the healthy data-bearing members that remain hold a majority
+// of the votes in the new config.
+func Replace(session *mgo.Session, remove []string, add []Member) error {
 	config, err := CurrentConfig(session)
 	if err != nil {
 		return err
 	}
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return errors.Trace(err)
+	}
+	members := replaceMembers(config.Members, remove, add)
+	if err := checkWritableMajority(status, members); err != nil {
+		return errors.Trace(err)
+	}
 	oldconfig := *config
 	config.Version++
-	for _, rem := range addrs {
-		for n, repl := range config.Members {
-			if repl.Address == rem {
-				config.Members = append(config.Members[:n], config.Members[n+1:]...)
-				break
-			}
+	config.Members = members
+	return applyReplSetConfig("Replace", session, &oldconfig, config)
+}
+
+// replaceMembers returns the current members without those with the given
+// addresses to remove, followed by the members to add that are not already
+// present. Added members without an Id are given one above the highest
+// one in use.
+func replaceMembers(current []Member, remove []string, add []Member) []Member {
+	removed := make(map[string]bool)
+	for _, addr := range remove {
+		removed[formatIPv6AddressWithBrackets(addr)] = true
+	}
+	var members []Member
+	present := make(map[string]bool)
+	for _, member := range current {
+		addr := formatIPv6AddressWithBrackets(member.Address)
+		if removed[addr] {
+			continue
 		}
+		members = append(members, member)
+		present[addr] = true
 	}
-	return applyReplSetConfig("Remove", session, &oldconfig, config)
+	max := findMaxId(current, add)
+	for _, member := range add {
+		addr := formatIPv6AddressWithBrackets(member.Address)
+		if present[addr] {
+			continue
+		}
+		if member.Id < 1 {
+			max++
+			member.Id = max
+		}
+		members = append(members, member)
+		present[addr] = true
+	}
+	return members
 }
 
-// findMaxId looks through both sets of members and makes sure we cannot reuse an Id value
-func findMaxId(oldMembers, newMembers []Member) int {
-	max := 0
-	for _, m := range oldMembers {
-		if m.Id > max {
-			max = m.Id
+// checkWritableMajority returns an error unless the members in the given
+// status that are healthy, are not arbiters and are kept in the given new
+// members hold a majority of the votes of the new members.
+func checkWritableMajority(status *Status, members []Member) error {
+	healthy := make(map[int]bool)
+	for _, member := range status.Members {
+		if member.Healthy && member.State != ArbiterState {
+			healthy[member.Id] = true
 		}
 	}
-	// Also check if any of the members being passed in already have an Id that we would be reusing.
-	for _, m := range newMembers {
-		if m.Id > max {
-			max = m.Id
+	counts := voteCounts{}
+	for _, member := range members {
+		votes := memberVotes(member)
+		counts.total += votes
+		if healthy[member.Id] && (member.Arbiter == nil || !*member.Arbiter) {
+			counts.healthyData += votes
 		}
 	}
-	return max
+	if counts.healthyData < counts.majority() {
+		return errors.Errorf("new config would leave %d of %d votes on healthy data-bearing members, need %d",
+			counts.healthyData, counts.total, counts.majority())
+	}
+	return nil
 }
 
-// Set changes the current set of replica set members.  Members will have their
-// ids set automatically if their ids are not already > 0.
-func Set(session *mgo.Session, members []Member) error {
+// AddIfMissing adds the given member to the session's replica set unless a
+// member with the same address is already present, returning whether it
+// was added. Unlike Add, it does not reconfigure the replica set when there
+// is nothing to add, so the config version is left unchanged.
+func AddIfMissing(session *mgo.Session, member Member) (bool, error) {
+	members, err := CurrentMembersMap(session)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := members[formatIPv6AddressWithBrackets(member.Address)]; ok {
+		return false, nil
+	}
+	if err := Add(session, member); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// AddArbiter adds an arbiter with the given address to the session's
+// replica set: a member with arbiterOnly set, one vote and a priority of
+// 0. It fails with an error satisfying errors.IsAlreadyExists if a member
+// with the address is already present, and with an error satisfying
+// errors.IsNotValid if the arbiter would leave the replica set with an
+// even number of voting members, as arbiters are only useful to break
+// ties.
+func AddArbiter(session *mgo.Session, addr string) error {
+	arbiter, err := NewMember(addr, AsArbiter(), WithVotes(1))
+	if err != nil {
+		return errors.Trace(err)
+	}
 	config, err := CurrentConfig(session)
 	if err != nil {
 		return err
 	}
+	if findMember(config.Members, formatIPv6AddressWithBrackets(addr)) >= 0 {
+		return errors.AlreadyExistsf("replica set member %q", addr)
+	}
+	next := *config
+	next.Members = append(append([]Member(nil), config.Members...), arbiter)
+	if even, _ := next.VotingParityWarning(); even {
+		return errors.NotValidf("arbiter %q with an even number of voting members", addr)
+	}
+	return Add(session, arbiter)
+}
+
+// Remove removes members with the given addresses from the replica set. It is
+// not an error to remove addresses of non-existent replica set members.
+func Remove(session *mgo.Session, addrs ...string) error {
+	return RemoveWithOptions(session, ReconfigOptions{}, addrs...)
+}
+
+// RemoveWithOptions is like Remove but also takes options that change its
+// behaviour. Removing members cannot introduce new addresses, so the
+// address checks of the options do not apply.
+func RemoveWithOptions(session *mgo.Session, opts ReconfigOptions, addrs ...string) error {
+	remove := make(map[string]bool)
+	for _, addr := range addrs {
+		remove[addr] = true
+	}
+	return removeMembers("Remove", session, opts, func(m Member) bool {
+		return remove[m.Address]
+	})
+}
+
+// RemoveById removes the members with the given Ids from the replica set.
+// Unlike Remove, it does not depend on the member addresses, which may
+// have changed or be shared by several members. It is not an error to
+// remove Ids of non-existent replica set members.
+func RemoveById(session *mgo.Session, ids ...int) error {
+	remove := make(map[int]bool)
+	for _, id := range ids {
+		remove[id] = true
+	}
+	return removeMembers("RemoveById", session, ReconfigOptions{}, func(m Member) bool {
+		return remove[m.Id]
+	})
+}
+
+// removeMembers removes the members for which remove returns true from the
+// replica set.
+func removeMembers(cmd string, session *mgo.Session, opts ReconfigOptions, remove func(Member) bool) error {
+	config, err := CurrentConfig(session)
+	if err != nil {
+		return err
+	}
+	if err := checkReplicaSetName(config, opts.ExpectedName); err != nil {
+		return err
+	}
+	oldconfig := *config
+	config.Version++
+	config.Members = nil
+	for _, member := range oldconfig.Members {
+		if !remove(member) {
+			config.Members = append(config.Members, member)
+		}
+	}
+	if _, err := applyReplSetConfigWithWarnings(cmd, session, &oldconfig, config, opts); err != nil {
+		return err
+	}
+	return opts.wait(session)
+}
+
+// RemoveAndWait removes the member with the given address from the replica
+// set, as Remove does, and then waits until the member is no longer in the
+// current config or status of the replica set, and every healthy member
+// reports the config version that dropped it. The address can then safely
+// be added again.
+func RemoveAndWait(session *mgo.Session, addr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	if err := Remove(session, addr); err != nil {
+		return errors.Trace(err)
+	}
+	attempts := utils.AttemptStrategy{
+		Delay: configVersionAttemptDelay,
+		Total: timeout,
+	}
+	var config *Config
+	for a := attempts.Start(); a.Next(); {
+		var err error
+		config, err = CurrentConfig(session)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !configHasMember(config, addr) {
+			break
+		}
+		config = nil
+	}
+	if config == nil {
+		return errors.Errorf("timed out after %v waiting for %s to be removed from the config", timeout, addr)
+	}
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return errors.Errorf("timed out after %v: %s was removed from the config, but its propagation to the other members is unconfirmed", timeout, addr)
+	}
+	if err := WaitForConfigVersion(session, config.Version, remaining); err != nil {
+		return errors.Annotatef(err, "waiting for removal of %s", addr)
+	}
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return errors.Trace(err)
+	}
+	for _, member := range status.Members {
+		if member.Address == addr {
+			return errors.Errorf("%s is still in the replica set status", addr)
+		}
+	}
+	return nil
+}
+
+// configHasMember reports whether the given config holds a member with the
+// given address.
+func configHasMember(config *Config, addr string) bool {
+	for _, member := range config.Members {
+		if member.Address == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveUnreachable removes the members of the replica set that are
+// unhealthy and from which no heartbeat has been received for longer than
+// the given grace period, returning the addresses of the removed members.
+//
+// The primary and the member that the session is connected to are never
+// removed, and a member is only removed if the healthy voting members
+// remaining afterwards still form a majority of the voting members.
+// Members from which no heartbeat has ever been received, such as members
+// that were just added or that the session's member has not heard from
+// since it restarted, are never removed either, as there is no telling
+// how long they have been unreachable.
+func RemoveUnreachable(session *mgo.Session, grace time.Duration) ([]string, error) {
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return nil, errors.Trace(err)
+	}
+	config, err := CurrentConfig(session)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	healthy := make(map[int]bool)
+	unreachable := make(map[int]bool)
+	for _, member := range status.Members {
+		if member.Healthy {
+			healthy[member.Id] = true
+		}
+		if member.Healthy || member.Self || member.State == PrimaryState {
+			continue
+		}
+		if member.LastHeartbeatRecv.IsZero() || now.Sub(member.LastHeartbeatRecv) <= grace {
+			continue
+		}
+		unreachable[member.Id] = true
+	}
+	if len(unreachable) == 0 {
+		return nil, nil
+	}
+
+	// Check that the members left after the removal still have a healthy
+	// voting majority.
+	remainingVotes, healthyVotes := 0, 0
+	for _, member := range config.Members {
+		if unreachable[member.Id] {
+			continue
+		}
+		remainingVotes += memberVotes(member)
+		if healthy[member.Id] {
+			healthyVotes += memberVotes(member)
+		}
+	}
+	if healthyVotes < remainingVotes/2+1 {
+		return nil, errors.Errorf("cannot remove unreachable members: only %d of the remaining %d votes are healthy",
+			healthyVotes, remainingVotes)
+	}
+
+	oldconfig := *config
+	config.Version++
+	config.Members = nil
+	var removed []string
+	for _, member := range oldconfig.Members {
+		if unreachable[member.Id] {
+			removed = append(removed, member.Address)
+			continue
+		}
+		config.Members = append(config.Members, member)
+	}
+	if err := applyReplSetConfig("RemoveUnreachable", session, &oldconfig, config); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// memberVotes returns the number of votes the given member has in
+// elections. Newly added members do not vote until they have finished
+// their initial sync.
+func memberVotes(member Member) int {
+	if member.NewlyAdded != nil && *member.NewlyAdded {
+		return 0
+	}
+	if member.Votes == nil {
+		return 1
+	}
+	return *member.Votes
+}
+
+// countVotingMembers returns the number of the given members that vote.
+func countVotingMembers(members []Member) int {
+	voting := 0
+	for _, member := range members {
+		if memberVotes(member) > 0 {
+			voting++
+		}
+	}
+	return voting
+}
+
+// NextMemberID returns the Id that Add or Set would assign to a new member
+// of the replica set with the given config, one more than the highest Id
+// already in use.
+func NextMemberID(cfg *Config) int {
+	return findMaxId(cfg.Members, nil) + 1
+}
+
+// findMaxId looks through both sets of members and makes sure we cannot reuse an Id value
+func findMaxId(oldMembers, newMembers []Member) int {
+	max := 0
+	for _, m := range oldMembers {
+		if m.Id > max {
+			max = m.Id
+		}
+	}
+	// Also check if any of the members being passed in already have an Id that we would be reusing.
+	for _, m := range newMembers {
+		if m.Id > max {
+			max = m.Id
+		}
+	}
+	return max
+}
+
+// Set changes the current set of replica set members.  Members will have their
+// ids set automatically if their ids are not already > 0.
+func Set(session *mgo.Session, members []Member) error {
+	return SetWithOptions(session, ReconfigOptions{}, members)
+}
+
+// SetWithOptions is like Set but also takes options that change its
+// behaviour.
+func SetWithOptions(session *mgo.Session, opts ReconfigOptions, members []Member) error {
+	_, err := setMembers(session, opts, members)
+	return err
+}
+
+// SetWithWarnings is like Set, but also returns any warnings that mongo
+// reported while applying the new config, such as those it would
+// otherwise only write to its log, along with the warning from
+// Config.VotingParityWarning if the new config has an even number of
+// voting members.
+func SetWithWarnings(session *mgo.Session, members []Member) ([]string, error) {
+	return setMembers(session, ReconfigOptions{}, members)
+}
+
+// setMembers implements SetWithOptions and SetWithWarnings.
+func setMembers(session *mgo.Session, opts ReconfigOptions, members []Member) ([]string, error) {
+	if err := opts.check(members); err != nil {
+		return nil, err
+	}
+	config, err := CurrentConfig(session)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkReplicaSetName(config, opts.ExpectedName); err != nil {
+		return nil, err
+	}
+
+	if err := checkNewlyAdded(config.Members, members); err != nil {
+		return nil, err
+	}
 
 	// Copy the current configuration for logging
 	oldconfig := *config
 	config.Version++
 
-	// Assign ids to members that did not previously exist, starting above the
-	// value of the highest id that already existed
+	assignMemberIds(config.Members, members)
+	if opts.KeepMemberSettings {
+		inheritMemberSettings(config.Members, members)
+	}
+	config.Members = members
+	if err := opts.checkConfig(config); err != nil {
+		return nil, err
+	}
+
+	warnings, err := applyReplSetConfigWithWarnings("Set", session, &oldconfig, config, opts)
+	if err != nil {
+		return nil, err
+	}
+	return warnings, opts.wait(session)
+}
+
+// ErrNotPrimary is the cause of the error returned by SetVia when the
+// session is not connected to the primary.
+var ErrNotPrimary = errors.New("not the primary")
+
+// NotPrimaryError is returned by SetVia when the session is not connected
+// to the primary. Its cause is ErrNotPrimary.
+type NotPrimaryError struct {
+	// Address holds the address of the member that the session is
+	// connected to.
+	Address string
+
+	// Primary holds the address of the current primary, so that the
+	// caller can redirect the request. It is empty if there is no
+	// primary, for example during an election.
+	Primary string
+}
+
+// Error implements error.
+func (e *NotPrimaryError) Error() string {
+	if e.Primary == "" {
+		return fmt.Sprintf("%s is %v and there is no primary", e.Address, ErrNotPrimary)
+	}
+	return fmt.Sprintf("%s is %v, the primary is %s", e.Address, ErrNotPrimary, e.Primary)
+}
+
+// Cause returns ErrNotPrimary, so that errors.Cause can be used to check
+// for it.
+func (e *NotPrimaryError) Cause() error {
+	return ErrNotPrimary
+}
+
+// SetVia is like Set, but first checks that primarySession is connected to
+// the primary, which is the only member that accepts replSetReconfig. This
+// is useful with direct sessions, which are not redirected to the primary.
+// If the session is not connected to the primary, a *NotPrimaryError
+// holding the address of the actual primary is returned, and no reconfig
+// is attempted.
+func SetVia(primarySession *mgo.Session, members []Member) error {
+	results, err := IsMaster(primarySession)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !results.IsMaster {
+		return &NotPrimaryError{
+			Address: results.Address,
+			Primary: results.PrimaryAddress,
+		}
+	}
+	return Set(primarySession, members)
+}
+
+// ErrReplicaSetNameMismatch is returned when a reconfig is requested for a
+// replica set name that differs from the name of the session's replica set.
+var ErrReplicaSetNameMismatch = errors.New("replica set name mismatch")
+
+// SetInReplicaSet is like Set, but first checks that the session's replica
+// set is named name, returning an error with a cause of
+// ErrReplicaSetNameMismatch if it is not. This guards against reconfiguring
+// the wrong replica set. An empty name skips the check.
+func SetInReplicaSet(session *mgo.Session, name string, members []Member) error {
+	return SetWithOptions(session, ReconfigOptions{ExpectedName: name}, members)
+}
+
+// checkReplicaSetName returns an error with a cause of
+// ErrReplicaSetNameMismatch if name is not empty and differs from the name
+// of the given config.
+func checkReplicaSetName(config *Config, name string) error {
+	if name != "" && name != config.Name {
+		return errors.Annotatef(ErrReplicaSetNameMismatch,
+			"expected replica set %q, found %q", name, config.Name)
+	}
+	return nil
+}
+
+// assignMemberIds sets the ids of the given members. Members that already
+// exist in current keep their existing id, and members that did not
+// previously exist get an id starting above the value of the highest id
+// that already existed, unless their id is already > 0. The members are
+// then sorted by id.
+func assignMemberIds(current, members []Member) {
 	ids := map[string]int{}
-	max := findMaxId(config.Members, members)
-	for _, m := range config.Members {
+	max := findMaxId(current, members)
+	for _, m := range current {
 		ids[m.Address] = m.Id
 	}
 	for x, m := range members {
@@ -348,86 +1395,664 @@ func Set(session *mgo.Session, members []Member) error {
 
 	// Sort by Id just to keep things nicely understandable
 	sort.SliceStable(members, func(i, j int) bool { return members[i].Id < members[j].Id })
-	config.Members = members
-
-	return applyReplSetConfig("Set", session, &oldconfig, config)
 }
 
-// Config reports information about the configuration of a given mongo node
-type IsMasterResults struct {
-	// The following fields hold information about the specific mongodb node.
-	IsMaster  bool      `bson:"ismaster"`
-	Secondary bool      `bson:"secondary"`
-	Arbiter   bool      `bson:"arbiterOnly"`
-	Address   string    `bson:"me"`
-	LocalTime time.Time `bson:"localTime"`
-
-	// The following fields hold information about the replica set.
-	ReplicaSetName string   `bson:"setName"`
-	Addresses      []string `bson:"hosts"`
-	Arbiters       []string `bson:"arbiters"`
-	PrimaryAddress string   `bson:"primary"`
+// inheritMemberSettings sets the settings that are unset in each of the
+// given members from the current member with the same address, if any.
+func inheritMemberSettings(current, members []Member) {
+	byAddress := make(map[string]Member)
+	for _, m := range current {
+		byAddress[m.Address] = m
+	}
+	for i, m := range members {
+		old, ok := byAddress[m.Address]
+		if !ok {
+			continue
+		}
+		if m.Arbiter == nil {
+			m.Arbiter = old.Arbiter
+		}
+		if m.BuildIndexes == nil {
+			m.BuildIndexes = old.BuildIndexes
+		}
+		if m.Hidden == nil {
+			m.Hidden = old.Hidden
+		}
+		if m.Priority == nil {
+			m.Priority = old.Priority
+		}
+		if m.Tags == nil {
+			m.Tags = old.Tags
+		}
+		if m.SlaveDelay == nil {
+			m.SlaveDelay = old.SlaveDelay
+		}
+		if m.Votes == nil {
+			m.Votes = old.Votes
+		}
+		if m.Extra == nil {
+			m.Extra = old.Extra
+		}
+		members[i] = m
+	}
 }
 
-// IsMaster returns information about the configuration of the node that
-// the given session is connected to.
-func IsMaster(session *mgo.Session) (*IsMasterResults, error) {
-	results := &IsMasterResults{}
-	err := session.Run("isMaster", results)
+// ErrConfigVersionConflict is returned by ReconfigureAtVersion when the
+// live replica set config is not at the expected version.
+var ErrConfigVersionConflict = errors.New("replica set config version conflict")
+
+// ReconfigureAtVersion replaces the replica set config with the given config,
+// but only if the live config is still at expectedCurrentVersion. This gives
+// compare-and-swap semantics, so a controller that retries after a crash can
+// tell whether its change was already applied. If the live version differs,
+// an error with a cause of ErrConfigVersionConflict is returned.
+//
+// The version of cfg is ignored and set to expectedCurrentVersion+1. Members
+// will have their ids set automatically as they are with Set. If the name of
+// cfg is not empty, it must match the name of the live config, otherwise an
+// error with a cause of ErrReplicaSetNameMismatch is returned.
+func ReconfigureAtVersion(session *mgo.Session, cfg Config, expectedCurrentVersion int) error {
+	config, err := CurrentConfig(session)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if err := checkReplicaSetName(config, cfg.Name); err != nil {
+		return err
+	}
+	if config.Version != expectedCurrentVersion {
+		return errors.Annotatef(ErrConfigVersionConflict,
+			"expected version %d, found %d", expectedCurrentVersion, config.Version)
 	}
 
-	results.Address = formatIPv6AddressWithBrackets(results.Address)
-	results.PrimaryAddress = formatIPv6AddressWithBrackets(results.PrimaryAddress)
-	for index, address := range results.Addresses {
-		results.Addresses[index] = formatIPv6AddressWithBrackets(address)
+	newconfig := cfg
+	if newconfig.Name == "" {
+		newconfig.Name = config.Name
 	}
-	return results, nil
-}
+	if newconfig.ProtocolVersion == 0 {
+		newconfig.ProtocolVersion = config.ProtocolVersion
+	}
+	newconfig.Version = config.Version + 1
+	newconfig.Members = append([]Member(nil), cfg.Members...)
+	assignMemberIds(config.Members, newconfig.Members)
 
-var ErrMasterNotConfigured = fmt.Errorf("mongo master not configured")
+	return applyReplSetConfig("ReconfigureAtVersion", session, config, &newconfig)
+}
 
-// MasterHostPort returns the "address:port" string for the primary
-// mongo server in the replicaset. It returns ErrMasterNotConfigured if
-// the replica set has not yet been initiated.
-func MasterHostPort(session *mgo.Session) (string, error) {
-	results, err := IsMaster(session)
+// ApplyPriorityPolicy sets the priority of each member of the replica set to
+// the value returned by policy for that member, in a single reconfig.
+// Arbiters and members without votes must have a priority of 0, so the
+// policy is not consulted for them and their priority is left unchanged.
+func ApplyPriorityPolicy(session *mgo.Session, policy func(Member) float64) error {
+	config, err := CurrentConfig(session)
 	if err != nil {
-		return "", err
+		return err
 	}
-	if results.PrimaryAddress == "" {
-		return "", ErrMasterNotConfigured
+	oldconfig := *config
+	config.Version++
+	config.Members = append([]Member(nil), config.Members...)
+	for i, member := range config.Members {
+		if (member.Arbiter != nil && *member.Arbiter) || memberVotes(member) == 0 {
+			continue
+		}
+		priority := policy(member)
+		config.Members[i].Priority = &priority
 	}
-	return results.PrimaryAddress, nil
+	return applyReplSetConfig("ApplyPriorityPolicy", session, &oldconfig, config)
 }
 
-// CurrentMembers returns the current members of the replica set.
-func CurrentMembers(session *mgo.Session) ([]Member, error) {
-	cfg, err := CurrentConfig(session)
+// Cordon takes the member with the given address out of service without
+// removing it from the replica set, by making it hidden with a priority of
+// 0 so that it neither serves reads from drivers nor becomes primary. It
+// returns a function that restores the member's original priority and
+// hidden settings.
+//
+// The primary cannot be cordoned; step it down first. An error satisfying
+// errors.IsNotValid is returned for it, as for arbiters.
+func Cordon(session *mgo.Session, addr string) (restore func() error, err error) {
+	addr = formatIPv6AddressWithBrackets(addr)
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return nil, errors.Trace(err)
+	}
+	if member := findMemberStatus(status, addr); member != nil && member.State == PrimaryState {
+		return nil, errors.NotValidf("cordoning primary %q", addr)
+	}
+	var priority *float64
+	var hidden *bool
+	err = updateMember("Cordon", session, addr, func(m *Member) error {
+		if m.Arbiter != nil && *m.Arbiter {
+			return errors.NotValidf("cordoning arbiter %q", addr)
+		}
+		priority, hidden = m.Priority, m.Hidden
+		zero := 0.0
+		yes := true
+		m.Priority, m.Hidden = &zero, &yes
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	return cfg.Members, nil
+	restore = func() error {
+		return updateMember("Uncordon", session, addr, func(m *Member) error {
+			m.Priority, m.Hidden = priority, hidden
+			return nil
+		})
+	}
+	return restore, nil
 }
 
-// CurrentConfig returns the Config for the given session's replica set.  If
-// there is no current config, the error returned will be mgo.ErrNotFound.
-var CurrentConfig = currentConfig
-
-func currentConfig(session *mgo.Session) (*Config, error) {
-	cfg := &Config{}
-	monotonicSession := session.Clone()
-	defer monotonicSession.Close()
-	monotonicSession.SetMode(mgo.Monotonic, true)
-	err := monotonicSession.DB("local").C("system.replset").Find(nil).One(cfg)
-	if err == mgo.ErrNotFound {
-		return nil, err
+// Isolate takes the member with the given address out of the replica set's
+// consensus without removing it, by making it hidden, with a priority of 0
+// and no votes, so that it keeps replicating but neither serves reads from
+// drivers, votes in elections, nor counts towards write majorities. It
+// returns a function that restores the member's original votes, priority
+// and hidden settings.
+//
+// The primary and arbiters cannot be isolated, and an error is returned
+// without changing the config if the healthy data-bearing members left
+// voting would not hold a majority of the remaining votes, or if an even
+// number of voting members would be left, which would make the replica
+// set less fault tolerant. Add an arbiter first to isolate a member of a
+// replica set with an odd number of voting members.
+func Isolate(session *mgo.Session, addr string) (restore func() error, err error) {
+	addr = formatIPv6AddressWithBrackets(addr)
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return nil, errors.Trace(err)
 	}
-	if err != nil {
-		return nil, fmt.Errorf("cannot get replset config: %s", err.Error())
+	if member := findMemberStatus(status, addr); member != nil && member.State == PrimaryState {
+		return nil, errors.NotValidf("isolating primary %q", addr)
 	}
-
+	var votes *int
+	var priority *float64
+	var hidden *bool
+	err = updateMembers("Isolate", session, func(members []Member) error {
+		i := findMember(members, addr)
+		if i < 0 {
+			return errors.NotFoundf("replica set member %q", addr)
+		}
+		m := &members[i]
+		if m.Arbiter != nil && *m.Arbiter {
+			return errors.NotValidf("isolating arbiter %q", addr)
+		}
+		votes, priority, hidden = m.Votes, m.Priority, m.Hidden
+		zero := 0
+		zeroPriority := 0.0
+		yes := true
+		m.Votes, m.Priority, m.Hidden = &zero, &zeroPriority, &yes
+		if err := checkWritableMajority(status, members); err != nil {
+			return errors.Annotatef(err, "cannot isolate %q", addr)
+		}
+		if voting := countVotingMembers(members); voting%2 == 0 {
+			return errors.NewNotValid(nil, fmt.Sprintf(
+				"cannot isolate %q: it would leave an even number of voting members (%d)", addr, voting))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	restore = func() error {
+		return updateMember("Unisolate", session, addr, func(m *Member) error {
+			m.Votes, m.Priority, m.Hidden = votes, priority, hidden
+			return nil
+		})
+	}
+	return restore, nil
+}
+
+// UpdateMember changes the member with the given Id in the session's
+// replica set by calling update with a copy of it, and reconfigures the
+// replica set with the result, leaving the other members unchanged. It
+// returns an error satisfying errors.IsNotFound if there is no member with
+// the Id, and one satisfying errors.IsNotValid if update changes the Id.
+func UpdateMember(session *mgo.Session, id int, update func(*Member)) error {
+	return updateMembers("UpdateMember", session, func(members []Member) error {
+		for i := range members {
+			if members[i].Id != id {
+				continue
+			}
+			update(&members[i])
+			if members[i].Id != id {
+				return errors.NotValidf("changing the Id of member %d", id)
+			}
+			return nil
+		}
+		return errors.NotFoundf("replica set member %d", id)
+	})
+}
+
+// SetMemberTags replaces the tags of the member with the given address in
+// the session's replica set, leaving the rest of the config unchanged.
+// Empty tags remove all the tags of the member. It returns an error
+// satisfying errors.IsNotFound if there is no such member.
+func SetMemberTags(session *mgo.Session, addr string, tags map[string]string) error {
+	return updateMember("SetMemberTags", session, addr, func(m *Member) error {
+		m.Tags = copyTags(tags)
+		return nil
+	})
+}
+
+// MergeMemberTags adds the given tags to those of the member with the given
+// address in the session's replica set, replacing the values of the tags
+// it already has and keeping the others. It returns an error satisfying
+// errors.IsNotFound if there is no such member.
+func MergeMemberTags(session *mgo.Session, addr string, tags map[string]string) error {
+	return updateMember("MergeMemberTags", session, addr, func(m *Member) error {
+		merged := copyTags(m.Tags)
+		if merged == nil {
+			merged = make(map[string]string, len(tags))
+		}
+		for key, value := range tags {
+			merged[key] = value
+		}
+		m.Tags = merged
+		return nil
+	})
+}
+
+// copyTags returns a copy of the given tags, or nil if there are none.
+func copyTags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(tags))
+	for key, value := range tags {
+		copied[key] = value
+	}
+	return copied
+}
+
+// updateMember changes the member of the replica set with the given address
+// by calling update on it, and applies the resulting config. It returns an
+// error satisfying errors.IsNotFound if there is no such member.
+func updateMember(cmd string, session *mgo.Session, addr string, update func(*Member) error) error {
+	addr = formatIPv6AddressWithBrackets(addr)
+	return updateMembers(cmd, session, func(members []Member) error {
+		i := findMember(members, addr)
+		if i < 0 {
+			return errors.NotFoundf("replica set member %q", addr)
+		}
+		return update(&members[i])
+	})
+}
+
+// updateMembers changes the members of the replica set by calling update on
+// a copy of them, and applies the resulting config. If update returns an
+// error, the config is left unchanged.
+func updateMembers(cmd string, session *mgo.Session, update func([]Member) error) error {
+	config, err := CurrentConfig(session)
+	if err != nil {
+		return err
+	}
+	oldconfig := *config
+	config.Version++
+	config.Members = append([]Member(nil), config.Members...)
+	if err := update(config.Members); err != nil {
+		return errors.Trace(err)
+	}
+	return applyReplSetConfig(cmd, session, &oldconfig, config)
+}
+
+// findMember returns the index of the member with the given address, which
+// must have IPv6 hosts in brackets, or -1 if there is no such member.
+func findMember(members []Member, addr string) int {
+	for i, member := range members {
+		if formatIPv6AddressWithBrackets(member.Address) == addr {
+			return i
+		}
+	}
+	return -1
+}
+
+// Config reports information about the configuration of a given mongo node
+type IsMasterResults struct {
+	// The following fields hold information about the specific mongodb node.
+	IsMaster  bool      `bson:"ismaster"`
+	Secondary bool      `bson:"secondary"`
+	Arbiter   bool      `bson:"arbiterOnly"`
+	Address   string    `bson:"me"`
+	LocalTime time.Time `bson:"localTime"`
+
+	// The following fields hold information about the replica set.
+	ReplicaSetName string   `bson:"setName"`
+	Addresses      []string `bson:"hosts"`
+	Arbiters       []string `bson:"arbiters"`
+	PrimaryAddress string   `bson:"primary"`
+}
+
+// IsMaster returns information about the configuration of the node that
+// the given session is connected to.
+func IsMaster(session *mgo.Session) (*IsMasterResults, error) {
+	return ReadIsMaster(session)
+}
+
+// ReadIsMaster is like IsMaster, but runs the command through the given
+// runner.
+func ReadIsMaster(r Runner) (*IsMasterResults, error) {
+	results := &IsMasterResults{}
+	err := runCommand(r, "isMaster", results)
+	if err != nil {
+		return nil, errors.Annotate(err, "isMaster")
+	}
+
+	results.Address = formatIPv6AddressWithBrackets(results.Address)
+	results.PrimaryAddress = formatIPv6AddressWithBrackets(results.PrimaryAddress)
+	for index, address := range results.Addresses {
+		results.Addresses[index] = formatIPv6AddressWithBrackets(address)
+	}
+	return results, nil
+}
+
+var ErrMasterNotConfigured = fmt.Errorf("mongo master not configured")
+
+// MasterHostPort returns the "address:port" string for the primary
+// mongo server in the replicaset. It returns ErrMasterNotConfigured if
+// the replica set has not yet been initiated.
+func MasterHostPort(session *mgo.Session) (string, error) {
+	results, err := IsMaster(session)
+	if err != nil {
+		return "", err
+	}
+	if results.PrimaryAddress == "" {
+		return "", ErrMasterNotConfigured
+	}
+	return results.PrimaryAddress, nil
+}
+
+// ErrNoPrimaryElected is returned by DialPrimary when the replica set has
+// no primary, for example during an election.
+var ErrNoPrimaryElected = errors.New("no primary elected")
+
+// DialPrimary returns a new session connected directly to the primary of
+// the replica set that the node the given session is connected to belongs
+// to. The primary is found with isMaster, dialed with the given timeout,
+// and checked to still be the primary before the session is returned; the
+// caller must close it. Like the sessions used by PingMembers, it is
+// dialed without credentials, so callers using authentication should log
+// in on it.
+//
+// An error with ErrNoPrimaryElected as its cause is returned if there is no
+// primary, and ErrMasterNotConfigured if the node is not yet part of an
+// initiated replica set.
+func DialPrimary(session *mgo.Session, timeout time.Duration) (*mgo.Session, error) {
+	results, err := IsMaster(session)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if results.PrimaryAddress == "" {
+		if results.ReplicaSetName == "" {
+			return nil, ErrMasterNotConfigured
+		}
+		return nil, ErrNoPrimaryElected
+	}
+	primarySession, err := dialMember(results.PrimaryAddress, timeout)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	primarySession.SetMode(mgo.Strong, true)
+	primaryResults, err := IsMaster(primarySession)
+	if err != nil {
+		primarySession.Close()
+		return nil, errors.Trace(err)
+	}
+	if !primaryResults.IsMaster {
+		primarySession.Close()
+		return nil, errors.Annotatef(ErrNoPrimaryElected, "%s is no longer primary", results.PrimaryAddress)
+	}
+	return primarySession, nil
+}
+
+// WaitForPrimary waits until the session's replica set has a primary, as
+// reported by replSetGetStatus, and returns its address. It polls the
+// status until ctx is done, refreshing the session when the connection is
+// dropped, as happens when the primary steps down, so it can be used after
+// StepDownPrimary or a reconfig. If ctx is done first, an error whose cause
+// is the context's error is returned.
+func WaitForPrimary(ctx context.Context, session *mgo.Session) (string, error) {
+	ticker := time.NewTicker(memberStateAttemptDelay)
+	defer ticker.Stop()
+	var lastErr error
+	for {
+		status, err := getCurrentStatus(session)
+		switch {
+		case isConnectionNotAvailable(err):
+			logger.Errorf("DB connection dropped so reconnecting")
+			session.Refresh()
+			lastErr = err
+		case err != nil && !IsPartialStatus(err):
+			logger.Debugf("WaitForPrimary: %v", err)
+			lastErr = err
+		default:
+			if primary := primaryAddress(status); primary != "" {
+				return primary, nil
+			}
+			lastErr = ErrNoPrimaryElected
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return "", errors.Annotatef(ctx.Err(), "waiting for a primary (last error: %v)", lastErr)
+		}
+	}
+}
+
+// ReplicaSetName returns the name of the replica set that the node the
+// given session is connected to belongs to. It returns
+// ErrMasterNotConfigured if the node is not yet part of an initiated
+// replica set. It may be used with a direct session during bootstrap.
+func ReplicaSetName(session *mgo.Session) (string, error) {
+	results, err := IsMaster(session)
+	if err != nil {
+		return "", err
+	}
+	if results.ReplicaSetName == "" {
+		return "", ErrMasterNotConfigured
+	}
+	return results.ReplicaSetName, nil
+}
+
+// IsPrimaryAddress reports whether the member with the given address is the
+// current primary of the session's replica set. IPv6 addresses may be given
+// with or without brackets. It returns false when there is no primary, for
+// example while an election is in progress.
+func IsPrimaryAddress(session *mgo.Session, addr string) (bool, error) {
+	primary, err := MasterHostPort(session)
+	if err == ErrMasterNotConfigured {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return primary == formatIPv6AddressWithBrackets(addr), nil
+}
+
+// CurrentMembers returns the current members of the replica set, sorted by
+// Id. Like CurrentConfig, it leaves the mode of the given session
+// unchanged.
+func CurrentMembers(session *mgo.Session) ([]Member, error) {
+	cfg, err := CurrentConfig(session)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Members, nil
+}
+
+// CurrentMembersMap returns the current members of the replica set keyed by
+// their address. Addresses are normalized the same way as for CurrentMembers,
+// so IPv6 addresses are always in the bracketed "[<addr>]:<port>" form.
+func CurrentMembersMap(session *mgo.Session) (map[string]Member, error) {
+	members, err := CurrentMembers(session)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]Member, len(members))
+	for _, member := range members {
+		result[formatIPv6AddressWithBrackets(member.Address)] = member
+	}
+	return result, nil
+}
+
+// RequireTags returns the addresses of the members of the session's replica
+// set, other than arbiters, that do not have all of the given tag keys.
+// Members added without the tags that custom write concerns rely on can
+// then be found and fixed; ReconfigOptions.RequiredTags keeps them from
+// being added in the first place.
+func RequireTags(session *mgo.Session, requiredKeys []string) ([]string, error) {
+	members, err := CurrentMembers(session)
+	if err != nil {
+		return nil, err
+	}
+	return membersMissingTags(members, requiredKeys), nil
+}
+
+// membersMissingTags returns the addresses of the given members, other
+// than arbiters, that do not have all of the given tag keys.
+func membersMissingTags(members []Member, keys []string) []string {
+	var missing []string
+	for _, member := range members {
+		if member.Arbiter != nil && *member.Arbiter {
+			continue
+		}
+		for _, key := range keys {
+			if _, ok := member.Tags[key]; !ok {
+				missing = append(missing, member.Address)
+				break
+			}
+		}
+	}
+	return missing
+}
+
+// AllTags returns, for each tag key used by the members of the replica set,
+// the sorted distinct values it has across all members. It can be used to
+// check that a custom write concern can be satisfied.
+func AllTags(session *mgo.Session) (map[string][]string, error) {
+	members, err := CurrentMembers(session)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]map[string]bool)
+	for _, member := range members {
+		for key, value := range member.Tags {
+			if seen[key] == nil {
+				seen[key] = make(map[string]bool)
+			}
+			seen[key][value] = true
+		}
+	}
+	tags := make(map[string][]string, len(seen))
+	for key, values := range seen {
+		for value := range values {
+			tags[key] = append(tags[key], value)
+		}
+		sort.Strings(tags[key])
+	}
+	return tags, nil
+}
+
+// PingMembers dials each member of the session's replica set directly and
+// measures the round-trip time of a ping to it. The result maps member
+// addresses to round-trip times. Members that cannot be dialed or pinged
+// within the given timeout are left out of the result and reported in the
+// returned error, along with the times for the members that succeeded.
+func PingMembers(session *mgo.Session, timeout time.Duration) (map[string]time.Duration, error) {
+	members, err := CurrentMembers(session)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]time.Duration)
+	var failures []string
+	for _, member := range members {
+		rtt, err := pingMember(member.Address, timeout)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", member.Address, err))
+			continue
+		}
+		result[member.Address] = rtt
+	}
+	if len(failures) > 0 {
+		return result, errors.Errorf("cannot ping members: %s", strings.Join(failures, "; "))
+	}
+	return result, nil
+}
+
+// pingMember dials the mongo server at the given address directly and
+// returns the round-trip time of a single ping.
+func pingMember(addr string, timeout time.Duration) (time.Duration, error) {
+	session, err := dialMember(addr, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer session.Close()
+
+	start := time.Now()
+	if err := session.Ping(); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// dialMember dials the mongo server at the given address directly, without
+// credentials. The returned session uses the given timeout for both
+// dialing and operations, and is in monotonic mode so it can be used with
+// secondaries. It is a variable so that it can be patched in tests.
+var dialMember = func(addr string, timeout time.Duration) (*mgo.Session, error) {
+	session, err := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:   []string{addr},
+		Direct:  true,
+		Timeout: timeout,
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot dial %s", addr)
+	}
+	session.SetSyncTimeout(timeout)
+	session.SetSocketTimeout(timeout)
+	session.SetMode(mgo.Monotonic, true)
+	return session, nil
+}
+
+// CurrentConfig returns the Config for the given session's replica set.  If
+// there is no current config, the error returned will be mgo.ErrNotFound.
+//
+// The config is read using a clone of the session in monotonic mode, so
+// the mode of the given session is left unchanged. Use CurrentConfigStrong
+// to read the config from the primary.
+var CurrentConfig = currentConfig
+
+func currentConfig(session *mgo.Session) (*Config, error) {
+	return readConfig(session, mgo.Monotonic)
+}
+
+// CurrentConfigStrong is like CurrentConfig but reads the config from the
+// primary in strong mode, so it never returns a config that is older than
+// the one the primary has. It fails if there is no primary. The mode of the
+// given session is left unchanged.
+func CurrentConfigStrong(session *mgo.Session) (*Config, error) {
+	return readConfig(session, mgo.Strong)
+}
+
+// readConfig reads the replica set config using a clone of the given
+// session set to the given mode.
+func readConfig(session *mgo.Session, mode mgo.Mode) (*Config, error) {
+	cfg := &Config{}
+	readSession := session.Clone()
+	defer readSession.Close()
+	readSession.SetMode(mode, true)
+	err := readSession.DB("local").C("system.replset").Find(nil).One(cfg)
+	if err == mgo.ErrNotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot get replset config")
+	}
+	normalizeConfig(cfg)
+	return cfg, nil
+}
+
+// normalizeConfig formats the member addresses of a config read from mongo
+// and sorts the members by Id.
+func normalizeConfig(cfg *Config) {
 	members := make([]Member, len(cfg.Members), len(cfg.Members))
 	for index, member := range cfg.Members {
 		member.Address = formatIPv6AddressWithBrackets(member.Address)
@@ -436,7 +2061,33 @@ func currentConfig(session *mgo.Session) (*Config, error) {
 	// Sort the values by Member.Id
 	sort.Slice(members, func(i, j int) bool { return members[i].Id < members[j].Id })
 	cfg.Members = members
-	return cfg, nil
+}
+
+// CurrentConfigWithCommitment returns the Config for the given session's
+// replica set, as reported by replSetGetConfig, along with whether that
+// config has been committed to a majority of the members. A new reconfig
+// should not be started until the previous one is committed.
+//
+// The commitment status is only reported by MongoDB 4.4 and later; for
+// older servers the returned committed value is nil.
+func CurrentConfigWithCommitment(session *mgo.Session) (*Config, *bool, error) {
+	withCommitment, err := SupportsFeature(session, FeatureCommitmentStatus)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	cmd := bson.D{{"replSetGetConfig", 1}}
+	if withCommitment {
+		cmd = append(cmd, bson.DocElem{"commitmentStatus", true})
+	}
+	var result struct {
+		Config           Config `bson:"config"`
+		CommitmentStatus *bool  `bson:"commitmentStatus"`
+	}
+	if err := runCommand(session, cmd, &result); err != nil {
+		return nil, nil, errors.Annotate(err, "cannot get replset config")
+	}
+	normalizeConfig(&result.Config)
+	return &result.Config, result.CommitmentStatus, nil
 }
 
 // Config is the document stored in mongodb that defines the servers in the
@@ -446,43 +2097,710 @@ type Config struct {
 	ProtocolVersion int64    `bson:"protocolVersion"`
 	Version         int      `bson:"version"`
 	Members         []Member `bson:"members"`
+
+	// WriteConcernMajorityJournalDefault holds whether majority writes
+	// are only acknowledged once written to the journal of a majority of
+	// the members. It is nil when the config does not set it, in which
+	// case mongo uses true.
+	WriteConcernMajorityJournalDefault *bool `bson:"writeConcernMajorityJournalDefault,omitempty"`
+
+	// Settings holds the settings that apply to the whole replica set.
+	// It is nil when the config has no settings document.
+	Settings *ReplicaSetSettings `bson:"settings,omitempty"`
+}
+
+// VotingParityWarning reports whether the config has an even number of
+// voting members, along with a message describing the risk. An even number
+// of voting members tolerates no more failures than one fewer would, and
+// risks tied elections, so an arbiter or another voting member should be
+// added. The message is empty when the number of voting members is odd.
+func (cfg *Config) VotingParityWarning() (bool, string) {
+	voting := countVotingMembers(cfg.Members)
+	if voting == 0 || voting%2 != 0 {
+		return false, ""
+	}
+	return true, fmt.Sprintf("replica set has an even number of voting members (%d); "+
+		"add an arbiter or another voting member to improve fault tolerance", voting)
+}
+
+// StepDownPrimary asks the current mongo primary to step down.
+// Note that triggering a step down causes all client connections to be
+// disconnected. We explicitly treat the io.EOF we get as not being an error,
+// but all other sessions will also be disconnected.
+func StepDownPrimary(session *mgo.Session) error {
+	return StepDownPrimaryWithOptions(session, StepDownOptions{})
+}
+
+// StepDownOptions holds the optional parameters of
+// StepDownPrimaryWithOptions.
+type StepDownOptions struct {
+	// StepDownPeriod holds how long the old primary refuses to become
+	// primary again. It is rounded up to the nearest second. If zero,
+	// defaultStepDownPeriod is used.
+	StepDownPeriod time.Duration
+
+	// CatchUpPeriod holds how long the primary waits for a secondary to
+	// catch up with it before stepping down. It is rounded up to the
+	// nearest second, and must be shorter than the step down period. If
+	// zero, mongo's default of 10 seconds is used.
+	CatchUpPeriod time.Duration
+
+	// Force, if true, makes the primary step down even if no secondary
+	// catches up with it within the catch up period, which may lose the
+	// writes that were not replicated.
+	Force bool
+}
+
+// defaultStepDownPeriod is the default value of
+// StepDownOptions.StepDownPeriod.
+const defaultStepDownPeriod = 60 * time.Second
+
+// StepDownPrimaryWithOptions is like StepDownPrimary, but also takes
+// options that control how long the old primary refuses to be elected and
+// how long it waits for a secondary to catch up. It returns an error
+// satisfying errors.IsNotValid if the catch up period is not shorter than
+// the step down period.
+func StepDownPrimaryWithOptions(session *mgo.Session, opts StepDownOptions) error {
+	cmd, err := opts.command()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// StepDown can only be called on the primary. The mode is set on a
+	// clone so that the caller's session is left unchanged.
+	strictSession := session.Clone()
+	defer strictSession.Close()
+	strictSession.SetMode(mgo.Primary, true)
+	err = runCommand(strictSession, cmd, nil)
+	// we expect to get io.EOF so don't treat it as a failure.
+	if err == io.EOF {
+		return nil
+	}
+	return errors.Annotate(err, "replSetStepDown")
+}
+
+// command returns the replSetStepDown command for the options.
+func (opts StepDownOptions) command() (bson.D, error) {
+	stepDown := opts.StepDownPeriod
+	if stepDown == 0 {
+		stepDown = defaultStepDownPeriod
+	}
+	stepDownSecs := (stepDown + time.Second - 1) / time.Second
+	// replSetStepDown takes a few optional parameters that vary based on what
+	// version of Mongo is running. In Mongo 2.4 it just takes the "step down
+	// seconds" which claims to default to 60s.
+	// In 3.2 it can also take secondaryCatchUpPeriodSecs which is supposed to
+	// start at 10s. However, testing shows that not passing either gives:
+	// err{"stepdown period must be longer than secondaryCatchUpPeriodSecs"}
+	cmd := bson.D{{"replSetStepDown", float64(stepDownSecs)}}
+	if opts.CatchUpPeriod != 0 {
+		catchUpSecs := (opts.CatchUpPeriod + time.Second - 1) / time.Second
+		if catchUpSecs >= stepDownSecs {
+			return nil, errors.NotValidf("catch up period %v for step down period %v",
+				opts.CatchUpPeriod, stepDown)
+		}
+		cmd = append(cmd, bson.DocElem{"secondaryCatchUpPeriodSecs", float64(catchUpSecs)})
+	}
+	if opts.Force {
+		cmd = append(cmd, bson.DocElem{"force", true})
+	}
+	return cmd, nil
+}
+
+// EnsureNotPrimary makes sure that the member that the given session is
+// connected to is not the primary. If it is, it is asked to step down and
+// EnsureNotPrimary waits up to the given timeout for another member to
+// become primary. If it is not the primary, nothing is done and nil is
+// returned, so it is safe to call defensively. The session should be a
+// direct session to the member.
+func EnsureNotPrimary(session *mgo.Session, timeout time.Duration) error {
+	results, err := IsMaster(session)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !results.IsMaster {
+		return nil
+	}
+	self := results.Address
+	if err := stepDownPrimary(session); err != nil {
+		return errors.Trace(err)
+	}
+	attempts := utils.AttemptStrategy{
+		Delay: memberStateAttemptDelay,
+		Total: timeout,
+	}
+	for a := attempts.Start(); a.Next(); {
+		// Stepping down drops all connections.
+		session.Refresh()
+		results, err := IsMaster(session)
+		if err != nil {
+			logger.Debugf("EnsureNotPrimary: %v", err)
+			continue
+		}
+		if results.PrimaryAddress != "" && results.PrimaryAddress != self {
+			return nil
+		}
+	}
+	return errors.Errorf("timed out after %v waiting for a new primary", timeout)
+}
+
+// DemotePrimary durably prevents the current primary from being primary
+// again: it steps the primary down, waits up to the given timeout for
+// another member to be elected, and then sets the priority of the old
+// primary to 0. It returns the address of the new primary.
+//
+// The priority is changed after the step down because mongo refuses a
+// reconfig that makes the member running it unelectable; the old primary
+// cannot be re-elected in between, as replSetStepDown keeps it from
+// seeking election for 60 seconds.
+//
+// An error is returned without stepping down if there is no other healthy
+// secondary that can become primary.
+func DemotePrimary(session *mgo.Session, timeout time.Duration) (newPrimary string, err error) {
+	deadline := time.Now().Add(timeout)
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return "", errors.Trace(err)
+	}
+	oldPrimary := primaryAddress(status)
+	if oldPrimary == "" {
+		return "", errors.New("no primary to demote")
+	}
+	config, err := CurrentConfig(session)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if !hasElectableSecondary(status, config) {
+		return "", errors.Errorf("cannot demote %s: no other member can become primary", oldPrimary)
+	}
+	if err := stepDownPrimary(session); err != nil {
+		return "", errors.Trace(err)
+	}
+	attempts := utils.AttemptStrategy{
+		Delay: memberStateAttemptDelay,
+		Total: time.Until(deadline),
+	}
+	for a := attempts.Start(); a.Next() && newPrimary == ""; {
+		status, err := getCurrentStatus(session)
+		if isConnectionNotAvailable(err) {
+			logger.Errorf("DB connection dropped so reconnecting")
+			session.Refresh()
+			continue
+		}
+		if err != nil && !IsPartialStatus(err) {
+			logger.Debugf("DemotePrimary: %v", err)
+			continue
+		}
+		if primary := primaryAddress(status); primary != oldPrimary {
+			newPrimary = primary
+		}
+	}
+	if newPrimary == "" {
+		return "", errors.Errorf("timed out after %v waiting for a new primary", timeout)
+	}
+	err = updateMember("DemotePrimary", session, oldPrimary, func(m *Member) error {
+		zero := 0.0
+		m.Priority = &zero
+		return nil
+	})
+	if err != nil {
+		return newPrimary, errors.Annotatef(err, "%s is primary but the priority of %s was not changed", newPrimary, oldPrimary)
+	}
+	return newPrimary, nil
+}
+
+// hasElectableSecondary reports whether the replica set with the given
+// status and config has a healthy secondary that can become primary.
+func hasElectableSecondary(status *Status, config *Config) bool {
+	members := make(map[int]Member)
+	for _, member := range config.Members {
+		members[member.Id] = member
+	}
+	for _, member := range status.Members {
+		if !member.Healthy || member.State != SecondaryState {
+			continue
+		}
+		m, ok := members[member.Id]
+		if !ok || memberVotes(m) == 0 || (m.Priority != nil && *m.Priority == 0) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// ResyncMember forces the member that the given session is connected to
+// to discard all of its data and perform an initial sync from another member
+// of the replica set. The session must be a direct session to the member to
+// resync, and that member must not be the primary.
+//
+// This is destructive: the member's data is wiped and it is unavailable for
+// reads until the initial sync completes, which may take a long time for a
+// large data set.
+//
+// The resync command was removed in MongoDB 4.2; for those servers an error
+// satisfying errors.IsNotSupported is returned and the member must be
+// resynced by stopping it and emptying its data directory instead.
+//
+// See https://docs.mongodb.com/v4.0/reference/command/resync/ for more
+// details.
+func ResyncMember(session *mgo.Session) error {
+	buildInfo, err := getBuildInfo(session)
+	if err != nil {
+		return errors.Annotate(err, "buildInfo")
+	}
+	if buildInfo.VersionAtLeast(4, 2) {
+		return errors.NotSupportedf("resync on MongoDB %s", buildInfo.Version)
+	}
+	return errors.Annotate(runCommand(session, "resync", nil), "resync")
+}
+
+// CurrentStatus returns the status of the replica set for the given session.
+// The command is run according to the session's current mode, which is
+// left unchanged. Use CurrentStatusStrong to get the status as seen by the
+// primary.
+//
+// The members of the returned status are sorted by Id, whatever the order
+// in which mongo reports them. Their Votes and Electable fields, which
+// come from the config, are left unset; use CurrentStatusWithVoting to
+// get them.
+func CurrentStatus(session *mgo.Session) (*Status, error) {
+	status, err := readStatus(session)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return status, checkPartialStatus(status)
+}
+
+// CurrentStatusWithVoting is like CurrentStatus, but also reads the config
+// of the replica set to set the Votes and Electable fields of the members.
+func CurrentStatusWithVoting(session *mgo.Session) (*Status, error) {
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return nil, errors.Trace(err)
+	}
+	config, configErr := CurrentConfig(session)
+	if configErr != nil {
+		return nil, errors.Annotate(configErr, "cannot get replica set config")
+	}
+	setMemberVoting(status, config)
+	return status, err
+}
+
+// readStatus runs replSetGetStatus through the given runner, normalizing
+// the member addresses and sorting the members by Id.
+func readStatus(r Runner) (*Status, error) {
+	status := &Status{}
+	err := runCommand(r, "replSetGetStatus", status)
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot get replica set status")
+	}
+
+	for index, member := range status.Members {
+		status.Members[index].Address = formatIPv6AddressWithBrackets(member.Address)
+		if member.SyncSource != "" {
+			status.Members[index].SyncSource = formatIPv6AddressWithBrackets(member.SyncSource)
+		}
+		if member.OptimeDurable.IsZero() {
+			status.Members[index].OptimeDurable = member.OptimeApplied
+		}
+		if member.Self && status.LastStableRecoveryTimestamp != 0 {
+			status.Members[index].StableRecoveryTimestamp = timestampTime(status.LastStableRecoveryTimestamp)
+		}
+	}
+	sort.SliceStable(status.Members, func(i, j int) bool {
+		return status.Members[i].Id < status.Members[j].Id
+	})
+	return status, nil
+}
+
+// setMemberVoting sets the Votes and Electable fields of the members of
+// the given status from their settings in the given config. Members not
+// in the config, or all members if config is nil, are assumed to have one
+// vote and the default priority.
+func setMemberVoting(status *Status, config *Config) {
+	members := make(map[int]Member)
+	if config != nil {
+		for _, member := range config.Members {
+			members[member.Id] = member
+		}
+	}
+	for i, member := range status.Members {
+		configMember := members[member.Id]
+		votes := memberVotes(configMember)
+		priority := 1.0
+		if configMember.Priority != nil {
+			priority = *configMember.Priority
+		}
+		isArbiter := configMember.Arbiter != nil && *configMember.Arbiter
+		status.Members[i].Votes = votes
+		status.Members[i].Electable = member.Healthy &&
+			(member.State == PrimaryState || member.State == SecondaryState) &&
+			votes > 0 && priority > 0 && !isArbiter
+	}
+}
+
+// SelfStatus returns the status of the member that the given session is
+// connected to, which should be a direct session. It is taken from
+// replSetGetStatus if possible; if that fails, for instance because the
+// member cannot see the rest of the replica set, the status is built from
+// the member's own view reported by isMaster, and only the Id, Address,
+// Self, Healthy and State fields are set.
+func SelfStatus(session *mgo.Session) (*MemberStatus, error) {
+	status, err := getCurrentStatus(session)
+	if err == nil || IsPartialStatus(err) {
+		for _, member := range status.Members {
+			if member.Self && member.parseErr == nil {
+				return &member, nil
+			}
+		}
+	}
+	if err != nil {
+		logger.Debugf("SelfStatus: falling back to isMaster: %v", err)
+	}
+	results, err := IsMaster(session)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	self := &MemberStatus{
+		Address: results.Address,
+		Self:    true,
+		Healthy: true,
+		State:   UnknownState,
+	}
+	switch {
+	case results.IsMaster:
+		self.State = PrimaryState
+	case results.Secondary:
+		self.State = SecondaryState
+	case results.Arbiter:
+		self.State = ArbiterState
+	}
+	if config, err := CurrentConfig(session); err == nil {
+		for _, member := range config.Members {
+			if member.Address == self.Address {
+				self.Id = member.Id
+			}
+		}
+	}
+	return self, nil
+}
+
+// ResolveSelfAddress returns the address of the member that the given
+// session is connected to, as the replica set knows it: the address of the
+// member reported with self set in replSetGetStatus, or, failing that,
+// the address reported by isMaster. With split-horizon or NAT setups this
+// may differ from the address used to dial the member, so callers can use
+// it to reconcile the two before matching members by address.
+func ResolveSelfAddress(session *mgo.Session) (string, error) {
+	self, err := SelfStatus(session)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if self.Address == "" {
+		return "", errors.NotFoundf("self address")
+	}
+	return self.Address, nil
+}
+
+// MembersInState returns the status of the members of the session's
+// replica set that are currently in any of the given states, sorted by Id.
+func MembersInState(session *mgo.Session, states ...MemberState) ([]MemberStatus, error) {
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return nil, errors.Trace(err)
+	}
+	var members []MemberStatus
+	for _, member := range status.Members {
+		for _, state := range states {
+			if member.State == state {
+				members = append(members, member)
+				break
+			}
+		}
+	}
+	return members, nil
+}
+
+// LastCommittedOptime returns the time of the most recent operation that
+// has been written to a majority of the members of the session's replica
+// set, as seen by the member that the session is connected to. It is zero
+// if no operation has been committed yet. Compare it with the result of
+// MemberAppliedOptime to check whether a member has caught up with a
+// committed write.
+func LastCommittedOptime(session *mgo.Session) (time.Time, error) {
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return time.Time{}, errors.Trace(err)
+	}
+	ts := status.Optimes.LastCommitted.Timestamp
+	if ts == 0 {
+		return time.Time{}, nil
+	}
+	return timestampTime(ts), nil
+}
+
+// MemberAppliedOptime returns the time of the most recent operation that
+// the member with the given address has applied, as seen by the member
+// that the session is connected to. It returns an error satisfying
+// errors.IsNotFound if there is no such member.
+func MemberAppliedOptime(session *mgo.Session, addr string) (time.Time, error) {
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return time.Time{}, errors.Trace(err)
+	}
+	member := findMemberStatus(status, formatIPv6AddressWithBrackets(addr))
+	if member == nil {
+		return time.Time{}, errors.NotFoundf("replica set member %q", addr)
+	}
+	return member.OptimeApplied, nil
+}
+
+// ReplicationLag returns how far behind the primary each healthy secondary
+// of the session's replica set is, keyed by address, as the difference
+// between the times of the last operations applied by the primary and by
+// the secondary. The primary itself is reported with no lag; arbiters and
+// members that are not healthy are left out. An error with
+// ErrNoPrimaryElected as its cause is returned if there is no primary.
+func ReplicationLag(session *mgo.Session) (map[string]time.Duration, error) {
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return nil, errors.Trace(err)
+	}
+	primary := findMemberStatus(status, primaryAddress(status))
+	if primary == nil {
+		return nil, ErrNoPrimaryElected
+	}
+	lags := make(map[string]time.Duration)
+	for _, member := range status.Members {
+		if !member.Healthy || member.State == ArbiterState {
+			continue
+		}
+		lag := primary.OptimeApplied.Sub(member.OptimeApplied)
+		if lag < 0 {
+			lag = 0
+		}
+		lags[member.Address] = lag
+	}
+	return lags, nil
+}
+
+// ErrPartialStatus is the cause of the error returned by CurrentStatus
+// when the status of some members could not be parsed. The status is
+// returned alongside the error, with the unparsed members reported as
+// unhealthy and in UnknownState, and every function in this package that
+// reads the status carries on with it. Use IsPartialStatus to check for it.
+var ErrPartialStatus = errors.New("partial replica set status")
+
+// IsPartialStatus reports whether the cause of err is ErrPartialStatus.
+func IsPartialStatus(err error) bool {
+	return errors.Cause(err) == ErrPartialStatus
+}
+
+// checkPartialStatus returns an error caused by ErrPartialStatus if the
+// status of any member of the given status could not be parsed.
+func checkPartialStatus(status *Status) error {
+	var unparsed []string
+	for _, member := range status.Members {
+		if member.parseErr != nil {
+			unparsed = append(unparsed, fmt.Sprintf("member %d (%v)", member.Id, member.parseErr))
+		}
+	}
+	if len(unparsed) == 0 {
+		return nil
+	}
+	return errors.Annotatef(ErrPartialStatus, "cannot parse status of %s", strings.Join(unparsed, ", "))
+}
+
+// CurrentStatusStrong is like CurrentStatus but always gets the status from
+// the primary, using a clone of the session in strong mode. It fails if
+// there is no primary. The mode of the given session is left unchanged.
+func CurrentStatusStrong(session *mgo.Session) (*Status, error) {
+	strongSession := session.Clone()
+	defer strongSession.Close()
+	strongSession.SetMode(mgo.Strong, true)
+	return CurrentStatus(strongSession)
+}
+
+// BulkStatus gets the status of many replica sets concurrently, running at
+// most concurrency requests at a time. The sessions are keyed by an
+// arbitrary name for each replica set; the statuses of the sets that
+// succeeded and the errors of those that failed are returned keyed by the
+// same names. A partial status is returned in both maps.
+func BulkStatus(sessions map[string]*mgo.Session, concurrency int) (map[string]*Status, map[string]error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	type result struct {
+		name   string
+		status *Status
+		err    error
+	}
+	names := make(chan string)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				status, err := getCurrentStatus(sessions[name])
+				results <- result{name, status, err}
+			}
+		}()
+	}
+	go func() {
+		for name := range sessions {
+			names <- name
+		}
+		close(names)
+		wg.Wait()
+		close(results)
+	}()
+
+	statuses := make(map[string]*Status)
+	errs := make(map[string]error)
+	for r := range results {
+		if r.err != nil {
+			errs[r.name] = r.err
+			if !IsPartialStatus(r.err) {
+				continue
+			}
+		}
+		statuses[r.name] = r.status
+	}
+	return statuses, errs
+}
+
+// Freeze prevents the member that the session is connected to, which
+// should be a direct session to a secondary, from seeking election for the
+// given duration, rounded up to the nearest second, for instance during
+// maintenance. It returns an error satisfying errors.IsNotValid if the
+// duration is not positive. Use Unfreeze to allow the member to seek
+// election again before the duration has elapsed.
+func Freeze(session *mgo.Session, d time.Duration) error {
+	if d <= 0 {
+		return errors.NotValidf("freeze duration %v", d)
+	}
+	secs := int((d + time.Second - 1) / time.Second)
+	return freeze(session, secs)
+}
+
+// Unfreeze allows the member that the session is connected to, which
+// should be a direct session, to seek election again after Freeze. It is
+// not an error to unfreeze a member that is not frozen.
+func Unfreeze(session *mgo.Session) error {
+	return freeze(session, 0)
+}
+
+// freeze runs replSetFreeze with the given number of seconds.
+func freeze(session *mgo.Session, secs int) error {
+	return errors.Annotate(runCommand(session, bson.D{{"replSetFreeze", secs}}, nil), "replSetFreeze")
+}
+
+// EnterMaintenanceMode puts the member that the session is connected to,
+// which should be a direct session to a secondary, in maintenance mode: it
+// goes into the RECOVERING state, so that it stops serving reads, while
+// it keeps replicating. This is useful to build indexes on secondaries one
+// at a time. Mongo counts maintenance requests, so the member only leaves
+// maintenance mode once ExitMaintenanceMode has been called as many times
+// as EnterMaintenanceMode.
+func EnterMaintenanceMode(session *mgo.Session) error {
+	return errors.Annotate(runCommand(session, bson.D{{"replSetMaintenance", true}}, nil), "replSetMaintenance")
+}
+
+// ExitMaintenanceMode undoes one call to EnterMaintenanceMode for the
+// member that the session is connected to, which should be a direct
+// session. It is not an error to call it for a member that is not in
+// maintenance mode.
+func ExitMaintenanceMode(session *mgo.Session) error {
+	_, err := exitMaintenanceMode(session)
+	return err
+}
+
+// exitMaintenanceMode implements ExitMaintenanceMode, also reporting
+// whether mongo said that the member was already out of maintenance mode.
+func exitMaintenanceMode(session *mgo.Session) (bool, error) {
+	err := runCommand(session, bson.D{{"replSetMaintenance", false}}, nil)
+	// Members may be RECOVERING for reasons other than maintenance
+	// mode, in which case mongo refuses to leave maintenance mode.
+	if err != nil && strings.Contains(err.Error(), "already out of maintenance mode") {
+		return true, nil
+	}
+	return false, errors.Annotate(err, "replSetMaintenance")
+}
+
+// clearMaintenanceMode takes the member that the session is connected to
+// out of maintenance mode. Mongo counts maintenance requests, so
+// ExitMaintenanceMode is called until the member reports no outstanding
+// requests or mongo says that it is already out of maintenance mode.
+func clearMaintenanceMode(session *mgo.Session) error {
+	for {
+		out, err := exitMaintenanceMode(session)
+		if err != nil || out {
+			return err
+		}
+		self, err := SelfStatus(session)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if self.MaintenanceMode == 0 {
+			return nil
+		}
+	}
 }
 
-// StepDownPrimary asks the current mongo primary to step down.
-// Note that triggering a step down causes all client connections to be
-// disconnected. We explicitly treat the io.EOF we get as not being an error,
-// but all other sessions will also be disconnected.
-func StepDownPrimary(session *mgo.Session) error {
-	strictSession := session.Clone()
-	defer strictSession.Close()
-	// StepDown can only be called on the primary
-	session.SetMode(mgo.Primary, true)
-	// replSetStepDown takes a few optional parameters that vary based on what
-	// version of Mongo is running. In Mongo 2.4 it just takes the "step down
-	// seconds" which claims to default to 60s.
-	// In 3.2 it can also take secondaryCatchUpPeriodSecs which is supposed to
-	// start at 10s. However, testing shows that not passing either gives:
-	// err{"stepdown period must be longer than secondaryCatchUpPeriodSecs"}
-	err := session.Run(bson.D{{"replSetStepDown", 60.0}}, nil)
-	// we expect to get io.EOF so don't treat it as a failure.
-	if err == io.EOF {
-		return nil
+// ClearHolds returns the reachable members of the session's replica set to
+// normal operation: secondaries that were frozen with replSetFreeze are
+// unfrozen, and members in maintenance mode (which are reported as
+// RECOVERING) are taken out of it, however many maintenance requests they
+// have outstanding. Each member is dialed directly, without
+// credentials.
+//
+// Members that are unhealthy are skipped. Failures for individual members
+// do not stop the others from being processed; they are all reported in
+// the returned error.
+func ClearHolds(session *mgo.Session) error {
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return errors.Trace(err)
 	}
-	return err
+	var failures []string
+	for _, member := range status.Members {
+		if !member.Healthy {
+			logger.Debugf("ClearHolds: skipping unhealthy member %s", member.Address)
+			continue
+		}
+		if member.State != SecondaryState && member.State != RecoveringState {
+			continue
+		}
+		if err := clearMemberHolds(member); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", member.Address, err))
+		}
+	}
+	if len(failures) > 0 {
+		return errors.Errorf("cannot clear holds: %s", strings.Join(failures, "; "))
+	}
+	return nil
 }
 
-// CurrentStatus returns the status of the replica set for the given session.
-func CurrentStatus(session *mgo.Session) (*Status, error) {
-	status := &Status{}
-	err := session.Run("replSetGetStatus", status)
+// clearMemberHolds unfreezes the given member and takes it out of
+// maintenance mode if it is RECOVERING.
+func clearMemberHolds(member MemberStatus) error {
+	memberSession, err := dialMember(member.Address, clearHoldsTimeout)
 	if err != nil {
-		return nil, fmt.Errorf("cannot get replica set status: %v", err)
+		return err
 	}
+	defer memberSession.Close()
 
-	for index, member := range status.Members {
-		status.Members[index].Address = formatIPv6AddressWithBrackets(member.Address)
+	if err := Unfreeze(memberSession); err != nil {
+		return errors.Trace(err)
 	}
-	return status, nil
+	if member.State == RecoveringState {
+		if err := clearMaintenanceMode(memberSession); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
 }
 
 // Status holds data about the status of members of the replica set returned
@@ -490,46 +2808,210 @@ func CurrentStatus(session *mgo.Session) (*Status, error) {
 //
 // See http://docs.mongodb.org/manual/reference/command/replSetGetStatus/#dbcmd.replSetGetStatus
 type Status struct {
-	Name    string         `bson:"set"`
-	Members []MemberStatus `bson:"members"`
+	Name    string         `bson:"set" json:"name"`
+	Members []MemberStatus `bson:"members" json:"members"`
+
+	// WriteMajorityCount holds the number of members that must acknowledge
+	// a {w: "majority"} write. It is only reported by MongoDB 4.2.1 and
+	// later, and is zero otherwise.
+	WriteMajorityCount int `bson:"writeMajorityCount" json:"writeMajorityCount"`
+
+	// VotingMembersCount holds the number of voting members that can
+	// acknowledge writes, i.e. voting members that are not arbiters. It is
+	// only reported by MongoDB 4.2.1 and later, and is zero otherwise.
+	VotingMembersCount int `bson:"writableVotingMembersCount" json:"votingMembersCount"`
+
+	// Optimes holds the replication progress of the replica set as a
+	// whole, as seen by the member that the session is connected to.
+	Optimes Optimes `bson:"optimes" json:"optimes"`
+
+	// LastStableRecoveryTimestamp holds the timestamp of the most recent
+	// stable checkpoint of the member that the session is connected to,
+	// which it can recover to without a resync. It is only reported by
+	// MongoDB 4.2 and later on WiredTiger, and is zero otherwise.
+	LastStableRecoveryTimestamp bson.MongoTimestamp `bson:"lastStableRecoveryTimestamp" json:"lastStableRecoveryTimestamp"`
+}
+
+// OpTime identifies an operation in the oplog.
+type OpTime struct {
+	// Timestamp holds the timestamp of the operation.
+	Timestamp bson.MongoTimestamp `bson:"ts" json:"ts"`
+
+	// Term holds the election term in which the operation was written.
+	Term int64 `bson:"t" json:"t"`
+}
+
+// Optimes holds the top level optimes reported by replSetGetStatus.
+type Optimes struct {
+	// LastCommitted holds the most recent operation that has been
+	// written to a majority of the members.
+	LastCommitted OpTime `bson:"lastCommittedOpTime" json:"lastCommitted"`
+
+	// ReadConcernMajority holds the most recent operation that can be
+	// read with a "majority" read concern.
+	ReadConcernMajority OpTime `bson:"readConcernMajorityOpTime" json:"readConcernMajority"`
+
+	// Applied holds the most recent operation applied by the member.
+	Applied OpTime `bson:"appliedOpTime" json:"applied"`
+
+	// Durable holds the most recent operation written to the journal
+	// of the member.
+	Durable OpTime `bson:"durableOpTime" json:"durable"`
 }
 
 // Status holds the status of a replica set member returned from
 // replSetGetStatus.
 type MemberStatus struct {
 	// Id holds the replica set id of the member that the status is describing.
-	Id int `bson:"_id"`
+	Id int `bson:"_id" json:"id"`
 
 	// Address holds address of the member that the status is describing.
-	Address string `bson:"name"`
+	Address string `bson:"name" json:"address"`
 
 	// Self holds whether this is the status for the member that
 	// the session is connected to.
-	Self bool `bson:"self"`
+	Self bool `bson:"self" json:"self"`
 
 	// ErrMsg holds the most recent error or status message received
 	// from the member.
-	ErrMsg string `bson:"errmsg"`
+	ErrMsg string `bson:"errmsg" json:"errmsg,omitempty"`
 
 	// Healthy reports whether the member is up. It is true for the
 	// member that the request was made to.
-	Healthy bool `bson:"health"`
+	Healthy bool `bson:"health" json:"healthy"`
 
 	// State describes the current state of the member.
-	State MemberState `bson:"state"`
+	State MemberState `bson:"state" json:"state"`
 
 	// Uptime describes how long the member has been online.
-	Uptime time.Duration `bson:"uptime"`
+	Uptime time.Duration `bson:"uptime" json:"uptime"`
 
 	// Ping describes the length of time a round-trip packet takes to travel
 	// between the remote member and the local instance.  It is zero for the
 	// member that the session is connected to.
-	Ping time.Duration `bson:"pingMS"`
+	Ping time.Duration `bson:"pingMS" json:"ping"`
+
+	// ConfigVersion holds the version of the replica set config that the
+	// member currently has. It is zero if the server does not report it.
+	ConfigVersion int `bson:"configVersion" json:"configVersion"`
+
+	// ConfigTerm holds the term of the replica set config that the member
+	// currently has. It is only reported by MongoDB 4.4 and later, and is
+	// zero otherwise.
+	ConfigTerm int64 `bson:"configTerm" json:"configTerm"`
+
+	// MaintenanceMode holds the number of outstanding maintenance mode
+	// requests of the member, as made by EnterMaintenanceMode. A member
+	// with a positive count is RECOVERING because of maintenance. Mongo
+	// only reports it for the member that the session is connected to,
+	// so it is zero for the other members.
+	MaintenanceMode int `bson:"maintenanceMode" json:"maintenanceMode,omitempty"`
+
+	// LastHeartbeat holds when the member that the session is connected
+	// to last sent a heartbeat to the member and got a reply. It is zero
+	// for the member that the session is connected to.
+	LastHeartbeat time.Time `bson:"lastHeartbeat" json:"lastHeartbeat"`
+
+	// LastHeartbeatRecv holds when the last heartbeat was received from
+	// the member. It is zero for the member that the session is connected
+	// to and for members that have never been heard from.
+	LastHeartbeatRecv time.Time `bson:"lastHeartbeatRecv" json:"lastHeartbeatRecv"`
+
+	// OptimeApplied holds the time of the last operation from the oplog
+	// that the member has applied. It is zero for arbiters.
+	OptimeApplied time.Time `bson:"optimeDate" json:"optimeApplied"`
+
+	// OptimeDurable holds the time of the last operation from the oplog
+	// that the member has written to its journal. Servers that do not
+	// report it separately (before MongoDB 3.4) get OptimeApplied instead.
+	OptimeDurable time.Time `bson:"optimeDurableDate" json:"optimeDurable"`
+
+	// SyncSource holds the address of the member that this member is
+	// replicating from. It is empty for the primary, for arbiters and
+	// for members that are not currently syncing. Servers before MongoDB
+	// 4.4 report it as syncingTo, which is also accepted.
+	SyncSource string `bson:"syncSourceHost" json:"syncSource,omitempty"`
+
+	// InfoMessage holds the latest informational message reported about
+	// the member, such as why it is not syncing or cannot choose a sync
+	// source. It is empty when there is nothing to report.
+	InfoMessage string `bson:"infoMessage" json:"infoMessage,omitempty"`
+
+	// StableRecoveryTimestamp holds the time of the most recent stable
+	// checkpoint of the member, which bounds how far back it can recover
+	// without a resync. mongo only reports it for the member that the
+	// session is connected to, so it is zero for the other members, and
+	// for servers and storage engines that do not report it.
+	StableRecoveryTimestamp time.Time `bson:"-" json:"stableRecoveryTimestamp"`
+
+	// Votes holds the number of votes the member has in elections, taken
+	// from the replica set config. It is only set by
+	// CurrentStatusWithVoting.
+	Votes int `bson:"-" json:"votes"`
+
+	// Electable reports whether the member could currently become
+	// primary: it is healthy, is the primary or a secondary, and has
+	// votes and a priority above 0 in the replica set config. It is only
+	// set by CurrentStatusWithVoting.
+	Electable bool `bson:"-" json:"electable"`
+
+	// parseErr holds the error encountered when parsing the member's
+	// status, if any.
+	parseErr error
+}
+
+// plainMemberStatus has the same fields as MemberStatus but none of its
+// methods, so it can be unmarshalled with the default bson behaviour.
+type plainMemberStatus MemberStatus
+
+// SetBSON implements bson.Setter. So that the status of the other members
+// can still be used, it never fails: a member status that cannot be parsed,
+// or that lacks its name or state, is recorded with UnknownState and an
+// ErrMsg describing the problem.
+func (m *MemberStatus) SetBSON(raw bson.Raw) error {
+	var member plainMemberStatus
+	err := raw.Unmarshal(&member)
+	if err == nil {
+		var required struct {
+			Address *string `bson:"name"`
+			State   *int    `bson:"state"`
+		}
+		err = raw.Unmarshal(&required)
+		if err == nil && (required.Address == nil || required.State == nil) {
+			err = errors.New("missing name or state")
+		}
+	}
+	if err != nil {
+		// Keep whatever identifies the member.
+		var partial struct {
+			Id      int    `bson:"_id"`
+			Address string `bson:"name"`
+		}
+		raw.Unmarshal(&partial)
+		*m = MemberStatus{
+			Id:       partial.Id,
+			Address:  partial.Address,
+			State:    UnknownState,
+			ErrMsg:   fmt.Sprintf("cannot parse member status: %v", err),
+			parseErr: err,
+		}
+		return nil
+	}
+	if member.SyncSource == "" {
+		var legacy struct {
+			SyncingTo string `bson:"syncingTo"`
+		}
+		raw.Unmarshal(&legacy)
+		member.SyncSource = legacy.SyncingTo
+	}
+	*m = MemberStatus(member)
+	return nil
 }
 
 // IsReady checks on the status of all members in the replicaset
-// associated with the provided session. If we can connect and the majority of
-// members are ready then the result is true.
+// associated with the provided session. If we can connect and the members
+// holding a majority of the votes are ready then the result is true.
+// Members with no votes do not count towards the majority.
 func IsReady(session *mgo.Session) (bool, error) {
 	status, err := getCurrentStatus(session)
 	if isConnectionNotAvailable(err) {
@@ -538,26 +3020,288 @@ func IsReady(session *mgo.Session) (bool, error) {
 		session.Refresh()
 		return false, nil
 	}
-	if err != nil {
-		// Fail for any other reason.
+	if err != nil && !IsPartialStatus(err) {
+		// Fail for any other reason. Members whose status could not be
+		// parsed are counted as unhealthy.
+		return false, errors.Trace(err)
+	}
+
+	votes := countVotes(status, currentConfigForVotes(session))
+	if votes.healthy < votes.majority() {
+		logger.Errorf("not enough members ready")
+		return false, nil
+	}
+	return true, nil
+}
+
+// IsSingleNodeReady reports whether the session's replica set, which must
+// have a single member, is ready: that member is healthy and is the
+// primary. Unlike IsReady, it does not report a member that is still
+// being elected as ready, so it does not flap while a single-node
+// development replica set starts up. An error satisfying
+// errors.IsNotValid is returned if the replica set has more than one
+// member.
+func IsSingleNodeReady(session *mgo.Session) (bool, error) {
+	status, err := getCurrentStatus(session)
+	if isConnectionNotAvailable(err) {
+		logger.Errorf("DB connection dropped so reconnecting")
+		session.Refresh()
+		return false, nil
+	}
+	if err != nil && !IsPartialStatus(err) {
+		return false, errors.Trace(err)
+	}
+	switch len(status.Members) {
+	case 0:
+		return false, nil
+	case 1:
+		member := status.Members[0]
+		return member.Healthy && member.State == PrimaryState, nil
+	}
+	return false, errors.NotValidf("single node check of replica set with %d members", len(status.Members))
+}
+
+// IsWritable checks on the status of all members in the replicaset
+// associated with the provided session. Unlike IsReady, it only reports true
+// when a healthy PRIMARY exists, so that it is false while an election is in
+// progress. It also requires that the healthy data-bearing members, i.e.
+// excluding arbiters, hold a majority of the votes, so that writes with a
+// majority write concern can be acknowledged.
+func IsWritable(session *mgo.Session) (bool, error) {
+	status, err := getCurrentStatus(session)
+	if isConnectionNotAvailable(err) {
+		// The connection dropped...
+		logger.Errorf("DB connection dropped so reconnecting")
+		session.Refresh()
+		return false, nil
+	}
+	if err != nil && !IsPartialStatus(err) {
+		// Fail for any other reason. Members whose status could not be
+		// parsed are counted as unhealthy.
 		return false, errors.Trace(err)
 	}
 
-	majority := (len(status.Members) / 2) + 1
-	healthy := 0
-	// Check the members.
+	hasPrimary := false
 	for _, member := range status.Members {
-		if member.Healthy {
-			healthy += 1
+		if member.State == PrimaryState && member.Healthy {
+			hasPrimary = true
+			break
 		}
 	}
-	if healthy < majority {
-		logger.Errorf("not enough members ready")
+	if !hasPrimary {
+		logger.Errorf("no primary member found")
+		return false, nil
+	}
+	votes := countVotes(status, currentConfigForVotes(session))
+	if votes.healthyData < votes.majority() {
+		logger.Errorf("not enough data-bearing members ready")
 		return false, nil
 	}
 	return true, nil
 }
 
+// WritableFromMemberView gives a best-effort assessment of whether the
+// replica set is writable, as seen by the member that the given session is
+// connected to, which need not be the primary. This is useful when the
+// primary cannot be reached directly, for example during an asymmetric
+// network partition. It returns the address of the member's primary, as
+// reported by isMaster, and whether, according to the member's status,
+// that primary is healthy and the healthy data-bearing members hold a
+// majority of the votes. The session should be a direct session to the
+// member.
+func WritableFromMemberView(session *mgo.Session) (primary string, writable bool, err error) {
+	results, err := IsMaster(session)
+	if err != nil {
+		return "", false, errors.Trace(err)
+	}
+	if results.PrimaryAddress == "" {
+		return "", false, nil
+	}
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return results.PrimaryAddress, false, errors.Trace(err)
+	}
+	member := findMemberStatus(status, results.PrimaryAddress)
+	if member == nil || !member.Healthy || member.State != PrimaryState {
+		return results.PrimaryAddress, false, nil
+	}
+	votes := countVotes(status, currentConfigForVotes(session))
+	return results.PrimaryAddress, votes.healthyData >= votes.majority(), nil
+}
+
+// currentConfigForVotes returns the current config of the session's replica
+// set, so that the votes of the members can be taken into account. If the
+// config cannot be read, nil is returned and every member is assumed to
+// have one vote.
+func currentConfigForVotes(session *mgo.Session) *Config {
+	config, err := CurrentConfig(session)
+	if err != nil {
+		logger.Warningf("cannot get replica set config, assuming one vote per member: %v", err)
+		return nil
+	}
+	return config
+}
+
+// FaultTolerance returns the number of voting members of the session's
+// replica set that can fail before it loses its majority, and so its
+// primary, according to the current config.
+func FaultTolerance(session *mgo.Session) (int, error) {
+	config, err := CurrentConfig(session)
+	if err != nil {
+		return 0, err
+	}
+	voting := 0
+	for _, member := range config.Members {
+		if memberVotes(member) > 0 {
+			voting++
+		}
+	}
+	if voting == 0 {
+		return 0, nil
+	}
+	return (voting - 1) / 2, nil
+}
+
+// CurrentFaultTolerance returns how many more voting data-bearing members
+// of the replica set with the given status can fail before it loses write
+// availability, taking into account the members that are currently
+// unhealthy. The votes of each member are taken from the given config;
+// if config is nil, each member is assumed to have one vote. It returns 0
+// if the replica set cannot absorb any more failures, including when it
+// has already lost write availability.
+func CurrentFaultTolerance(status *Status, config *Config) int {
+	counts := countVotes(status, config)
+	if counts.healthyData <= counts.majority() {
+		return 0
+	}
+	return counts.healthyData - counts.majority()
+}
+
+// voteCounts holds the number of votes held by the members of a replica set.
+type voteCounts struct {
+	// total holds the number of votes held by all members.
+	total int
+
+	// healthy holds the number of votes held by healthy members.
+	healthy int
+
+	// healthyData holds the number of votes held by healthy members that
+	// are not arbiters.
+	healthyData int
+}
+
+// majority returns the number of votes that make up a majority.
+func (v voteCounts) majority() int {
+	return v.total/2 + 1
+}
+
+// countVotes counts the votes held by the members of the given status,
+// taking the votes and arbiter flag of each member from the given config.
+// Members not in the config, or all members if config is nil, are assumed
+// to have one vote.
+func countVotes(status *Status, config *Config) voteCounts {
+	votes := make(map[int]int)
+	arbiters := make(map[int]bool)
+	if config != nil {
+		for _, member := range config.Members {
+			votes[member.Id] = memberVotes(member)
+			arbiters[member.Id] = member.Arbiter != nil && *member.Arbiter
+		}
+	}
+	var counts voteCounts
+	for _, member := range status.Members {
+		v, ok := votes[member.Id]
+		if !ok {
+			v = 1
+		}
+		counts.total += v
+		if !member.Healthy {
+			continue
+		}
+		counts.healthy += v
+		if member.State != ArbiterState && !arbiters[member.Id] {
+			counts.healthyData += v
+		}
+	}
+	return counts
+}
+
+// SetState is a coarse summary of the operational state of a replica set.
+type SetState int
+
+const (
+	// Healthy means that all members are healthy and there is a primary.
+	Healthy SetState = iota
+
+	// Degraded means that there is a primary and a majority of the votes
+	// are held by healthy members, but some members are unhealthy or not
+	// yet serving, or too few data-bearing members are healthy for
+	// majority writes to be acknowledged.
+	Degraded
+
+	// ReadOnly means that a majority of the votes are held by healthy
+	// members but there is no primary, for example during an election.
+	ReadOnly
+
+	// Unavailable means that the healthy members do not hold a majority
+	// of the votes, so no primary can be elected.
+	Unavailable
+)
+
+var setStateStrings = []string{
+	Healthy:     "healthy",
+	Degraded:    "degraded",
+	ReadOnly:    "read-only",
+	Unavailable: "unavailable",
+}
+
+// String implements fmt.Stringer.
+func (state SetState) String() string {
+	if state < 0 || int(state) >= len(setStateStrings) {
+		return "invalid"
+	}
+	return setStateStrings[state]
+}
+
+// OperationalState returns a summary of the operational state of the
+// session's replica set, computed from its status and the votes of its
+// members. Members whose status cannot be parsed are counted as unhealthy.
+func OperationalState(session *mgo.Session) (SetState, error) {
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return Unavailable, errors.Trace(err)
+	}
+	return operationalState(status, currentConfigForVotes(session)), nil
+}
+
+// operationalState returns the operational state of the replica set with
+// the given status, taking the votes of its members from the given config.
+func operationalState(status *Status, config *Config) SetState {
+	votes := countVotes(status, config)
+	if votes.healthy < votes.majority() {
+		return Unavailable
+	}
+	hasPrimary := false
+	allServing := true
+	for _, member := range status.Members {
+		switch {
+		case !member.Healthy:
+			allServing = false
+		case member.State == PrimaryState:
+			hasPrimary = true
+		case member.State != SecondaryState && member.State != ArbiterState:
+			allServing = false
+		}
+	}
+	if !hasPrimary {
+		return ReadOnly
+	}
+	if !allServing || votes.healthyData < votes.majority() {
+		return Degraded
+	}
+	return Healthy
+}
+
 var connectionErrors = []syscall.Errno{
 	syscall.ECONNABORTED, // "software caused connection abort"
 	syscall.ECONNREFUSED, // "connection refused"
@@ -606,6 +3350,222 @@ func WaitUntilReady(session *mgo.Session, timeout int) error {
 	return nil
 }
 
+// WaitUntilReadyStrategy is like WaitUntilReady, but checks whether the
+// members are ready according to the given strategy, so that the total
+// time and delay between checks can be tuned as for other retries.
+func WaitUntilReadyStrategy(session *mgo.Session, strategy utils.AttemptStrategy) error {
+	start := time.Now()
+	attemptCount := 0
+	for a := strategy.Start(); a.Next(); {
+		attemptCount++
+		ready, err := isReady(session)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if ready {
+			return nil
+		}
+	}
+	return errors.Errorf("replica set not ready after %d attempts in %v", attemptCount, time.Since(start))
+}
+
+// waitUntilWritable waits until the replica set is writable, as reported
+// by IsWritable, or the timeout is reached. If progress is not nil, it is
+// called each time the replica set is found not to be writable.
+func waitUntilWritable(session *mgo.Session, timeout time.Duration, progress ProgressFunc) error {
+	attempts := utils.AttemptStrategy{
+		Delay: writableAttemptDelay,
+		Total: timeout,
+	}
+	for a := attempts.Start(); a.Next(); {
+		writable, err := isWritable(session)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if writable {
+			return nil
+		}
+		reportProgress(session, progress, writablePhase)
+	}
+	return errors.Errorf("timed out after %v waiting for the replica set to be writable", timeout)
+}
+
+// WaitForConfigCommitted waits until the current config of the session's
+// replica set has been committed to a majority of the members, so that it
+// is safe to start another reconfig. MongoDB 4.4 and later report this
+// directly; for older servers, the config is considered committed once a
+// majority of the members report its version in replSetGetStatus.
+func WaitForConfigCommitted(session *mgo.Session, timeout time.Duration) error {
+	return waitForConfigCommitted(session, timeout, nil)
+}
+
+// waitForConfigCommitted is like WaitForConfigCommitted, but also calls
+// progress, if it is not nil, each time the config is found not to be
+// committed yet.
+func waitForConfigCommitted(session *mgo.Session, timeout time.Duration, progress ProgressFunc) error {
+	attempts := utils.AttemptStrategy{
+		Delay: configVersionAttemptDelay,
+		Total: timeout,
+	}
+	for a := attempts.Start(); a.Next(); {
+		config, committed, err := getConfigWithCommitment(session)
+		if isConnectionNotAvailable(err) {
+			logger.Errorf("DB connection dropped so reconnecting")
+			session.Refresh()
+			continue
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if committed == nil {
+			committed, err = majorityHasConfigVersion(session, config.Version)
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+		if *committed {
+			return nil
+		}
+		reportProgress(session, progress, commitPhase)
+	}
+	return errors.Errorf("timed out after %v waiting for the config to be committed", timeout)
+}
+
+// majorityHasConfigVersion reports whether a majority of the members of
+// the session's replica set report a config version of at least the given
+// version.
+func majorityHasConfigVersion(session *mgo.Session, version int) (*bool, error) {
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return nil, errors.Trace(err)
+	}
+	upToDate := 0
+	for _, member := range status.Members {
+		if member.Healthy && member.ConfigVersion >= version {
+			upToDate++
+		}
+	}
+	committed := upToDate > len(status.Members)/2
+	return &committed, nil
+}
+
+// WaitForConfigVersion waits until all healthy members of the replica set
+// report a config version of at least the given version, as seen by
+// replSetGetStatus. On timeout, the returned error names the members that
+// had not caught up.
+func WaitForConfigVersion(session *mgo.Session, version int, timeout time.Duration) error {
+	attempts := utils.AttemptStrategy{
+		Delay: configVersionAttemptDelay,
+		Total: timeout,
+	}
+	var stragglers []string
+	for a := attempts.Start(); a.Next(); {
+		status, err := getCurrentStatus(session)
+		if isConnectionNotAvailable(err) {
+			logger.Errorf("DB connection dropped so reconnecting")
+			session.Refresh()
+			continue
+		}
+		if err != nil && !IsPartialStatus(err) {
+			return errors.Trace(err)
+		}
+		stragglers = stragglers[:0]
+		for _, member := range status.Members {
+			if member.Healthy && member.ConfigVersion < version {
+				stragglers = append(stragglers,
+					fmt.Sprintf("%s (version %d)", member.Address, member.ConfigVersion))
+			}
+		}
+		if len(stragglers) == 0 {
+			return nil
+		}
+	}
+	if len(stragglers) == 0 {
+		return errors.Errorf("timed out after %v waiting for config version %d", timeout, version)
+	}
+	return errors.Errorf("timed out after %v waiting for config version %d on %s",
+		timeout, version, strings.Join(stragglers, ", "))
+}
+
+// SyncTopology returns the address of each member of the replica set
+// mapped to the address of the member it is replicating from, as reported
+// by replSetGetStatus. The primary, arbiters and members that are not
+// syncing map to the empty string.
+func SyncTopology(session *mgo.Session) (map[string]string, error) {
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return nil, errors.Trace(err)
+	}
+	return syncTopology(status), nil
+}
+
+// syncTopology returns the sync source of each member of the given status,
+// keyed by member address.
+func syncTopology(status *Status) map[string]string {
+	topology := make(map[string]string, len(status.Members))
+	for _, member := range status.Members {
+		if member.State == PrimaryState {
+			topology[member.Address] = ""
+			continue
+		}
+		topology[member.Address] = member.SyncSource
+	}
+	return topology
+}
+
+// IsChaining reports whether any member of the replica set is replicating
+// from a member other than the primary, along with the sorted addresses
+// of those members. Chaining may only happen when the chainingAllowed
+// setting is true, but that setting does not mean it is happening.
+func IsChaining(session *mgo.Session) (bool, []string, error) {
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return false, nil, errors.Trace(err)
+	}
+	primary := primaryAddress(status)
+	var chained []string
+	for addr, source := range syncTopology(status) {
+		if source != "" && source != primary {
+			chained = append(chained, addr)
+		}
+	}
+	sort.Strings(chained)
+	return len(chained) > 0, chained, nil
+}
+
+// ConfigIsConsistent reports whether all healthy members of the replica set
+// have the same config version and term as the primary, as seen by
+// replSetGetStatus. It returns false if there is no primary.
+func ConfigIsConsistent(session *mgo.Session) (bool, error) {
+	status, err := getCurrentStatus(session)
+	if err != nil && !IsPartialStatus(err) {
+		return false, errors.Trace(err)
+	}
+	var primary *MemberStatus
+	for i, member := range status.Members {
+		if member.State == PrimaryState {
+			primary = &status.Members[i]
+			break
+		}
+	}
+	if primary == nil {
+		logger.Debugf("no primary member found")
+		return false, nil
+	}
+	for _, member := range status.Members {
+		if !member.Healthy {
+			continue
+		}
+		if member.ConfigVersion != primary.ConfigVersion || member.ConfigTerm != primary.ConfigTerm {
+			logger.Debugf("member %s has config version %d term %d, primary has version %d term %d",
+				member.Address, member.ConfigVersion, member.ConfigTerm,
+				primary.ConfigVersion, primary.ConfigTerm)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 // MemberState represents the state of a replica set member.
 // See http://docs.mongodb.org/manual/reference/replica-states/
 type MemberState int
@@ -646,6 +3606,35 @@ func (state MemberState) String() string {
 	return memberStateStrings[state]
 }
 
+// MarshalJSON implements json.Marshaler, encoding the state as its name.
+func (state MemberState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(state.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts either the name of
+// the state, as written by MarshalJSON, or its numeric code.
+func (state *MemberState) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		var code int
+		if err := json.Unmarshal(data, &code); err != nil {
+			return errors.Errorf("cannot parse member state %s", data)
+		}
+		if code < 0 || code >= len(memberStateStrings) {
+			return errors.Errorf("invalid member state %d", code)
+		}
+		*state = MemberState(code)
+		return nil
+	}
+	for code, stateName := range memberStateStrings {
+		if stateName == name {
+			*state = MemberState(code)
+			return nil
+		}
+	}
+	return errors.Errorf("invalid member state %q", name)
+}
+
 // formatIPv6AddressWithoutBrackets turns correctly formatted IPv6 addresses
 // into the "bad format" (without brackets around the address) that mongo <2.7
 // require use.
@@ -4,11 +4,14 @@
 package replicaset
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	stdtesting "testing"
 	"time"
 
@@ -18,6 +21,7 @@ import (
 	"github.com/juju/utils"
 	gc "gopkg.in/check.v1"
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
 const rsName = "juju"
@@ -132,6 +136,75 @@ func (s *MongoSuite) TestInitiateWaitsForStatus(c *gc.C) {
 	c.Assert(i, gc.Equals, 21)
 }
 
+func (s *MongoSuite) TestInitiateNoWait(c *gc.C) {
+	s.root.Destroy()
+
+	// create a new server that hasn't been initiated
+	s.root = newServer(c)
+	session := s.root.MustDialDirect()
+	defer session.Close()
+
+	called := false
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		called = true
+		return nil, fmt.Errorf("bang!")
+	})
+	err := InitiateNoWait(session, s.root.Addr(), rsName, initialTags)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(called, jc.IsFalse)
+}
+
+func (s *MongoSuite) TestInitiateWaitCommitted(c *gc.C) {
+	s.root.Destroy()
+
+	// create a new server that hasn't been initiated
+	s.root = newServer(c)
+	session := s.root.MustDialDirect()
+	defer session.Close()
+
+	err := InitiateWithOptions(session, s.root.Addr(), rsName, initialTags, InitiateOptions{
+		WaitCommitted: true,
+		WaitTimeout:   time.Minute,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	_, committed, err := CurrentConfigWithCommitment(session)
+	c.Assert(err, jc.ErrorIsNil)
+	if committed != nil {
+		c.Check(*committed, jc.IsTrue)
+	}
+}
+
+func (s *MongoSuite) TestReplicaSetName(c *gc.C) {
+	session := s.root.MustDialDirect()
+	defer session.Close()
+
+	name, err := ReplicaSetName(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(name, gc.Equals, rsName)
+
+	inst := newServer(c)
+	defer inst.Destroy()
+	uninitiated := inst.MustDialDirect()
+	defer uninitiated.Close()
+
+	_, err = ReplicaSetName(uninitiated)
+	c.Check(err, gc.Equals, ErrMasterNotConfigured)
+}
+
+func (s *MongoSuite) TestInitiateTwice(c *gc.C) {
+	session := s.root.MustDialDirect()
+	defer session.Close()
+
+	// The replica set was initiated by SetUpTest.
+	err := Initiate(session, s.root.Addr(), rsName, initialTags)
+	c.Assert(err, gc.Equals, ErrAlreadyInitiated)
+
+	cfg, err := CurrentConfig(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(cfg.Version, gc.Equals, 1)
+}
+
 func loadData(session *mgo.Session, c *gc.C) {
 	type foo struct {
 		Name    string
@@ -296,6 +369,252 @@ func assertAddRemoveSet(c *gc.C, root *testing.MgoInstance, getAddr func(*testin
 	assertMembers(c, mems, expectedMembers)
 }
 
+func (s *MongoSuite) TestCurrentMembersMap(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	members, err := CurrentMembersMap(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(members, gc.HasLen, 1)
+	member, ok := members[s.root.Addr()]
+	c.Assert(ok, jc.IsTrue)
+	c.Check(member.Id, gc.Equals, 1)
+	c.Check(member.Tags, jc.DeepEquals, initialTags)
+}
+
+func (s *MongoSuite) TestPingMembers(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	rtts, err := PingMembers(session, 10*time.Second)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(rtts, gc.HasLen, 1)
+	_, ok := rtts[s.root.Addr()]
+	c.Check(ok, jc.IsTrue)
+}
+
+func (s *MongoSuite) TestApplyPriorityPolicy(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	err := ApplyPriorityPolicy(session, func(m Member) float64 {
+		if m.Tags["foo"] == "bar" {
+			return 2
+		}
+		return 1
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	mems, err := CurrentMembers(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(mems, gc.HasLen, 1)
+	c.Assert(mems[0].Priority, gc.NotNil)
+	c.Check(*mems[0].Priority, gc.Equals, 2.0)
+}
+
+func (s *MongoSuite) TestCordon(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	inst := newServer(c)
+	defer inst.Destroy()
+	defer Remove(session, inst.Addr())
+
+	var err error
+	strategy := utils.AttemptStrategy{Total: time.Minute * 2, Delay: time.Millisecond * 500}
+	for attempt := strategy.Start(); attempt.Next(); {
+		err = Add(session, Member{Address: inst.Addr()})
+		if err == nil || !attempt.HasNext() {
+			break
+		}
+	}
+	c.Assert(err, jc.ErrorIsNil)
+
+	restore, err := Cordon(session, inst.Addr())
+	c.Assert(err, jc.ErrorIsNil)
+
+	mems, err := CurrentMembers(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(mems, gc.HasLen, 2)
+	c.Assert(mems[1].Priority, gc.NotNil)
+	c.Check(*mems[1].Priority, gc.Equals, 0.0)
+	c.Assert(mems[1].Hidden, gc.NotNil)
+	c.Check(*mems[1].Hidden, jc.IsTrue)
+
+	err = restore()
+	c.Assert(err, jc.ErrorIsNil)
+
+	mems, err = CurrentMembers(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(mems, gc.HasLen, 2)
+	if mems[1].Priority != nil {
+		c.Check(*mems[1].Priority, gc.Equals, 1.0)
+	}
+	if mems[1].Hidden != nil {
+		c.Check(*mems[1].Hidden, jc.IsFalse)
+	}
+}
+
+func (s *MongoSuite) TestCordonUnknownMember(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	_, err := Cordon(session, "1.2.3.4:37017")
+	c.Check(err, jc.Satisfies, errors.IsNotFound)
+}
+
+func (s *MongoSuite) TestStepDownPrimaryPreservesSessionMode(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+	session.SetMode(mgo.Monotonic, true)
+
+	// A single member has no secondary to step down for, so this fails,
+	// but only after the mode has been chosen.
+	err := StepDownPrimaryWithOptions(session, StepDownOptions{StepDownPeriod: time.Second})
+	c.Assert(err, gc.NotNil)
+	c.Check(session.Mode(), gc.Equals, mgo.Monotonic)
+}
+
+func (s *MongoSuite) TestReadsPreserveSessionMode(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	for _, mode := range []mgo.Mode{mgo.Eventual, mgo.Monotonic, mgo.Strong} {
+		session.SetMode(mode, true)
+
+		_, err := CurrentConfig(session)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(session.Mode(), gc.Equals, mode)
+
+		_, err = CurrentConfigStrong(session)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(session.Mode(), gc.Equals, mode)
+
+		_, err = CurrentMembers(session)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(session.Mode(), gc.Equals, mode)
+
+		_, err = CurrentStatus(session)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(session.Mode(), gc.Equals, mode)
+
+		_, err = CurrentStatusStrong(session)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(session.Mode(), gc.Equals, mode)
+	}
+}
+
+func (s *MongoSuite) TestSetInReplicaSetNameMismatch(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	members := []Member{{Address: s.root.Addr(), Tags: initialTags}}
+	err := SetInReplicaSet(session, "not-"+rsName, members)
+	c.Assert(errors.Cause(err), gc.Equals, ErrReplicaSetNameMismatch)
+	c.Assert(err, gc.ErrorMatches, `expected replica set "not-juju", found "juju": replica set name mismatch`)
+
+	err = ReconfigureAtVersion(session, Config{Name: "not-" + rsName, Members: members}, 1)
+	c.Assert(errors.Cause(err), gc.Equals, ErrReplicaSetNameMismatch)
+
+	cfg, err := CurrentConfig(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(cfg.Version, gc.Equals, 1)
+}
+
+func (s *MongoSuite) TestCurrentConfigWithCommitment(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	cfg, committed, err := CurrentConfigWithCommitment(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(cfg.Name, gc.Equals, rsName)
+	assertMembers(c, cfg.Members, []Member{{Id: 1, Address: s.root.Addr(), Tags: initialTags}})
+
+	buildInfo, err := session.BuildInfo()
+	c.Assert(err, jc.ErrorIsNil)
+	if buildInfo.VersionAtLeast(4, 4) {
+		c.Assert(committed, gc.NotNil)
+		c.Check(*committed, jc.IsTrue)
+	} else {
+		c.Check(committed, gc.IsNil)
+	}
+}
+
+func (s *MongoSuite) TestAddResolvesAddresses(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	opts := ReconfigOptions{ResolveAddresses: true}
+	err := AddWithOptions(session, opts,
+		Member{Address: "127.0.0.1:37017"},
+		Member{Address: "no-such-host.invalid:37017"},
+	)
+	c.Assert(err, gc.ErrorMatches, `cannot resolve member addresses: no-such-host.invalid:37017 \(.*\)`)
+
+	err = SetWithOptions(session, opts, []Member{
+		{Address: s.root.Addr()},
+		{Address: "no-such-host.invalid:37017"},
+	})
+	c.Assert(err, gc.ErrorMatches, `cannot resolve member addresses: no-such-host.invalid:37017 \(.*\)`)
+
+	// The config must be untouched.
+	cfg, err := CurrentConfig(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(cfg.Version, gc.Equals, 1)
+}
+
+func (s *MongoSuite) TestAddWaitWritable(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	calls := 0
+	s.PatchValue(&isWritable, func(session *mgo.Session) (bool, error) {
+		calls++
+		return calls > 2, nil
+	})
+	opts := ReconfigOptions{WaitWritable: true, WaitTimeout: time.Minute}
+	err := AddWithOptions(session, opts, Member{Address: s.root.Addr()})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(calls, gc.Equals, 3)
+}
+
+func (s *MongoSuite) TestAddWaitWritableTimeout(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	s.PatchValue(&isWritable, func(session *mgo.Session) (bool, error) {
+		return false, nil
+	})
+	opts := ReconfigOptions{WaitWritable: true, WaitTimeout: time.Nanosecond}
+	err := AddWithOptions(session, opts, Member{Address: s.root.Addr()})
+	c.Assert(err, gc.ErrorMatches, "timed out after 1ns waiting for the replica set to be writable")
+}
+
+func (s *MongoSuite) TestReplaceRejectsLosingMajority(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	err := Replace(session, []string{s.root.Addr()}, []Member{{Address: "1.2.3.4:37017"}})
+	c.Assert(err, gc.ErrorMatches, "new config would leave 0 of 1 votes on healthy data-bearing members, need 1")
+
+	cfg, err := CurrentConfig(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(cfg.Version, gc.Equals, 1)
+}
+
+func (s *MongoSuite) TestAddIfMissingExisting(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	added, err := AddIfMissing(session, Member{Address: s.root.Addr()})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(added, jc.IsFalse)
+
+	cfg, err := CurrentConfig(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(cfg.Version, gc.Equals, 1)
+}
+
 func (s *MongoSuite) TestIsMaster(c *gc.C) {
 	session := s.root.MustDial()
 	defer session.Close()
@@ -334,6 +653,109 @@ func (s *MongoSuite) TestMasterHostPort(c *gc.C) {
 	c.Assert(result, gc.Equals, expected)
 }
 
+func (s *MongoSuite) TestIsPrimaryAddress(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	isPrimary, err := IsPrimaryAddress(session, s.root.Addr())
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(isPrimary, jc.IsTrue)
+
+	isPrimary, err = IsPrimaryAddress(session, "1.2.3.4:37017")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(isPrimary, jc.IsFalse)
+}
+
+func (s *MongoSuite) TestSetVia(c *gc.C) {
+	session := s.root.MustDialDirect()
+	defer session.Close()
+
+	members, err := CurrentMembers(session)
+	c.Assert(err, jc.ErrorIsNil)
+	members[0].Tags = map[string]string{"foo": "baz"}
+	err = SetVia(session, members)
+	c.Assert(err, jc.ErrorIsNil)
+
+	members, err = CurrentMembers(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(members[0].Tags, jc.DeepEquals, map[string]string{"foo": "baz"})
+}
+
+func (s *MongoSuite) TestSetViaNotPrimary(c *gc.C) {
+	inst := newServer(c)
+	defer inst.Destroy()
+	session := inst.MustDialDirect()
+	defer session.Close()
+
+	err := SetVia(session, []Member{{Address: inst.Addr()}})
+	c.Assert(errors.Cause(err), gc.Equals, ErrNotPrimary)
+	notPrimary, ok := err.(*NotPrimaryError)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(notPrimary.Primary, gc.Equals, "")
+	c.Check(err, gc.ErrorMatches, ".*is not the primary and there is no primary")
+}
+
+func (s *MongoSuite) TestEnsureNotPrimaryNotPrimary(c *gc.C) {
+	inst := newServer(c)
+	defer inst.Destroy()
+	session := inst.MustDialDirect()
+	defer session.Close()
+
+	s.PatchValue(&stepDownPrimary, func(session *mgo.Session) error {
+		c.Fatalf("unexpected step down")
+		return nil
+	})
+	err := EnsureNotPrimary(session, time.Minute)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *MongoSuite) TestEnsureNotPrimaryStepsDown(c *gc.C) {
+	session := s.root.MustDialDirect()
+	defer session.Close()
+
+	stepDownCalled := false
+	s.PatchValue(&stepDownPrimary, func(session *mgo.Session) error {
+		stepDownCalled = true
+		return nil
+	})
+	// The only member remains primary, so no new primary is found.
+	err := EnsureNotPrimary(session, time.Nanosecond)
+	c.Assert(err, gc.ErrorMatches, "timed out after 1ns waiting for a new primary")
+	c.Check(stepDownCalled, jc.IsTrue)
+}
+
+func (s *MongoSuite) TestSelfStatus(c *gc.C) {
+	session := s.root.MustDialDirect()
+	defer session.Close()
+
+	self, err := SelfStatus(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(self.Id, gc.Equals, 1)
+	c.Check(self.Address, gc.Equals, s.root.Addr())
+	c.Check(self.Self, jc.IsTrue)
+	c.Check(self.Healthy, jc.IsTrue)
+	c.Check(self.State, gc.Equals, MemberState(PrimaryState))
+	c.Check(self.ConfigVersion, gc.Not(gc.Equals), 0)
+}
+
+func (s *MongoSuite) TestSelfStatusWithoutReplicaSetStatus(c *gc.C) {
+	session := s.root.MustDialDirect()
+	defer session.Close()
+
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return nil, errors.New("boom")
+	})
+	self, err := SelfStatus(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(self, jc.DeepEquals, &MemberStatus{
+		Id:      1,
+		Address: s.root.Addr(),
+		Self:    true,
+		Healthy: true,
+		State:   PrimaryState,
+	})
+}
+
 func (s *MongoSuite) TestMasterHostPortOnUnconfiguredReplicaSet(c *gc.C) {
 	inst := &testing.MgoInstance{}
 	err := inst.Start(nil)
@@ -364,46 +786,89 @@ func (s *MongoSuite) TestIsReadyOne(c *gc.C) {
 	c.Check(ready, jc.IsTrue)
 }
 
-func (s *MongoSuite) TestIsReadyMultiple(c *gc.C) {
+func (s *MongoSuite) TestIsSingleNodeReady(c *gc.C) {
+	state := MemberState(SecondaryState)
 	s.PatchValue(&getCurrentStatus,
 		func(session *mgo.Session) (*Status, error) {
-			status := &Status{}
-			for i := 1; i < 5; i++ {
-				member := MemberStatus{Id: i + 1, Healthy: true}
-				status.Members = append(status.Members, member)
-			}
+			status := &Status{Members: []MemberStatus{{
+				Id:      1,
+				Healthy: true,
+				State:   state,
+			}}}
 			return status, nil
 		},
 	)
 	session := s.root.MustDial()
 	defer session.Close()
 
-	ready, err := IsReady(session)
+	ready, err := IsSingleNodeReady(session)
 	c.Assert(err, jc.ErrorIsNil)
+	c.Check(ready, jc.IsFalse)
 
+	state = PrimaryState
+	ready, err = IsSingleNodeReady(session)
+	c.Assert(err, jc.ErrorIsNil)
 	c.Check(ready, jc.IsTrue)
 }
 
-func (s *MongoSuite) TestIsReadyNotOne(c *gc.C) {
+func (s *MongoSuite) TestIsSingleNodeReadyMultiple(c *gc.C) {
 	s.PatchValue(&getCurrentStatus,
 		func(session *mgo.Session) (*Status, error) {
-			status := &Status{Members: []MemberStatus{{
-				Id:      1,
-				Healthy: false,
-			}}}
+			status := &Status{Members: []MemberStatus{
+				{Id: 1, Healthy: true, State: PrimaryState},
+				{Id: 2, Healthy: true, State: SecondaryState},
+			}}
 			return status, nil
 		},
 	)
 	session := s.root.MustDial()
 	defer session.Close()
 
-	ready, err := IsReady(session)
-	c.Assert(err, jc.ErrorIsNil)
-
-	c.Check(ready, jc.IsFalse)
+	_, err := IsSingleNodeReady(session)
+	c.Check(err, gc.ErrorMatches, "single node check of replica set with 2 members not valid")
+	c.Check(err, jc.Satisfies, errors.IsNotValid)
 }
 
-func (s *MongoSuite) TestIsReadyMinority(c *gc.C) {
+func (s *MongoSuite) TestIsReadyMultiple(c *gc.C) {
+	s.PatchValue(&getCurrentStatus,
+		func(session *mgo.Session) (*Status, error) {
+			status := &Status{}
+			for i := 1; i < 5; i++ {
+				member := MemberStatus{Id: i + 1, Healthy: true}
+				status.Members = append(status.Members, member)
+			}
+			return status, nil
+		},
+	)
+	session := s.root.MustDial()
+	defer session.Close()
+
+	ready, err := IsReady(session)
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Check(ready, jc.IsTrue)
+}
+
+func (s *MongoSuite) TestIsReadyNotOne(c *gc.C) {
+	s.PatchValue(&getCurrentStatus,
+		func(session *mgo.Session) (*Status, error) {
+			status := &Status{Members: []MemberStatus{{
+				Id:      1,
+				Healthy: false,
+			}}}
+			return status, nil
+		},
+	)
+	session := s.root.MustDial()
+	defer session.Close()
+
+	ready, err := IsReady(session)
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Check(ready, jc.IsFalse)
+}
+
+func (s *MongoSuite) TestIsReadyMinority(c *gc.C) {
 	s.PatchValue(&getCurrentStatus,
 		func(session *mgo.Session) (*Status, error) {
 			status := &Status{Members: []MemberStatus{{
@@ -430,6 +895,207 @@ func (s *MongoSuite) TestIsReadyMinority(c *gc.C) {
 	c.Check(ready, jc.IsFalse)
 }
 
+func (s *MongoSuite) TestIsWritableOne(c *gc.C) {
+	s.PatchValue(&getCurrentStatus,
+		func(session *mgo.Session) (*Status, error) {
+			status := &Status{Members: []MemberStatus{{
+				Id:      1,
+				Healthy: true,
+				State:   PrimaryState,
+			}}}
+			return status, nil
+		},
+	)
+	session := s.root.MustDial()
+	defer session.Close()
+
+	writable, err := IsWritable(session)
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Check(writable, jc.IsTrue)
+}
+
+func (s *MongoSuite) TestIsWritableOneNoPrimary(c *gc.C) {
+	s.PatchValue(&getCurrentStatus,
+		func(session *mgo.Session) (*Status, error) {
+			status := &Status{Members: []MemberStatus{{
+				Id:      1,
+				Healthy: true,
+				State:   SecondaryState,
+			}}}
+			return status, nil
+		},
+	)
+	session := s.root.MustDial()
+	defer session.Close()
+
+	writable, err := IsWritable(session)
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Check(writable, jc.IsFalse)
+}
+
+func (s *MongoSuite) TestIsWritableMultiple(c *gc.C) {
+	s.PatchValue(&getCurrentStatus,
+		func(session *mgo.Session) (*Status, error) {
+			status := &Status{}
+			for i := 1; i < 5; i++ {
+				member := MemberStatus{Id: i + 1, Healthy: true, State: SecondaryState}
+				status.Members = append(status.Members, member)
+			}
+			status.Members[0].State = PrimaryState
+			return status, nil
+		},
+	)
+	session := s.root.MustDial()
+	defer session.Close()
+
+	writable, err := IsWritable(session)
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Check(writable, jc.IsTrue)
+}
+
+func (s *MongoSuite) TestIsWritableMultipleNoPrimary(c *gc.C) {
+	s.PatchValue(&getCurrentStatus,
+		func(session *mgo.Session) (*Status, error) {
+			status := &Status{}
+			for i := 1; i < 5; i++ {
+				member := MemberStatus{Id: i + 1, Healthy: true, State: SecondaryState}
+				status.Members = append(status.Members, member)
+			}
+			return status, nil
+		},
+	)
+	session := s.root.MustDial()
+	defer session.Close()
+
+	writable, err := IsWritable(session)
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Check(writable, jc.IsFalse)
+}
+
+func (s *MongoSuite) TestIsWritableMinority(c *gc.C) {
+	s.PatchValue(&getCurrentStatus,
+		func(session *mgo.Session) (*Status, error) {
+			status := &Status{Members: []MemberStatus{{
+				Id:      1,
+				Healthy: true,
+				State:   PrimaryState,
+			},
+				{
+					Id:      2,
+					Healthy: false,
+				},
+				{
+					Id:      3,
+					Healthy: false,
+				}}}
+			return status, nil
+		},
+	)
+	session := s.root.MustDial()
+	defer session.Close()
+
+	writable, err := IsWritable(session)
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Check(writable, jc.IsFalse)
+}
+
+func (s *MongoSuite) TestIsWritableError(c *gc.C) {
+	failure := errors.New("failed!")
+	s.PatchValue(&getCurrentStatus,
+		func(session *mgo.Session) (*Status, error) { return nil, failure },
+	)
+	session := s.root.MustDial()
+	defer session.Close()
+
+	_, err := IsWritable(session)
+	c.Check(errors.Cause(err), gc.Equals, failure)
+}
+
+func (s *MongoSuite) patchArbiterSet(dataHealthy, arbiterHealthy bool) {
+	arbiter := true
+	s.PatchValue(&CurrentConfig,
+		func(session *mgo.Session) (*Config, error) {
+			return &Config{Members: []Member{
+				{Id: 1, Address: "1.2.3.4:37017"},
+				{Id: 2, Address: "1.2.3.5:37017"},
+				{Id: 3, Address: "1.2.3.6:37017", Arbiter: &arbiter},
+			}}, nil
+		},
+	)
+	s.PatchValue(&getCurrentStatus,
+		func(session *mgo.Session) (*Status, error) {
+			return &Status{Members: []MemberStatus{
+				{Id: 1, Healthy: true, State: PrimaryState},
+				{Id: 2, Healthy: dataHealthy, State: SecondaryState},
+				{Id: 3, Healthy: arbiterHealthy, State: ArbiterState},
+			}}, nil
+		},
+	)
+}
+
+func (s *MongoSuite) TestIsReadyWithArbiter(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	for i, test := range []struct {
+		dataHealthy    bool
+		arbiterHealthy bool
+		ready          bool
+		writable       bool
+	}{
+		{true, true, true, true},
+		{true, false, true, true},
+		{false, true, true, false},
+		{false, false, false, false},
+	} {
+		c.Logf("test %d: data healthy %v, arbiter healthy %v", i, test.dataHealthy, test.arbiterHealthy)
+		s.patchArbiterSet(test.dataHealthy, test.arbiterHealthy)
+
+		ready, err := IsReady(session)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(ready, gc.Equals, test.ready)
+
+		writable, err := IsWritable(session)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(writable, gc.Equals, test.writable)
+	}
+}
+
+func (s *MongoSuite) TestIsReadyIgnoresNonVotingMembers(c *gc.C) {
+	noVotes := 0
+	s.PatchValue(&CurrentConfig,
+		func(session *mgo.Session) (*Config, error) {
+			return &Config{Members: []Member{
+				{Id: 1, Address: "1.2.3.4:37017"},
+				{Id: 2, Address: "1.2.3.5:37017"},
+				{Id: 3, Address: "1.2.3.6:37017", Votes: &noVotes},
+				{Id: 4, Address: "1.2.3.7:37017", Votes: &noVotes},
+			}}, nil
+		},
+	)
+	s.PatchValue(&getCurrentStatus,
+		func(session *mgo.Session) (*Status, error) {
+			return &Status{Members: []MemberStatus{
+				{Id: 1, Healthy: true, State: PrimaryState},
+				{Id: 2, Healthy: true, State: SecondaryState},
+				{Id: 3, Healthy: false},
+				{Id: 4, Healthy: false},
+			}}, nil
+		},
+	)
+	session := s.root.MustDial()
+	defer session.Close()
+
+	ready, err := IsReady(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(ready, jc.IsTrue)
+}
+
 func (s *MongoSuite) checkConnectionFailure(c *gc.C, failure error) {
 	s.PatchValue(&getCurrentStatus,
 		func(session *mgo.Session) (*Status, error) { return nil, failure },
@@ -466,6 +1132,27 @@ func (s *MongoSuite) TestIsReadyError(c *gc.C) {
 	c.Check(errors.Cause(err), gc.Equals, failure)
 }
 
+func (s *MongoSuite) TestCommandErrorsAreAnnotated(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	// A config without members is rejected by mongo.
+	err := Set(session, []Member{})
+	c.Check(err, gc.ErrorMatches, `Set: replSetReconfig of replica set "`+rsName+`" to version 2: .+`)
+	_, ok := errors.Cause(err).(*mgo.QueryError)
+	c.Check(ok, jc.IsTrue)
+
+	inst := newServer(c)
+	defer inst.Destroy()
+	uninitiated := inst.MustDialDirect()
+	defer uninitiated.Close()
+
+	_, err = CurrentStatus(uninitiated)
+	c.Check(err, gc.ErrorMatches, `cannot get replica set status: .+`)
+	_, ok = errors.Cause(err).(*mgo.QueryError)
+	c.Check(ok, jc.IsTrue)
+}
+
 func (s *MongoSuite) TestWaitUntilReady(c *gc.C) {
 	var isReadyCalled bool
 	mockIsReady := func(session *mgo.Session) (bool, error) {
@@ -508,83 +1195,299 @@ func (s *MongoSuite) TestWaitUntilReadyError(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, "foobar")
 }
 
-func (s *MongoSuite) TestCurrentStatus(c *gc.C) {
+func (s *MongoSuite) TestWaitUntilReadyStrategy(c *gc.C) {
+	calls := 0
+	s.PatchValue(&isReady, func(session *mgo.Session) (bool, error) {
+		calls++
+		return calls == 3, nil
+	})
 	session := s.root.MustDial()
 	defer session.Close()
 
-	inst1 := newServer(c)
-	defer inst1.Destroy()
-	defer Remove(session, inst1.Addr())
-
-	inst2 := newServer(c)
-	defer inst2.Destroy()
-	defer Remove(session, inst2.Addr())
-
-	var err error
-	strategy := utils.AttemptStrategy{Total: time.Minute * 2, Delay: time.Millisecond * 500}
-	attempt := strategy.Start()
-	for attempt.Next() {
-		err = Add(session, Member{Address: inst1.Addr()}, Member{Address: inst2.Addr()})
-		if err == nil || !attempt.HasNext() {
-			break
-		}
-	}
+	strategy := utils.AttemptStrategy{Total: time.Minute, Delay: time.Millisecond}
+	err := WaitUntilReadyStrategy(session, strategy)
 	c.Assert(err, jc.ErrorIsNil)
+	c.Check(calls, gc.Equals, 3)
+}
 
-	expected := &Status{
-		Name: rsName,
-		Members: []MemberStatus{{
-			Id:      1,
-			Address: s.root.Addr(),
-			Self:    true,
-			ErrMsg:  "",
-			Healthy: true,
-			State:   PrimaryState,
-		}, {
-			Id:      2,
-			Address: inst1.Addr(),
-			Self:    false,
-			ErrMsg:  "",
-			Healthy: true,
-			State:   SecondaryState,
-		}, {
-			Id:      3,
-			Address: inst2.Addr(),
-			Self:    false,
-			ErrMsg:  "",
-			Healthy: true,
-			State:   SecondaryState,
-		}},
-	}
+func (s *MongoSuite) TestWaitUntilReadyStrategyTimeout(c *gc.C) {
+	s.PatchValue(&isReady, func(session *mgo.Session) (bool, error) {
+		return false, nil
+	})
+	session := s.root.MustDial()
+	defer session.Close()
 
-	strategy.Total = time.Second * 90
-	attempt = strategy.Start()
-	var res *Status
-	for attempt.Next() {
-		var err error
-		res, err = CurrentStatus(session)
-		if err != nil {
-			if !attempt.HasNext() {
-				c.Errorf("Couldn't get status before timeout, got err: %v", err)
-				return
-			} else {
-				// try again
-				continue
-			}
-		}
+	strategy := utils.AttemptStrategy{Min: 3, Delay: time.Millisecond}
+	err := WaitUntilReadyStrategy(session, strategy)
+	c.Assert(err, gc.ErrorMatches, "replica set not ready after 3 attempts in .*")
+}
 
-		if res.Members[0].State == PrimaryState &&
-			res.Members[1].State == SecondaryState &&
-			res.Members[2].State == SecondaryState {
-			break
-		}
-		if !attempt.HasNext() {
-			c.Errorf("Servers did not get into final state before timeout.  Status: %#v", res)
-			return
-		}
-	}
+func (s *MongoSuite) TestWaitUntilReadyStrategyError(c *gc.C) {
+	s.PatchValue(&isReady, func(session *mgo.Session) (bool, error) {
+		return false, errors.New("foobar")
+	})
+	session := s.root.MustDial()
+	defer session.Close()
 
-	for x := range res.Members {
+	err := WaitUntilReadyStrategy(session, utils.AttemptStrategy{})
+	c.Assert(err, gc.ErrorMatches, "foobar")
+}
+
+func (s *MongoSuite) TestWaitForConfigCommitted(c *gc.C) {
+	calls := 0
+	s.PatchValue(&getConfigWithCommitment, func(session *mgo.Session) (*Config, *bool, error) {
+		calls++
+		committed := calls > 1
+		return &Config{Version: 2}, &committed, nil
+	})
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		c.Fatalf("unexpected status request")
+		return nil, nil
+	})
+	session := s.root.MustDial()
+	defer session.Close()
+
+	err := WaitForConfigCommitted(session, time.Minute)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(calls, gc.Equals, 2)
+}
+
+func (s *MongoSuite) TestWaitForConfigCommittedOlderServers(c *gc.C) {
+	s.PatchValue(&getConfigWithCommitment, func(session *mgo.Session) (*Config, *bool, error) {
+		return &Config{Version: 3}, nil, nil
+	})
+	calls := 0
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		calls++
+		return &Status{Members: []MemberStatus{
+			{Id: 1, Healthy: true, ConfigVersion: 3},
+			{Id: 2, Healthy: true, ConfigVersion: 2 + calls/2},
+			{Id: 3, Healthy: true, ConfigVersion: 2},
+		}}, nil
+	})
+	session := s.root.MustDial()
+	defer session.Close()
+
+	err := WaitForConfigCommitted(session, time.Minute)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(calls, gc.Equals, 2)
+}
+
+func (s *MongoSuite) TestWaitForConfigCommittedTimeout(c *gc.C) {
+	s.PatchValue(&getConfigWithCommitment, func(session *mgo.Session) (*Config, *bool, error) {
+		committed := false
+		return &Config{Version: 2}, &committed, nil
+	})
+	session := s.root.MustDial()
+	defer session.Close()
+
+	err := WaitForConfigCommitted(session, 0)
+	c.Assert(err, gc.ErrorMatches, "timed out after 0s waiting for the config to be committed")
+}
+
+func (s *MongoSuite) TestSetWaitCommitted(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	members, err := CurrentMembers(session)
+	c.Assert(err, jc.ErrorIsNil)
+	opts := ReconfigOptions{WaitCommitted: true, WaitTimeout: time.Minute}
+	err = SetWithOptions(session, opts, members)
+	c.Assert(err, jc.ErrorIsNil)
+
+	cfg, committed, err := CurrentConfigWithCommitment(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(cfg.Version, gc.Equals, 2)
+	if committed != nil {
+		c.Check(*committed, jc.IsTrue)
+	}
+}
+
+func (s *MongoSuite) TestWaitForConfigVersion(c *gc.C) {
+	calls := 0
+	s.PatchValue(&getCurrentStatus,
+		func(session *mgo.Session) (*Status, error) {
+			calls++
+			return &Status{Members: []MemberStatus{{
+				Id:            1,
+				Address:       "1.2.3.4:37017",
+				Healthy:       true,
+				ConfigVersion: 3,
+			}, {
+				Id:            2,
+				Address:       "1.2.3.5:37017",
+				Healthy:       true,
+				ConfigVersion: 2 + calls/2,
+			}, {
+				Id:            3,
+				Address:       "1.2.3.6:37017",
+				Healthy:       false,
+				ConfigVersion: 1,
+			}}}, nil
+		},
+	)
+	session := s.root.MustDial()
+	defer session.Close()
+
+	err := WaitForConfigVersion(session, 3, 10*time.Second)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(calls, gc.Equals, 2)
+}
+
+func (s *MongoSuite) TestWaitForConfigVersionTimeout(c *gc.C) {
+	s.PatchValue(&getCurrentStatus,
+		func(session *mgo.Session) (*Status, error) {
+			return &Status{Members: []MemberStatus{{
+				Id:            1,
+				Address:       "1.2.3.4:37017",
+				Healthy:       true,
+				ConfigVersion: 3,
+			}, {
+				Id:            2,
+				Address:       "1.2.3.5:37017",
+				Healthy:       true,
+				ConfigVersion: 2,
+			}}}, nil
+		},
+	)
+	session := s.root.MustDial()
+	defer session.Close()
+
+	err := WaitForConfigVersion(session, 3, 0)
+	c.Assert(err, gc.ErrorMatches, `timed out after 0s waiting for config version 3 on 1.2.3.5:37017 \(version 2\)`)
+}
+
+func (s *MongoSuite) TestConfigIsConsistent(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	for i, test := range []struct {
+		about      string
+		members    []MemberStatus
+		consistent bool
+	}{{
+		about: "all agree",
+		members: []MemberStatus{
+			{Id: 1, Healthy: true, State: PrimaryState, ConfigVersion: 3, ConfigTerm: 2},
+			{Id: 2, Healthy: true, State: SecondaryState, ConfigVersion: 3, ConfigTerm: 2},
+		},
+		consistent: true,
+	}, {
+		about: "divergent version",
+		members: []MemberStatus{
+			{Id: 1, Healthy: true, State: PrimaryState, ConfigVersion: 3, ConfigTerm: 2},
+			{Id: 2, Healthy: true, State: SecondaryState, ConfigVersion: 2, ConfigTerm: 2},
+		},
+	}, {
+		about: "divergent term",
+		members: []MemberStatus{
+			{Id: 1, Healthy: true, State: PrimaryState, ConfigVersion: 3, ConfigTerm: 2},
+			{Id: 2, Healthy: true, State: SecondaryState, ConfigVersion: 3, ConfigTerm: 1},
+		},
+	}, {
+		about: "unhealthy members are ignored",
+		members: []MemberStatus{
+			{Id: 1, Healthy: true, State: PrimaryState, ConfigVersion: 3, ConfigTerm: 2},
+			{Id: 2, Healthy: false, State: SecondaryState, ConfigVersion: 1, ConfigTerm: 1},
+		},
+		consistent: true,
+	}, {
+		about: "no primary",
+		members: []MemberStatus{
+			{Id: 1, Healthy: true, State: SecondaryState, ConfigVersion: 3, ConfigTerm: 2},
+			{Id: 2, Healthy: true, State: SecondaryState, ConfigVersion: 3, ConfigTerm: 2},
+		},
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		members := test.members
+		s.PatchValue(&getCurrentStatus,
+			func(session *mgo.Session) (*Status, error) {
+				return &Status{Members: members}, nil
+			},
+		)
+		consistent, err := ConfigIsConsistent(session)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(consistent, gc.Equals, test.consistent)
+	}
+}
+
+func (s *MongoSuite) TestCurrentStatus(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	inst1 := newServer(c)
+	defer inst1.Destroy()
+	defer Remove(session, inst1.Addr())
+
+	inst2 := newServer(c)
+	defer inst2.Destroy()
+	defer Remove(session, inst2.Addr())
+
+	var err error
+	strategy := utils.AttemptStrategy{Total: time.Minute * 2, Delay: time.Millisecond * 500}
+	attempt := strategy.Start()
+	for attempt.Next() {
+		err = Add(session, Member{Address: inst1.Addr()}, Member{Address: inst2.Addr()})
+		if err == nil || !attempt.HasNext() {
+			break
+		}
+	}
+	c.Assert(err, jc.ErrorIsNil)
+
+	expected := &Status{
+		Name: rsName,
+		Members: []MemberStatus{{
+			Id:      1,
+			Address: s.root.Addr(),
+			Self:    true,
+			ErrMsg:  "",
+			Healthy: true,
+			State:   PrimaryState,
+		}, {
+			Id:      2,
+			Address: inst1.Addr(),
+			Self:    false,
+			ErrMsg:  "",
+			Healthy: true,
+			State:   SecondaryState,
+		}, {
+			Id:      3,
+			Address: inst2.Addr(),
+			Self:    false,
+			ErrMsg:  "",
+			Healthy: true,
+			State:   SecondaryState,
+		}},
+	}
+
+	strategy.Total = time.Second * 90
+	attempt = strategy.Start()
+	var res *Status
+	for attempt.Next() {
+		var err error
+		res, err = CurrentStatus(session)
+		if err != nil {
+			if !attempt.HasNext() {
+				c.Errorf("Couldn't get status before timeout, got err: %v", err)
+				return
+			} else {
+				// try again
+				continue
+			}
+		}
+
+		if res.Members[0].State == PrimaryState &&
+			res.Members[1].State == SecondaryState &&
+			res.Members[2].State == SecondaryState {
+			break
+		}
+		if !attempt.HasNext() {
+			c.Errorf("Servers did not get into final state before timeout.  Status: %#v", res)
+			return
+		}
+	}
+
+	for x := range res.Members {
 		// non-empty uptime and ping
 		c.Check(res.Members[x].Uptime, gc.Not(gc.Equals), 0)
 
@@ -593,10 +1496,97 @@ func (s *MongoSuite) TestCurrentStatus(c *gc.C) {
 
 		// now overwrite Uptime so it won't throw off DeepEquals
 		res.Members[x].Uptime = 0
+
+		// the config version depends on how many reconfigs it took to
+		// add the members, and the config term is only reported by
+		// newer servers, so don't compare them either.
+		c.Check(res.Members[x].ConfigVersion, gc.Not(gc.Equals), 0)
+		res.Members[x].ConfigVersion = 0
+		res.Members[x].ConfigTerm = 0
+		res.Members[x].LastHeartbeat = time.Time{}
+		res.Members[x].LastHeartbeatRecv = time.Time{}
+
+		// the optimes depend on the data loaded.
+		c.Check(res.Members[x].OptimeApplied.IsZero(), jc.IsFalse)
+		c.Check(res.Members[x].OptimeDurable.IsZero(), jc.IsFalse)
+		res.Members[x].OptimeApplied = time.Time{}
+		res.Members[x].OptimeDurable = time.Time{}
+
+		// the sync source of a secondary may be the primary or the
+		// other secondary.
+		res.Members[x].SyncSource = ""
+
+		// informational messages come and go as the members sync.
+		res.Members[x].InfoMessage = ""
+
+		// the stable checkpoint depends on the storage engine and timing.
+		res.Members[x].StableRecoveryTimestamp = time.Time{}
+	}
+	res.LastStableRecoveryTimestamp = 0
+	// the optimes of the set depend on the data loaded.
+	c.Check(res.Optimes.LastCommitted.Timestamp, gc.Not(gc.Equals), bson.MongoTimestamp(0))
+	c.Check(res.Optimes.Applied.Timestamp, gc.Not(gc.Equals), bson.MongoTimestamp(0))
+	res.Optimes = Optimes{}
+
+	// the majority counts are only reported by newer servers.
+	if res.WriteMajorityCount != 0 {
+		c.Check(res.WriteMajorityCount, gc.Equals, 2)
+		c.Check(res.VotingMembersCount, gc.Equals, 3)
 	}
+	res.WriteMajorityCount = 0
+	res.VotingMembersCount = 0
 	c.Check(res, jc.DeepEquals, expected)
 }
 
+func (s *MongoSuite) TestCurrentStatusWithVoting(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	inst1 := newServer(c)
+	defer inst1.Destroy()
+	defer Remove(session, inst1.Addr())
+
+	zero := 0
+	noPriority := 0.0
+	var err error
+	strategy := utils.AttemptStrategy{Total: time.Minute * 2, Delay: time.Millisecond * 500}
+	attempt := strategy.Start()
+	for attempt.Next() {
+		err = Add(session, Member{Address: inst1.Addr(), Votes: &zero, Priority: &noPriority})
+		if err == nil || !attempt.HasNext() {
+			break
+		}
+	}
+	c.Assert(err, jc.ErrorIsNil)
+
+	strategy.Total = time.Second * 90
+	attempt = strategy.Start()
+	var res *Status
+	for attempt.Next() {
+		res, err = CurrentStatusWithVoting(session)
+		if err == nil && len(res.Members) == 2 &&
+			res.Members[0].State == PrimaryState &&
+			res.Members[1].State == SecondaryState {
+			break
+		}
+		if !attempt.HasNext() {
+			c.Fatalf("Servers did not get into final state before timeout. Status: %#v, err: %v", res, err)
+		}
+	}
+	// The primary votes and can be elected; the added member has neither
+	// votes nor priority.
+	c.Check(res.Members[0].Votes, gc.Equals, 1)
+	c.Check(res.Members[0].Electable, jc.IsTrue)
+	c.Check(res.Members[1].Votes, gc.Equals, 0)
+	c.Check(res.Members[1].Electable, jc.IsFalse)
+
+	// CurrentStatus leaves them unset.
+	res, err = CurrentStatus(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(res.Members[0].Votes, gc.Equals, 0)
+	c.Check(res.Members[0].Electable, jc.IsFalse)
+}
+
 func closeEnough(expected, obtained time.Time) bool {
 	t := obtained.Sub(expected)
 	return (-500*time.Millisecond) < t && t < (500*time.Millisecond)
@@ -792,3 +1782,2144 @@ func (s *fmtConfigForLogSuite) TestSimpleFormatting(c *gc.C) {
   },
 }`)
 }
+
+type statusJSONSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&statusJSONSuite{})
+
+func (s *statusJSONSuite) TestMemberStateMarshalJSON(c *gc.C) {
+	data, err := json.Marshal(MemberState(SecondaryState))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(data), gc.Equals, `"SECONDARY"`)
+}
+
+func (s *statusJSONSuite) TestMemberStateUnmarshalJSON(c *gc.C) {
+	for i, test := range []struct {
+		data     string
+		expected MemberState
+	}{
+		{`"PRIMARY"`, PrimaryState},
+		{`"ARBITER"`, ArbiterState},
+		{`1`, PrimaryState},
+		{`7`, ArbiterState},
+	} {
+		c.Logf("test %d: %s", i, test.data)
+		var state MemberState
+		err := json.Unmarshal([]byte(test.data), &state)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(state, gc.Equals, test.expected)
+	}
+}
+
+func (s *statusJSONSuite) TestMemberStateUnmarshalJSONInvalid(c *gc.C) {
+	var state MemberState
+	err := json.Unmarshal([]byte(`"BOGUS"`), &state)
+	c.Check(err, gc.ErrorMatches, `invalid member state "BOGUS"`)
+	err = json.Unmarshal([]byte(`42`), &state)
+	c.Check(err, gc.ErrorMatches, `invalid member state 42`)
+}
+
+func (s *statusJSONSuite) TestStatusRoundTrip(c *gc.C) {
+	status := &Status{
+		Name: rsName,
+		Members: []MemberStatus{{
+			Id:            1,
+			Address:       "1.2.3.4:37017",
+			Self:          true,
+			Healthy:       true,
+			State:         PrimaryState,
+			ConfigVersion: 2,
+		}, {
+			Id:            2,
+			Address:       "1.2.3.5:37017",
+			ErrMsg:        "syncing",
+			Healthy:       true,
+			State:         SecondaryState,
+			ConfigVersion: 2,
+		}},
+	}
+	data, err := json.Marshal(status)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(data), jc.Contains, `"state":"PRIMARY"`)
+
+	var obtained Status
+	err = json.Unmarshal(data, &obtained)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(&obtained, jc.DeepEquals, status)
+}
+
+type memberBSONSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&memberBSONSuite{})
+
+func (s *memberBSONSuite) TestDelayFieldName(c *gc.C) {
+	for i, test := range []struct {
+		version  []int
+		expected string
+	}{
+		{[]int{3, 2, 22, 0}, "slaveDelay"},
+		{[]int{4, 4, 1, 0}, "slaveDelay"},
+		{[]int{5, 0, 0, 0}, "secondaryDelaySecs"},
+		{[]int{7, 0, 2, 0}, "secondaryDelaySecs"},
+	} {
+		c.Logf("test %d: %v", i, test.version)
+		buildInfo := mgo.BuildInfo{VersionArray: test.version}
+		c.Check(delayFieldName(buildInfo), gc.Equals, test.expected)
+	}
+}
+
+func (s *memberBSONSuite) TestMarshalDelay(c *gc.C) {
+	delay := time.Duration(3600)
+	for i, field := range []string{"", slaveDelayField, secondaryDelayField} {
+		c.Logf("test %d: %q", i, field)
+		member := Member{Id: 1, Address: "1.2.3.4:37017", SlaveDelay: &delay, delayField: field}
+		data, err := bson.Marshal(member)
+		c.Assert(err, jc.ErrorIsNil)
+		var doc bson.M
+		err = bson.Unmarshal(data, &doc)
+		c.Assert(err, jc.ErrorIsNil)
+		if field == "" {
+			field = slaveDelayField
+		}
+		c.Check(doc, jc.DeepEquals, bson.M{
+			"_id":  1,
+			"host": "1.2.3.4:37017",
+			field:  int64(3600),
+		})
+	}
+}
+
+func (s *memberBSONSuite) TestUnmarshalDelay(c *gc.C) {
+	for i, field := range []string{slaveDelayField, secondaryDelayField} {
+		c.Logf("test %d: %q", i, field)
+		data, err := bson.Marshal(bson.M{"_id": 1, "host": "1.2.3.4:37017", field: 3600})
+		c.Assert(err, jc.ErrorIsNil)
+		var member Member
+		err = bson.Unmarshal(data, &member)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(member.SlaveDelay, gc.NotNil)
+		c.Check(*member.SlaveDelay, gc.Equals, time.Duration(3600))
+		c.Check(member.Address, gc.Equals, "1.2.3.4:37017")
+		c.Check(member.Extra, gc.IsNil)
+
+		// The member is written back with the field name it was read with.
+		data, err = bson.Marshal(member)
+		c.Assert(err, jc.ErrorIsNil)
+		var doc bson.M
+		err = bson.Unmarshal(data, &doc)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(doc[field], gc.Equals, int64(3600))
+	}
+}
+
+func (s *memberBSONSuite) TestUnknownFieldsPreserved(c *gc.C) {
+	data, err := bson.Marshal(bson.M{
+		"_id":         1,
+		"host":        "1.2.3.4:37017",
+		"futureField": "future",
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	var member Member
+	err = bson.Unmarshal(data, &member)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(member.Extra, jc.DeepEquals, bson.M{"futureField": "future"})
+
+	// The fields are written back, whatever the delay field name.
+	for i, field := range []string{slaveDelayField, secondaryDelayField} {
+		c.Logf("test %d: %q", i, field)
+		member.delayField = field
+		data, err = bson.Marshal(Config{Name: rsName, Members: []Member{member}})
+		c.Assert(err, jc.ErrorIsNil)
+		var doc struct {
+			Members []bson.M `bson:"members"`
+		}
+		err = bson.Unmarshal(data, &doc)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(doc.Members, gc.HasLen, 1)
+		c.Check(doc.Members[0]["futureField"], gc.Equals, "future")
+	}
+}
+
+func (s *memberBSONSuite) TestNewlyAdded(c *gc.C) {
+	data, err := bson.Marshal(bson.M{
+		"_id":        2,
+		"host":       "1.2.3.5:37017",
+		"newlyAdded": true,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	var member Member
+	err = bson.Unmarshal(data, &member)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(member.NewlyAdded, gc.NotNil)
+	c.Check(*member.NewlyAdded, jc.IsTrue)
+	c.Check(member.Extra, gc.IsNil)
+
+	// The flag is never sent back to the server.
+	data, err = bson.Marshal(member)
+	c.Assert(err, jc.ErrorIsNil)
+	var doc bson.M
+	err = bson.Unmarshal(data, &doc)
+	c.Assert(err, jc.ErrorIsNil)
+	_, ok := doc["newlyAdded"]
+	c.Check(ok, jc.IsFalse)
+}
+
+func (s *memberBSONSuite) TestNewlyAddedExcludedFromVotes(c *gc.C) {
+	yes := true
+	no := false
+	status := &Status{Members: []MemberStatus{
+		{Id: 1, Healthy: true, State: PrimaryState},
+		{Id: 2, Healthy: true, State: SecondaryState},
+		{Id: 3, Healthy: true, State: Startup2State},
+	}}
+	config := &Config{Members: []Member{
+		{Id: 1}, {Id: 2}, {Id: 3, NewlyAdded: &yes},
+	}}
+	votes := countVotes(status, config)
+	c.Check(votes.total, gc.Equals, 2)
+	c.Check(votes.majority(), gc.Equals, 2)
+	c.Check(CurrentFaultTolerance(status, config), gc.Equals, 0)
+
+	// Once the initial sync is done, the member counts.
+	config.Members[2].NewlyAdded = &no
+	status.Members[2].State = SecondaryState
+	votes = countVotes(status, config)
+	c.Check(votes.total, gc.Equals, 3)
+	c.Check(votes.majority(), gc.Equals, 2)
+	c.Check(CurrentFaultTolerance(status, config), gc.Equals, 1)
+}
+
+type bulkStatusSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&bulkStatusSuite{})
+
+func (s *bulkStatusSuite) TestBulkStatus(c *gc.C) {
+	sessions := map[string]*mgo.Session{
+		"rs0": &mgo.Session{},
+		"rs1": &mgo.Session{},
+		"rs2": &mgo.Session{},
+		"rs3": &mgo.Session{},
+	}
+	names := make(map[*mgo.Session]string)
+	for name, session := range sessions {
+		names[session] = name
+	}
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	failure := errors.New("boom")
+	s.PatchValue(&getCurrentStatus,
+		func(session *mgo.Session) (*Status, error) {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+
+			name := names[session]
+			if name == "rs2" {
+				return nil, failure
+			}
+			return &Status{Name: name}, nil
+		},
+	)
+
+	statuses, errs := BulkStatus(sessions, 2)
+	c.Check(maxRunning <= 2, jc.IsTrue)
+	c.Assert(statuses, gc.HasLen, 3)
+	for _, name := range []string{"rs0", "rs1", "rs3"} {
+		c.Check(statuses[name].Name, gc.Equals, name)
+	}
+	c.Assert(errs, gc.HasLen, 1)
+	c.Check(errs["rs2"], gc.Equals, failure)
+}
+
+type configSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&configSuite{})
+
+func (s *configSuite) TestNextMemberID(c *gc.C) {
+	c.Check(NextMemberID(&Config{}), gc.Equals, 1)
+	c.Check(NextMemberID(&Config{Members: []Member{{Id: 1}, {Id: 2}}}), gc.Equals, 3)
+	// An explicitly large member Id, as in assertAddRemoveSet, is not reused.
+	cfg := &Config{Members: []Member{{Id: 1}, {Id: 10}, {Id: 3}}}
+	c.Check(NextMemberID(cfg), gc.Equals, 11)
+}
+
+func (s *configSuite) TestNotPrimaryError(c *gc.C) {
+	err := &NotPrimaryError{Address: "1.2.3.5:37017", Primary: "1.2.3.4:37017"}
+	c.Check(err, gc.ErrorMatches, "1.2.3.5:37017 is not the primary, the primary is 1.2.3.4:37017")
+	c.Check(errors.Cause(err), gc.Equals, ErrNotPrimary)
+}
+
+func (s *configSuite) TestReplaceMembers(c *gc.C) {
+	current := []Member{
+		{Id: 1, Address: "1.2.3.4:37017"},
+		{Id: 2, Address: "1.2.3.5:37017"},
+		{Id: 5, Address: "1.2.3.6:37017"},
+	}
+	add := []Member{
+		{Address: "1.2.3.7:37017"},
+		{Address: "1.2.3.4:37017"},
+		{Address: "1.2.3.8:37017"},
+	}
+	members := replaceMembers(current, []string{"1.2.3.5:37017", "1.2.3.9:37017"}, add)
+	c.Check(members, jc.DeepEquals, []Member{
+		{Id: 1, Address: "1.2.3.4:37017"},
+		{Id: 5, Address: "1.2.3.6:37017"},
+		{Id: 6, Address: "1.2.3.7:37017"},
+		{Id: 7, Address: "1.2.3.8:37017"},
+	})
+}
+
+func (s *configSuite) TestCheckWritableMajority(c *gc.C) {
+	status := &Status{Members: []MemberStatus{
+		{Id: 1, Healthy: true, State: PrimaryState},
+		{Id: 2, Healthy: true, State: SecondaryState},
+		{Id: 3, Healthy: false, State: DownState},
+		{Id: 4, Healthy: true, State: ArbiterState},
+	}}
+	yes := true
+	for i, test := range []struct {
+		about   string
+		members []Member
+		err     string
+	}{{
+		about:   "replacing the unhealthy member",
+		members: []Member{{Id: 1}, {Id: 2}, {Id: 5}},
+	}, {
+		about:   "adding too many new members",
+		members: []Member{{Id: 1}, {Id: 2}, {Id: 5}, {Id: 6}, {Id: 7}},
+		err:     "new config would leave 2 of 5 votes on healthy data-bearing members, need 3",
+	}, {
+		about:   "arbiters do not count",
+		members: []Member{{Id: 1}, {Id: 4, Arbiter: &yes}, {Id: 5}},
+		err:     "new config would leave 1 of 3 votes on healthy data-bearing members, need 2",
+	}, {
+		about: "removing everything",
+		err:   "new config would leave 0 of 0 votes on healthy data-bearing members, need 1",
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		err := checkWritableMajority(status, test.members)
+		if test.err == "" {
+			c.Check(err, jc.ErrorIsNil)
+		} else {
+			c.Check(err, gc.ErrorMatches, test.err)
+		}
+	}
+}
+
+func (s *configSuite) TestVotingParityWarning(c *gc.C) {
+	zero := 0
+	for i, test := range []struct {
+		members []Member
+		even    bool
+	}{
+		{members: nil},
+		{members: []Member{{Id: 1}}},
+		{members: []Member{{Id: 1}, {Id: 2}}, even: true},
+		{members: []Member{{Id: 1}, {Id: 2}, {Id: 3, Votes: &zero}}, even: true},
+		{members: []Member{{Id: 1}, {Id: 2}, {Id: 3}}},
+	} {
+		c.Logf("test %d", i)
+		cfg := &Config{Members: test.members}
+		even, warning := cfg.VotingParityWarning()
+		c.Check(even, gc.Equals, test.even)
+		if test.even {
+			c.Check(warning, gc.Matches, `replica set has an even number of voting members \(2\); .*`)
+		} else {
+			c.Check(warning, gc.Equals, "")
+		}
+	}
+}
+
+func (s *configSuite) TestFaultTolerance(c *gc.C) {
+	zero := 0
+	for i, test := range []struct {
+		members  []Member
+		expected int
+	}{
+		{nil, 0},
+		{[]Member{{Id: 1}}, 0},
+		{[]Member{{Id: 1}, {Id: 2}}, 0},
+		{[]Member{{Id: 1}, {Id: 2}, {Id: 3}}, 1},
+		{[]Member{{Id: 1}, {Id: 2}, {Id: 3}, {Id: 4, Votes: &zero}}, 1},
+		{[]Member{{Id: 1}, {Id: 2}, {Id: 3}, {Id: 4}, {Id: 5}}, 2},
+	} {
+		c.Logf("test %d", i)
+		members := test.members
+		s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+			return &Config{Members: members}, nil
+		})
+		tolerance, err := FaultTolerance(nil)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(tolerance, gc.Equals, test.expected)
+	}
+}
+
+func (s *configSuite) TestCurrentFaultTolerance(c *gc.C) {
+	yes := true
+	config := &Config{Members: []Member{
+		{Id: 1}, {Id: 2}, {Id: 3}, {Id: 4}, {Id: 5, Arbiter: &yes},
+	}}
+	status := func(healthy ...bool) *Status {
+		status := &Status{}
+		for i, h := range healthy {
+			status.Members = append(status.Members, MemberStatus{Id: i + 1, Healthy: h, State: SecondaryState})
+		}
+		return status
+	}
+	c.Check(CurrentFaultTolerance(status(true, true, true, true, true), config), gc.Equals, 1)
+	c.Check(CurrentFaultTolerance(status(true, true, true, false, true), config), gc.Equals, 0)
+	c.Check(CurrentFaultTolerance(status(true, true, false, false, true), config), gc.Equals, 0)
+	c.Check(CurrentFaultTolerance(status(true, true, true, true, true), nil), gc.Equals, 2)
+}
+
+func (s *configSuite) TestAllTags(c *gc.C) {
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{Members: []Member{
+			{Id: 1, Tags: map[string]string{"region": "east", "rack": "1"}},
+			{Id: 2, Tags: map[string]string{"region": "west", "rack": "1"}},
+			{Id: 3, Tags: map[string]string{"region": "east"}},
+			{Id: 4},
+		}}, nil
+	})
+	tags, err := AllTags(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(tags, jc.DeepEquals, map[string][]string{
+		"region": {"east", "west"},
+		"rack":   {"1"},
+	})
+}
+
+type partialStatusSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&partialStatusSuite{})
+
+func (s *partialStatusSuite) TestPartialStatus(c *gc.C) {
+	data, err := bson.Marshal(bson.M{
+		"set": rsName,
+		"members": []bson.M{{
+			"_id":    1,
+			"name":   "1.2.3.4:37017",
+			"health": 1,
+			"state":  1,
+		}, {
+			"_id":    2,
+			"name":   "1.2.3.5:37017",
+			"health": 1,
+			"state":  "SECONDARY",
+		}, {
+			"_id":  3,
+			"name": "1.2.3.6:37017",
+		}},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	var status Status
+	err = bson.Unmarshal(data, &status)
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Assert(status.Members, gc.HasLen, 3)
+	c.Check(status.Members[0].State, gc.Equals, MemberState(PrimaryState))
+	c.Check(status.Members[0].Healthy, jc.IsTrue)
+	c.Check(status.Members[0].ErrMsg, gc.Equals, "")
+	for _, member := range status.Members[1:] {
+		c.Check(member.State, gc.Equals, MemberState(UnknownState))
+		c.Check(member.Healthy, jc.IsFalse)
+		c.Check(member.ErrMsg, gc.Matches, "cannot parse member status: .+")
+	}
+	c.Check(status.Members[1].Address, gc.Equals, "1.2.3.5:37017")
+	c.Check(status.Members[2].ErrMsg, gc.Equals, "cannot parse member status: missing name or state")
+
+	err = checkPartialStatus(&status)
+	c.Check(IsPartialStatus(err), jc.IsTrue)
+	c.Check(errors.Cause(err), gc.Equals, ErrPartialStatus)
+	c.Check(err, gc.ErrorMatches, `cannot parse status of member 2 \(.*\), member 3 \(missing name or state\): partial replica set status`)
+}
+
+func (s *partialStatusSuite) TestOptimes(c *gc.C) {
+	data, err := bson.Marshal(bson.M{
+		"set": rsName,
+		"optimes": bson.M{
+			"lastCommittedOpTime":       bson.M{"ts": bson.MongoTimestamp(10), "t": int64(2)},
+			"readConcernMajorityOpTime": bson.M{"ts": bson.MongoTimestamp(10), "t": int64(2)},
+			"appliedOpTime":             bson.M{"ts": bson.MongoTimestamp(12), "t": int64(2)},
+			"durableOpTime":             bson.M{"ts": bson.MongoTimestamp(11), "t": int64(2)},
+		},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	var status Status
+	err = bson.Unmarshal(data, &status)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(status.Optimes, jc.DeepEquals, Optimes{
+		LastCommitted:       OpTime{Timestamp: 10, Term: 2},
+		ReadConcernMajority: OpTime{Timestamp: 10, Term: 2},
+		Applied:             OpTime{Timestamp: 12, Term: 2},
+		Durable:             OpTime{Timestamp: 11, Term: 2},
+	})
+}
+
+func (s *partialStatusSuite) TestOptimeHelpers(c *gc.C) {
+	applied := time.Unix(1500000100, 0).UTC()
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return &Status{
+			Members: []MemberStatus{
+				{Id: 1, Address: "1.2.3.4:37017", State: PrimaryState, OptimeApplied: applied},
+				{Id: 2, Address: "1.2.3.5:37017", State: SecondaryState},
+			},
+			Optimes: Optimes{
+				LastCommitted: OpTime{Timestamp: bson.MongoTimestamp(1500000000 << 32), Term: 1},
+			},
+		}, nil
+	})
+
+	committed, err := LastCommittedOptime(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(committed, gc.Equals, time.Unix(1500000000, 0).UTC())
+
+	optime, err := MemberAppliedOptime(nil, "1.2.3.4:37017")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(optime, gc.Equals, applied)
+
+	_, err = MemberAppliedOptime(nil, "1.2.3.9:37017")
+	c.Check(err, jc.Satisfies, errors.IsNotFound)
+}
+
+func (s *partialStatusSuite) TestCallersToleratePartialStatus(c *gc.C) {
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return &Status{Members: []MemberStatus{
+			{Id: 1, Address: "1.2.3.4:37017", State: PrimaryState, Healthy: true},
+			{Id: 2, Address: "1.2.3.5:37017", State: UnknownState, ErrMsg: "cannot parse member status: bad"},
+		}}, errors.Annotate(ErrPartialStatus, "cannot parse status of member 2 (bad)")
+	})
+
+	topology, err := SyncTopology(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(topology, gc.HasLen, 2)
+
+	members, err := MembersInState(nil, PrimaryState)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(members, gc.HasLen, 1)
+	c.Check(members[0].Address, gc.Equals, "1.2.3.4:37017")
+
+	consistent, err := ConfigIsConsistent(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(consistent, jc.IsTrue)
+}
+
+func (s *partialStatusSuite) TestSetMemberVoting(c *gc.C) {
+	zero := 0
+	noPriority := 0.0
+	yes := true
+	status := &Status{Members: []MemberStatus{
+		{Id: 1, Healthy: true, State: PrimaryState},
+		{Id: 2, Healthy: true, State: SecondaryState},
+		{Id: 3, Healthy: true, State: SecondaryState},
+		{Id: 4, Healthy: true, State: SecondaryState},
+		{Id: 5, Healthy: false, State: DownState},
+		{Id: 6, Healthy: true, State: ArbiterState},
+		{Id: 7, Healthy: true, State: RecoveringState},
+	}}
+	config := &Config{Members: []Member{
+		{Id: 1},
+		{Id: 2, Votes: &zero, Priority: &noPriority},
+		{Id: 3, Priority: &noPriority},
+		{Id: 4},
+		{Id: 5},
+		{Id: 6, Arbiter: &yes, Priority: &noPriority},
+		{Id: 7},
+	}}
+	setMemberVoting(status, config)
+	for i, expected := range []struct {
+		votes     int
+		electable bool
+	}{
+		{1, true},
+		{0, false},
+		{1, false},
+		{1, true},
+		{1, false},
+		{1, false},
+		{1, false},
+	} {
+		c.Check(status.Members[i].Votes, gc.Equals, expected.votes, gc.Commentf("member %d", i+1))
+		c.Check(status.Members[i].Electable, gc.Equals, expected.electable, gc.Commentf("member %d", i+1))
+	}
+}
+
+func (s *partialStatusSuite) TestOperationalState(c *gc.C) {
+	yes := true
+	config := &Config{Members: []Member{
+		{Id: 1}, {Id: 2}, {Id: 3, Arbiter: &yes},
+	}}
+	member := func(id int, healthy bool, state MemberState) MemberStatus {
+		return MemberStatus{Id: id, Healthy: healthy, State: state}
+	}
+	for i, test := range []struct {
+		about    string
+		members  []MemberStatus
+		expected SetState
+	}{{
+		about: "all members healthy",
+		members: []MemberStatus{
+			member(1, true, PrimaryState),
+			member(2, true, SecondaryState),
+			member(3, true, ArbiterState),
+		},
+		expected: Healthy,
+	}, {
+		about: "secondary down",
+		members: []MemberStatus{
+			member(1, true, PrimaryState),
+			member(2, false, DownState),
+			member(3, true, ArbiterState),
+		},
+		expected: Degraded,
+	}, {
+		about: "secondary recovering",
+		members: []MemberStatus{
+			member(1, true, PrimaryState),
+			member(2, true, RecoveringState),
+			member(3, true, ArbiterState),
+		},
+		expected: Degraded,
+	}, {
+		about: "no primary",
+		members: []MemberStatus{
+			member(1, true, SecondaryState),
+			member(2, true, SecondaryState),
+			member(3, true, ArbiterState),
+		},
+		expected: ReadOnly,
+	}, {
+		about: "no quorum",
+		members: []MemberStatus{
+			member(1, true, SecondaryState),
+			member(2, false, DownState),
+			member(3, false, DownState),
+		},
+		expected: Unavailable,
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		state := operationalState(&Status{Members: test.members}, config)
+		c.Check(state, gc.Equals, test.expected, gc.Commentf("got %v", state))
+	}
+}
+
+type syncTopologySuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&syncTopologySuite{})
+
+func (s *syncTopologySuite) TestSyncSourceFields(c *gc.C) {
+	for i, field := range []string{"syncSourceHost", "syncingTo"} {
+		c.Logf("test %d: %s", i, field)
+		data, err := bson.Marshal(bson.M{
+			"_id":   2,
+			"name":  "1.2.3.5:37017",
+			"state": 2,
+			field:   "1.2.3.4:37017",
+		})
+		c.Assert(err, jc.ErrorIsNil)
+		var member MemberStatus
+		err = bson.Unmarshal(data, &member)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(member.SyncSource, gc.Equals, "1.2.3.4:37017")
+	}
+}
+
+func (s *syncTopologySuite) TestSyncTopology(c *gc.C) {
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return &Status{Members: []MemberStatus{
+			{Id: 1, Address: "1.2.3.4:37017", State: PrimaryState},
+			{Id: 2, Address: "1.2.3.5:37017", State: SecondaryState, SyncSource: "1.2.3.4:37017"},
+			{Id: 3, Address: "1.2.3.6:37017", State: SecondaryState, SyncSource: "1.2.3.5:37017"},
+			{Id: 4, Address: "1.2.3.7:37017", State: ArbiterState},
+		}}, nil
+	})
+	topology, err := SyncTopology(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(topology, jc.DeepEquals, map[string]string{
+		"1.2.3.4:37017": "",
+		"1.2.3.5:37017": "1.2.3.4:37017",
+		"1.2.3.6:37017": "1.2.3.5:37017",
+		"1.2.3.7:37017": "",
+	})
+}
+
+func (s *syncTopologySuite) TestIsChaining(c *gc.C) {
+	members := []MemberStatus{
+		{Id: 1, Address: "1.2.3.4:37017", State: PrimaryState},
+		{Id: 2, Address: "1.2.3.5:37017", State: SecondaryState, SyncSource: "1.2.3.4:37017"},
+		{Id: 3, Address: "1.2.3.6:37017", State: SecondaryState, SyncSource: "1.2.3.4:37017"},
+	}
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return &Status{Members: members}, nil
+	})
+	chaining, chained, err := IsChaining(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(chaining, jc.IsFalse)
+	c.Check(chained, gc.HasLen, 0)
+
+	members[2].SyncSource = "1.2.3.5:37017"
+	chaining, chained, err = IsChaining(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(chaining, jc.IsTrue)
+	c.Check(chained, jc.DeepEquals, []string{"1.2.3.6:37017"})
+}
+
+type commandSuite struct {
+	testing.IsolationSuite
+
+	// commands holds the commands run, in order.
+	commands []interface{}
+}
+
+var _ = gc.Suite(&commandSuite{})
+
+func (s *commandSuite) SetUpTest(c *gc.C) {
+	s.IsolationSuite.SetUpTest(c)
+	s.commands = nil
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{
+			Name:            rsName,
+			ProtocolVersion: 1,
+			Version:         1,
+			Members:         []Member{{Id: 1, Address: "1.2.3.4:37017"}},
+		}, nil
+	})
+	s.PatchValue(&getBuildInfo, func(session *mgo.Session) (mgo.BuildInfo, error) {
+		return mgo.BuildInfo{Version: "4.4.1", VersionArray: []int{4, 4, 1, 0}}, nil
+	})
+}
+
+// patchCommands patches runCommand to record the commands run, replying
+// to each with the document and error returned by reply for its name.
+func (s *commandSuite) patchCommands(c *gc.C, reply func(name string) (bson.M, error)) {
+	s.PatchValue(&runCommand, func(session Runner, cmd interface{}, result interface{}) error {
+		s.commands = append(s.commands, cmd)
+		name, ok := cmd.(string)
+		if !ok {
+			name = cmd.(bson.D)[0].Name
+		}
+		doc, err := reply(name)
+		if err != nil || result == nil {
+			return err
+		}
+		data, err := bson.Marshal(doc)
+		c.Assert(err, jc.ErrorIsNil)
+		return bson.Unmarshal(data, result)
+	})
+}
+
+func (s *commandSuite) TestAddReconfigError(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		if name == "replSetReconfig" {
+			return nil, &mgo.QueryError{Code: 103, Message: "incompatible config"}
+		}
+		return bson.M{}, nil
+	})
+	err := Add(nil, Member{Address: "1.2.3.5:37017"})
+	c.Check(err, gc.ErrorMatches, `Add: replSetReconfig of replica set "juju" to version 2: incompatible config`)
+	_, ok := errors.Cause(err).(*mgo.QueryError)
+	c.Check(ok, jc.IsTrue)
+
+	c.Assert(s.commands, gc.HasLen, 1)
+	config := s.commands[0].(bson.D)[0].Value.(*Config)
+	c.Check(config.Members, gc.HasLen, 2)
+	c.Check(config.Members[1].Id, gc.Equals, 2)
+}
+
+func (s *commandSuite) TestSetWithWarnings(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		if name == "replSetReconfig" {
+			return bson.M{"ok": 1, "warnings": []string{"something odd"}}, nil
+		}
+		return bson.M{"ok": 1}, nil
+	})
+	members := []Member{{Address: "1.2.3.4:37017"}, {Address: "1.2.3.5:37017"}, {Address: "1.2.3.6:37017"}}
+	warnings, err := SetWithWarnings(nil, members)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(warnings, jc.DeepEquals, []string{"something odd"})
+
+	// The reconfig is followed by a ping.
+	c.Assert(s.commands, gc.HasLen, 2)
+	config := s.commands[0].(bson.D)[0].Value.(*Config)
+	c.Check(config.Version, gc.Equals, 2)
+	c.Check(s.commands[1], gc.Equals, "ping")
+}
+
+func (s *commandSuite) TestSetViaNotPrimary(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		c.Assert(name, gc.Equals, "isMaster")
+		return bson.M{
+			"ismaster":  false,
+			"secondary": true,
+			"me":        "1.2.3.5:37017",
+			"primary":   "1.2.3.4:37017",
+		}, nil
+	})
+	err := SetVia(nil, []Member{{Address: "1.2.3.4:37017"}})
+	c.Assert(errors.Cause(err), gc.Equals, ErrNotPrimary)
+	c.Check(err.(*NotPrimaryError).Primary, gc.Equals, "1.2.3.4:37017")
+	c.Check(s.commands, gc.HasLen, 1)
+}
+
+func (s *commandSuite) TestRemoveAndWait(c *gc.C) {
+	removed := false
+	statusCalls := 0
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		config := &Config{
+			Name:            rsName,
+			ProtocolVersion: 1,
+			Version:         1,
+			Members: []Member{
+				{Id: 1, Address: "1.2.3.4:37017"},
+				{Id: 2, Address: "1.2.3.5:37017"},
+			},
+		}
+		if removed {
+			config.Version = 2
+			config.Members = config.Members[:1]
+		}
+		return config, nil
+	})
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		statusCalls++
+		// The other member only catches up with the new config on the
+		// second status read.
+		version := 1
+		if statusCalls > 1 {
+			version = 2
+		}
+		return &Status{Members: []MemberStatus{
+			{Id: 1, Address: "1.2.3.4:37017", Healthy: true, State: PrimaryState, ConfigVersion: 2},
+			{Id: 3, Address: "1.2.3.6:37017", Healthy: true, State: SecondaryState, ConfigVersion: version},
+		}}, nil
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		if name == "replSetReconfig" {
+			removed = true
+		}
+		return bson.M{"ok": 1}, nil
+	})
+	err := RemoveAndWait(nil, "1.2.3.5:37017", 5*time.Second)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(removed, jc.IsTrue)
+	c.Check(statusCalls, gc.Equals, 3)
+}
+
+func (s *commandSuite) TestRemoveAndWaitTimeout(c *gc.C) {
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return &Status{Members: []MemberStatus{
+			{Id: 1, Address: "1.2.3.4:37017", Healthy: true, State: PrimaryState, ConfigVersion: 1},
+		}}, nil
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	// The mocked config never changes, so the member never goes away.
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{
+			Name:    rsName,
+			Version: 1,
+			Members: []Member{{Id: 1, Address: "1.2.3.4:37017"}, {Id: 2, Address: "1.2.3.5:37017"}},
+		}, nil
+	})
+	err := RemoveAndWait(nil, "1.2.3.5:37017", 10*time.Millisecond)
+	c.Check(err, gc.ErrorMatches, `timed out after 10ms waiting for 1.2.3.5:37017 to be removed from the config`)
+}
+
+func (s *commandSuite) TestMembersInState(c *gc.C) {
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return &Status{Members: []MemberStatus{
+			{Id: 1, Address: "1.2.3.4:37017", State: SecondaryState},
+			{Id: 2, Address: "1.2.3.5:37017", State: PrimaryState},
+			{Id: 3, Address: "1.2.3.6:37017", State: ArbiterState},
+			{Id: 4, Address: "1.2.3.7:37017", State: RecoveringState},
+			{Id: 5, Address: "1.2.3.8:37017", State: SecondaryState},
+		}}, nil
+	})
+	ids := func(members []MemberStatus) []int {
+		var ids []int
+		for _, member := range members {
+			ids = append(ids, member.Id)
+		}
+		return ids
+	}
+
+	members, err := MembersInState(nil, PrimaryState, SecondaryState)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(ids(members), jc.DeepEquals, []int{1, 2, 5})
+
+	members, err = MembersInState(nil, ArbiterState)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(ids(members), jc.DeepEquals, []int{3})
+
+	members, err = MembersInState(nil, RollbackState)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(members, gc.HasLen, 0)
+}
+
+func (s *commandSuite) TestCurrentStatusSortedById(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		c.Assert(name, gc.Equals, "replSetGetStatus")
+		return bson.M{
+			"set": rsName,
+			"members": []bson.M{
+				{"_id": 3, "name": "1.2.3.6:37017", "health": true, "state": 2},
+				{"_id": 1, "name": "1.2.3.4:37017", "health": true, "state": 1},
+				{"_id": 4, "name": "1.2.3.7:37017", "health": true, "state": 2},
+				{"_id": 2, "name": "1.2.3.5:37017", "health": true, "state": 2},
+			},
+		}, nil
+	})
+	status, err := CurrentStatus(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	var ids []int
+	var addrs []string
+	for _, member := range status.Members {
+		ids = append(ids, member.Id)
+		addrs = append(addrs, member.Address)
+	}
+	c.Check(ids, jc.DeepEquals, []int{1, 2, 3, 4})
+	c.Check(addrs, jc.DeepEquals, []string{"1.2.3.4:37017", "1.2.3.5:37017", "1.2.3.6:37017", "1.2.3.7:37017"})
+}
+
+func (s *commandSuite) TestCurrentMembersSortedById(c *gc.C) {
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		config := &Config{Name: rsName, Members: []Member{
+			{Id: 2, Address: "1.2.3.5:37017"},
+			{Id: 3, Address: "1.2.3.6:37017"},
+			{Id: 1, Address: "1.2.3.4:37017"},
+		}}
+		normalizeConfig(config)
+		return config, nil
+	})
+	members, err := CurrentMembers(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(members, gc.HasLen, 3)
+	for i, member := range members {
+		c.Check(member.Id, gc.Equals, i+1)
+	}
+}
+
+func (s *commandSuite) TestResolveSelfAddress(c *gc.C) {
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return &Status{Members: []MemberStatus{
+			{Id: 1, Address: "10.0.0.1:37017", State: PrimaryState, Healthy: true},
+			{Id: 2, Address: "[2001:db8::2]:37017", State: SecondaryState, Healthy: true, Self: true},
+		}}, nil
+	})
+	addr, err := ResolveSelfAddress(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(addr, gc.Equals, "[2001:db8::2]:37017")
+}
+
+func (s *commandSuite) TestResolveSelfAddressFromIsMaster(c *gc.C) {
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return nil, errors.New("no replset config has been received")
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		c.Assert(name, gc.Equals, "isMaster")
+		return bson.M{"ismaster": false, "secondary": true, "me": "node-2.internal:37017"}, nil
+	})
+	addr, err := ResolveSelfAddress(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(addr, gc.Equals, "node-2.internal:37017")
+}
+
+func (s *commandSuite) TestRemoveAndWaitNoTimeLeft(c *gc.C) {
+	reads := 0
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		reads++
+		members := []Member{{Id: 1, Address: "1.2.3.4:37017"}}
+		if reads == 1 {
+			members = append(members, Member{Id: 2, Address: "1.2.3.5:37017"})
+		}
+		return &Config{Name: rsName, Version: reads, Members: members}, nil
+	})
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		c.Fatalf("unexpected status read")
+		return nil, nil
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	err := RemoveAndWait(nil, "1.2.3.5:37017", time.Nanosecond)
+	c.Check(err, gc.ErrorMatches, `timed out after 1ns: 1.2.3.5:37017 was removed from the config, but its propagation to the other members is unconfirmed`)
+	c.Check(s.commands, gc.HasLen, 1)
+}
+
+func (s *commandSuite) TestSetKeepsMemberSettings(c *gc.C) {
+	priority := 2.0
+	hidden := false
+	votes := 0
+	delay := time.Duration(3600)
+	empty := map[string]string{}
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{
+			Name:    rsName,
+			Version: 1,
+			Members: []Member{{
+				Id:         1,
+				Address:    "1.2.3.4:37017",
+				Priority:   &priority,
+				Hidden:     &hidden,
+				SlaveDelay: &delay,
+				Tags:       map[string]string{"dc": "east"},
+				Extra:      bson.M{"horizons": bson.M{"external": "db.example.com:37017"}},
+			}, {
+				Id:       2,
+				Address:  "1.2.3.5:37017",
+				Priority: &priority,
+				Tags:     map[string]string{"dc": "west"},
+			}},
+		}, nil
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	members := func() []Member {
+		return []Member{
+			{Address: "1.2.3.4:37017", Votes: &votes},
+			{Address: "1.2.3.5:37017", Tags: empty},
+			{Address: "1.2.3.6:37017"},
+		}
+	}
+	// The delay field name depends on the server version only.
+	appliedMembers := func(i int) []Member {
+		config := s.commands[i].(bson.D)[0].Value.(*Config)
+		for i := range config.Members {
+			config.Members[i].delayField = ""
+		}
+		return config.Members
+	}
+
+	// By default, the members are applied as given.
+	err := Set(nil, members())
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(appliedMembers(0), jc.DeepEquals, []Member{
+		{Id: 1, Address: "1.2.3.4:37017", Votes: &votes},
+		{Id: 2, Address: "1.2.3.5:37017", Tags: empty},
+		{Id: 3, Address: "1.2.3.6:37017"},
+	})
+
+	err = SetWithOptions(nil, ReconfigOptions{KeepMemberSettings: true}, members())
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(appliedMembers(1), jc.DeepEquals, []Member{{
+		Id:         1,
+		Address:    "1.2.3.4:37017",
+		Priority:   &priority,
+		Hidden:     &hidden,
+		SlaveDelay: &delay,
+		Votes:      &votes,
+		Tags:       map[string]string{"dc": "east"},
+		Extra:      bson.M{"horizons": bson.M{"external": "db.example.com:37017"}},
+	}, {
+		Id:       2,
+		Address:  "1.2.3.5:37017",
+		Priority: &priority,
+		Tags:     empty,
+	}, {
+		Id:      3,
+		Address: "1.2.3.6:37017",
+	}})
+}
+
+func (s *commandSuite) TestSetInReplicaSet(c *gc.C) {
+	reads := 0
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		reads++
+		return &Config{
+			Name:    rsName,
+			Version: 1,
+			Members: []Member{{Id: 1, Address: "1.2.3.4:37017"}},
+		}, nil
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	members := []Member{{Address: "1.2.3.4:37017"}, {Address: "1.2.3.5:37017"}}
+
+	err := SetInReplicaSet(nil, "not-"+rsName, members)
+	c.Check(errors.Cause(err), gc.Equals, ErrReplicaSetNameMismatch)
+	c.Check(s.commands, gc.HasLen, 0)
+	c.Check(reads, gc.Equals, 1)
+
+	reads = 0
+	err = SetInReplicaSet(nil, rsName, members)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(reads, gc.Equals, 1)
+	config := s.commands[0].(bson.D)[0].Value.(*Config)
+	c.Check(config.Members, gc.HasLen, 2)
+}
+
+func (s *commandSuite) TestResyncMember(c *gc.C) {
+	s.PatchValue(&getBuildInfo, func(session *mgo.Session) (mgo.BuildInfo, error) {
+		return mgo.BuildInfo{Version: "4.0.10", VersionArray: []int{4, 0, 10, 0}}, nil
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	err := ResyncMember(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(s.commands, jc.DeepEquals, []interface{}{"resync"})
+}
+
+func (s *commandSuite) TestResyncMemberNotSupported(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	err := ResyncMember(nil)
+	c.Check(err, jc.Satisfies, errors.IsNotSupported)
+	c.Check(err, gc.ErrorMatches, "resync on MongoDB 4.4.1 not supported")
+	c.Check(s.commands, gc.HasLen, 0)
+}
+
+func (s *commandSuite) TestReconfigureAtVersion(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	err := ReconfigureAtVersion(nil, Config{
+		Members: []Member{{Address: "1.2.3.4:37017"}, {Address: "1.2.3.5:37017"}},
+	}, 1)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(s.commands, gc.HasLen, 1)
+	config := s.commands[0].(bson.D)[0].Value.(*Config)
+	c.Check(config.Name, gc.Equals, rsName)
+	c.Check(config.ProtocolVersion, gc.Equals, int64(1))
+	c.Check(config.Version, gc.Equals, 2)
+	c.Assert(config.Members, gc.HasLen, 2)
+	c.Check(config.Members[0].Id, gc.Equals, 1)
+	c.Check(config.Members[1].Id, gc.Equals, 2)
+}
+
+func (s *commandSuite) TestReconfigureAtVersionConflict(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	err := ReconfigureAtVersion(nil, Config{
+		Members: []Member{{Address: "1.2.3.4:37017"}},
+	}, 2)
+	c.Check(errors.Cause(err), gc.Equals, ErrConfigVersionConflict)
+	c.Check(err, gc.ErrorMatches, "expected version 2, found 1: .*")
+	c.Check(s.commands, gc.HasLen, 0)
+}
+
+func (s *commandSuite) TestAddArbiter(c *gc.C) {
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{
+			Name:    rsName,
+			Version: 1,
+			Members: []Member{
+				{Id: 1, Address: "1.2.3.4:37017"},
+				{Id: 2, Address: "1.2.3.5:37017"},
+			},
+		}, nil
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	err := AddArbiter(nil, "1.2.3.6:37017")
+	c.Assert(err, jc.ErrorIsNil)
+	config := s.commands[0].(bson.D)[0].Value.(*Config)
+	c.Assert(config.Members, gc.HasLen, 3)
+	arbiter := config.Members[2]
+	c.Check(arbiter.Id, gc.Equals, 3)
+	c.Check(arbiter.Address, gc.Equals, "1.2.3.6:37017")
+	c.Check(*arbiter.Arbiter, jc.IsTrue)
+	c.Check(*arbiter.Votes, gc.Equals, 1)
+	c.Check(*arbiter.Priority, gc.Equals, 0.0)
+}
+
+func (s *commandSuite) TestAddArbiterEvenVotingMembers(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		c.Fatalf("unexpected command %q", name)
+		return nil, nil
+	})
+	// The replica set has a single voting member.
+	err := AddArbiter(nil, "1.2.3.6:37017")
+	c.Check(err, jc.Satisfies, errors.IsNotValid)
+	c.Check(err, gc.ErrorMatches, `arbiter "1.2.3.6:37017" with an even number of voting members not valid`)
+}
+
+func (s *commandSuite) TestAddArbiterExisting(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		c.Fatalf("unexpected command %q", name)
+		return nil, nil
+	})
+	err := AddArbiter(nil, "1.2.3.4:37017")
+	c.Check(err, jc.Satisfies, errors.IsAlreadyExists)
+}
+
+// patchForceConfig patches CurrentConfig to return a config with three
+// members, and getCurrentStatus to return a status where the members with
+// the given addresses are healthy secondaries and the others are down.
+func (s *commandSuite) patchForceConfig(c *gc.C, up ...string) {
+	addrs := []string{"1.2.3.4:37017", "1.2.3.5:37017", "1.2.3.6:37017"}
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		config := &Config{Name: rsName, Version: 3}
+		for i, addr := range addrs {
+			config.Members = append(config.Members, Member{Id: i + 1, Address: addr})
+		}
+		return config, nil
+	})
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		status := rollingStatus(addrs, "", "")
+		for i, member := range status.Members {
+			status.Members[i].Healthy = false
+			status.Members[i].State = DownState
+			for _, addr := range up {
+				if member.Address == addr {
+					status.Members[i].Healthy = true
+					status.Members[i].State = SecondaryState
+				}
+			}
+		}
+		return status, nil
+	})
+}
+
+func (s *commandSuite) TestForceReconfig(c *gc.C) {
+	s.patchForceConfig(c, "1.2.3.4:37017")
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	err := RemoveWithOptions(nil, ReconfigOptions{Force: true}, "1.2.3.5:37017", "1.2.3.6:37017")
+	c.Assert(err, jc.ErrorIsNil)
+	reconfig := s.commands[0].(bson.D)
+	c.Assert(reconfig, gc.HasLen, 2)
+	c.Check(reconfig[1], gc.Equals, bson.DocElem{"force", true})
+	config := reconfig[0].Value.(*Config)
+	c.Assert(config.Members, gc.HasLen, 1)
+	c.Check(config.Members[0].Address, gc.Equals, "1.2.3.4:37017")
+}
+
+func (s *commandSuite) TestForceReconfigUnsafe(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		c.Fatalf("unexpected command %q", name)
+		return nil, nil
+	})
+	for i, test := range []struct {
+		about   string
+		up      []string
+		primary string
+		remove  []string
+		err     string
+	}{{
+		about:   "primary",
+		up:      []string{"1.2.3.4:37017", "1.2.3.5:37017"},
+		primary: "1.2.3.5:37017",
+		remove:  []string{"1.2.3.6:37017"},
+		err:     `Remove: unsafe forced reconfig: 1.2.3.5:37017 is primary; use a normal reconfig`,
+	}, {
+		about:  "member left out is up",
+		up:     []string{"1.2.3.4:37017", "1.2.3.6:37017"},
+		remove: []string{"1.2.3.5:37017", "1.2.3.6:37017"},
+		err:    `Remove: unsafe forced reconfig: members 1.2.3.6:37017 are up but left out of the new config`,
+	}, {
+		about:  "no majority",
+		up:     []string{"1.2.3.4:37017"},
+		remove: []string{"1.2.3.6:37017"},
+		err:    `Remove: unsafe forced reconfig: members that are up hold 1 of the 2 votes in the new config`,
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		s.patchForceConfig(c, test.up...)
+		if test.primary != "" {
+			status, err := getCurrentStatus(nil)
+			c.Assert(err, jc.ErrorIsNil)
+			s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+				for i, member := range status.Members {
+					if member.Address == test.primary {
+						status.Members[i].State = PrimaryState
+					}
+				}
+				return status, nil
+			})
+		}
+		err := RemoveWithOptions(nil, ReconfigOptions{Force: true}, test.remove...)
+		c.Check(err, gc.ErrorMatches, test.err)
+		c.Check(errors.Cause(err), gc.Equals, ErrUnsafeForceReconfig)
+	}
+}
+
+func (s *commandSuite) TestUpdateMember(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	err := UpdateMember(nil, 1, func(m *Member) {
+		priority := 2.0
+		m.Priority = &priority
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	config := s.commands[0].(bson.D)[0].Value.(*Config)
+	c.Check(config.Version, gc.Equals, 2)
+	c.Assert(config.Members, gc.HasLen, 1)
+	c.Assert(config.Members[0].Priority, gc.NotNil)
+	c.Check(*config.Members[0].Priority, gc.Equals, 2.0)
+}
+
+func (s *commandSuite) TestUpdateMemberErrors(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		c.Fatalf("unexpected command %q", name)
+		return nil, nil
+	})
+	err := UpdateMember(nil, 2, func(m *Member) {
+		c.Fatalf("unexpected update of member %d", m.Id)
+	})
+	c.Check(err, jc.Satisfies, errors.IsNotFound)
+
+	err = UpdateMember(nil, 1, func(m *Member) {
+		m.Id = 3
+	})
+	c.Check(err, gc.ErrorMatches, "changing the Id of member 1 not valid")
+}
+
+func (s *commandSuite) TestMemberTags(c *gc.C) {
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{
+			Name:    rsName,
+			Version: 1,
+			Members: []Member{{
+				Id:      1,
+				Address: "1.2.3.4:37017",
+				Tags:    map[string]string{"dc": "east", "rack": "r1"},
+			}},
+		}, nil
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	for i, test := range []struct {
+		set    bool
+		tags   map[string]string
+		expect map[string]string
+	}{{
+		set:    true,
+		tags:   map[string]string{"zone": "a"},
+		expect: map[string]string{"zone": "a"},
+	}, {
+		set:    true,
+		tags:   nil,
+		expect: nil,
+	}, {
+		tags:   map[string]string{"rack": "r2", "zone": "a"},
+		expect: map[string]string{"dc": "east", "rack": "r2", "zone": "a"},
+	}} {
+		c.Logf("test %d", i)
+		s.commands = nil
+		var err error
+		if test.set {
+			err = SetMemberTags(nil, "1.2.3.4:37017", test.tags)
+		} else {
+			err = MergeMemberTags(nil, "1.2.3.4:37017", test.tags)
+		}
+		c.Assert(err, jc.ErrorIsNil)
+		config := s.commands[0].(bson.D)[0].Value.(*Config)
+		c.Check(config.Members[0].Tags, jc.DeepEquals, test.expect)
+	}
+
+	err := SetMemberTags(nil, "1.2.3.9:37017", map[string]string{"zone": "a"})
+	c.Check(err, jc.Satisfies, errors.IsNotFound)
+}
+
+func (s *commandSuite) TestRemoveById(c *gc.C) {
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{
+			Name:    rsName,
+			Version: 1,
+			Members: []Member{
+				{Id: 1, Address: "1.2.3.4:37017"},
+				{Id: 2, Address: "db.example.com:37017"},
+				{Id: 3, Address: "db.example.com:37017"},
+			},
+		}, nil
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	err := RemoveById(nil, 3, 9)
+	c.Assert(err, jc.ErrorIsNil)
+	config := s.commands[0].(bson.D)[0].Value.(*Config)
+	c.Check(config.Version, gc.Equals, 2)
+	c.Assert(config.Members, gc.HasLen, 2)
+	c.Check(config.Members[0].Id, gc.Equals, 1)
+	c.Check(config.Members[1].Id, gc.Equals, 2)
+}
+
+func (s *commandSuite) TestReplicationLag(c *gc.C) {
+	now := time.Now()
+	primary := MemberState(PrimaryState)
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return &Status{Members: []MemberStatus{
+			{Id: 1, Address: "1.2.3.4:37017", Healthy: true, State: primary, OptimeApplied: now},
+			{Id: 2, Address: "1.2.3.5:37017", Healthy: true, State: SecondaryState, OptimeApplied: now.Add(-3 * time.Second)},
+			{Id: 3, Address: "1.2.3.6:37017", Healthy: false, State: DownState, OptimeApplied: now.Add(-time.Hour)},
+			{Id: 4, Address: "1.2.3.7:37017", Healthy: true, State: ArbiterState},
+		}}, nil
+	})
+	lags, err := ReplicationLag(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(lags, jc.DeepEquals, map[string]time.Duration{
+		"1.2.3.4:37017": 0,
+		"1.2.3.5:37017": 3 * time.Second,
+	})
+
+	primary = SecondaryState
+	_, err = ReplicationLag(nil)
+	c.Check(errors.Cause(err), gc.Equals, ErrNoPrimaryElected)
+}
+
+func (s *commandSuite) TestWaitForPrimary(c *gc.C) {
+	addrs := []string{"1.2.3.4:37017", "1.2.3.5:37017"}
+	calls := 0
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		calls++
+		if calls < 3 {
+			return rollingStatus(addrs, "", ""), nil
+		}
+		return rollingStatus(addrs, addrs[1], ""), nil
+	})
+	primary, err := WaitForPrimary(context.Background(), nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(primary, gc.Equals, addrs[1])
+	c.Check(calls, gc.Equals, 3)
+}
+
+func (s *commandSuite) TestWaitForPrimaryCancelled(c *gc.C) {
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return rollingStatus([]string{"1.2.3.4:37017"}, "", ""), nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := WaitForPrimary(ctx, nil)
+	c.Check(err, gc.ErrorMatches, `waiting for a primary \(last error: no primary elected\): context deadline exceeded`)
+	c.Check(errors.Cause(err), gc.Equals, context.DeadlineExceeded)
+}
+
+func (s *commandSuite) TestStepDownOptionsCommand(c *gc.C) {
+	for i, test := range []struct {
+		opts   StepDownOptions
+		expect bson.D
+	}{{
+		expect: bson.D{{"replSetStepDown", 60.0}},
+	}, {
+		opts: StepDownOptions{
+			StepDownPeriod: 90 * time.Second,
+			CatchUpPeriod:  1500 * time.Millisecond,
+			Force:          true,
+		},
+		expect: bson.D{
+			{"replSetStepDown", 90.0},
+			{"secondaryCatchUpPeriodSecs", 2.0},
+			{"force", true},
+		},
+	}} {
+		c.Logf("test %d", i)
+		cmd, err := test.opts.command()
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(cmd, jc.DeepEquals, test.expect)
+	}
+}
+
+func (s *commandSuite) TestStepDownPrimaryWithOptionsInvalid(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		c.Fatalf("unexpected command %q", name)
+		return nil, nil
+	})
+	err := StepDownPrimaryWithOptions(nil, StepDownOptions{
+		StepDownPeriod: 10 * time.Second,
+		CatchUpPeriod:  10 * time.Second,
+	})
+	c.Check(err, jc.Satisfies, errors.IsNotValid)
+	c.Check(err, gc.ErrorMatches, "catch up period 10s for step down period 10s not valid")
+}
+
+func (s *commandSuite) TestFreeze(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	err := Freeze(nil, 90500*time.Millisecond)
+	c.Assert(err, jc.ErrorIsNil)
+	err = Unfreeze(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(s.commands, jc.DeepEquals, []interface{}{
+		bson.D{{"replSetFreeze", 91}},
+		bson.D{{"replSetFreeze", 0}},
+	})
+
+	err = Freeze(nil, 0)
+	c.Check(err, gc.ErrorMatches, "freeze duration 0s not valid")
+}
+
+func (s *commandSuite) TestFreezeError(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return nil, &mgo.QueryError{Message: "cannot freeze node when primary or running for election"}
+	})
+	err := Freeze(nil, time.Minute)
+	c.Check(err, gc.ErrorMatches, "replSetFreeze: cannot freeze node when primary or running for election")
+}
+
+func (s *commandSuite) TestMaintenanceMode(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	err := EnterMaintenanceMode(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	err = ExitMaintenanceMode(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(s.commands, jc.DeepEquals, []interface{}{
+		bson.D{{"replSetMaintenance", true}},
+		bson.D{{"replSetMaintenance", false}},
+	})
+}
+
+func (s *commandSuite) TestExitMaintenanceModeNotInMaintenance(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return nil, &mgo.QueryError{Message: "already out of maintenance mode"}
+	})
+	err := ExitMaintenanceMode(nil)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *commandSuite) TestClearHoldsMaintenanceMode(c *gc.C) {
+	for i, test := range []struct {
+		about    string
+		requests int
+		reported bool
+		exits    int
+	}{{
+		about:    "count reported by the member",
+		requests: 3,
+		reported: true,
+		exits:    3,
+	}, {
+		about:    "stale count reported by the member",
+		requests: 2,
+		exits:    3,
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		dialed := &mgo.Session{}
+		s.PatchValue(&dialMember, func(addr string, timeout time.Duration) (*mgo.Session, error) {
+			c.Check(addr, gc.Equals, "1.2.3.5:37017")
+			return dialed, nil
+		})
+		requests := test.requests
+		s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+			if session != dialed {
+				return &Status{Members: []MemberStatus{
+					{Id: 1, Address: "1.2.3.4:37017", State: PrimaryState, Healthy: true},
+					{Id: 2, Address: "1.2.3.5:37017", State: RecoveringState, Healthy: true},
+				}}, nil
+			}
+			self := MemberStatus{Id: 2, Address: "1.2.3.5:37017", State: RecoveringState, Healthy: true, Self: true}
+			if test.reported {
+				self.MaintenanceMode = requests
+			} else {
+				// Report a stale count, so that only mongo saying that
+				// the member is already out of maintenance mode stops
+				// the loop.
+				self.MaintenanceMode = 1
+			}
+			return &Status{Members: []MemberStatus{self}}, nil
+		})
+		exits := 0
+		s.patchCommands(c, func(name string) (bson.M, error) {
+			if name != "replSetMaintenance" {
+				return bson.M{"ok": 1}, nil
+			}
+			exits++
+			if requests == 0 {
+				return nil, &mgo.QueryError{Message: "already out of maintenance mode"}
+			}
+			requests--
+			return bson.M{"ok": 1}, nil
+		})
+		err := ClearHolds(nil)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(exits, gc.Equals, test.exits)
+		c.Check(requests, gc.Equals, 0)
+	}
+}
+
+func (s *commandSuite) TestCurrentStatusMaintenanceMode(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"set": rsName, "members": []bson.M{{
+			"_id":             1,
+			"name":            "1.2.3.4:37017",
+			"health":          1,
+			"state":           3,
+			"self":            true,
+			"maintenanceMode": 1,
+		}}}, nil
+	})
+	status, err := CurrentStatus(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(status.Members, gc.HasLen, 1)
+	c.Check(status.Members[0].State, gc.Equals, MemberState(RecoveringState))
+	c.Check(status.Members[0].MaintenanceMode, gc.Equals, 1)
+}
+
+// patchUnreachable patches CurrentConfig and getCurrentStatus for a
+// replica set of three members: a healthy primary and two members that
+// were last heard from at the given times, or never if zero. The second
+// one is healthy if healthy is true.
+func (s *commandSuite) patchUnreachable(c *gc.C, healthy bool, lastHeard ...time.Time) {
+	addrs := []string{"1.2.3.4:37017", "1.2.3.5:37017", "1.2.3.6:37017"}
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		config := &Config{Name: rsName, Version: 1}
+		for i, addr := range addrs {
+			config.Members = append(config.Members, Member{Id: i + 1, Address: addr})
+		}
+		return config, nil
+	})
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		status := rollingStatus(addrs, addrs[0], "")
+		status.Members[0].Self = true
+		for i, heard := range lastHeard {
+			member := &status.Members[i+1]
+			member.Healthy = i == 0 && healthy
+			if !member.Healthy {
+				member.State = DownState
+			}
+			member.LastHeartbeatRecv = heard
+		}
+		return status, nil
+	})
+}
+
+func (s *commandSuite) TestRemoveUnreachable(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	now := time.Now()
+	s.patchUnreachable(c, true, now, now.Add(-time.Hour))
+	removed, err := RemoveUnreachable(nil, time.Minute)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(removed, jc.DeepEquals, []string{"1.2.3.6:37017"})
+	config := s.commands[0].(bson.D)[0].Value.(*Config)
+	c.Assert(config.Members, gc.HasLen, 2)
+	c.Check(config.Members[1].Address, gc.Equals, "1.2.3.5:37017")
+}
+
+func (s *commandSuite) TestRemoveUnreachableWithinGrace(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		c.Fatalf("unexpected command %q", name)
+		return nil, nil
+	})
+	// The last member was heard from recently, or never, as when it
+	// has just been added or restarted.
+	for i, lastHeard := range []time.Time{time.Now().Add(-time.Second), {}} {
+		c.Logf("test %d", i)
+		s.patchUnreachable(c, true, time.Now(), lastHeard)
+		removed, err := RemoveUnreachable(nil, time.Minute)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(removed, gc.HasLen, 0)
+	}
+}
+
+func (s *commandSuite) TestRemoveUnreachableNoMajority(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		c.Fatalf("unexpected command %q", name)
+		return nil, nil
+	})
+	// Of the two members left, only the primary is healthy.
+	s.patchUnreachable(c, false, time.Now(), time.Now().Add(-time.Hour))
+	removed, err := RemoveUnreachable(nil, time.Minute)
+	c.Check(err, gc.ErrorMatches, "cannot remove unreachable members: only 1 of the remaining 2 votes are healthy")
+	c.Check(removed, gc.HasLen, 0)
+}
+
+func (s *commandSuite) TestSetMaxTime(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	err := SetWithOptions(nil, ReconfigOptions{MaxTime: 2500 * time.Millisecond}, []Member{{Address: "1.2.3.4:37017"}})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(s.commands, gc.HasLen, 2)
+	reconfig := s.commands[0].(bson.D)
+	c.Assert(reconfig, gc.HasLen, 2)
+	c.Check(reconfig[1], gc.Equals, bson.DocElem{"maxTimeMS", int64(2500)})
+}
+
+func (s *commandSuite) TestReconfigMaxTimeExpired(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		if name == "replSetReconfig" {
+			return nil, &mgo.QueryError{Code: 50, Message: "operation exceeded time limit"}
+		}
+		return bson.M{"ok": 1}, nil
+	})
+	for i, test := range []struct {
+		currentVersion int
+		err            string
+	}{{
+		currentVersion: 1,
+		err:            `Add: replSetReconfig to version 2 timed out and was not applied: operation exceeded time limit`,
+	}, {
+		currentVersion: 2,
+		err:            `Add: replSetReconfig to version 2 timed out but was applied: operation exceeded time limit`,
+	}} {
+		c.Logf("test %d: current version %d", i, test.currentVersion)
+		reads := 0
+		s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+			reads++
+			version := 1
+			if reads > 1 {
+				version = test.currentVersion
+			}
+			return &Config{
+				Name:    rsName,
+				Version: version,
+				Members: []Member{{Id: 1, Address: "1.2.3.4:37017"}},
+			}, nil
+		})
+		err := AddWithOptions(nil, ReconfigOptions{MaxTime: time.Second}, Member{Address: "1.2.3.5:37017"})
+		c.Check(err, gc.ErrorMatches, test.err)
+		timeoutErr, ok := errors.Cause(err).(*ReconfigTimeoutError)
+		c.Assert(ok, jc.IsTrue)
+		c.Check(timeoutErr.Applied, gc.Equals, test.currentVersion == 2)
+		c.Check(reads, gc.Equals, 2)
+	}
+}
+
+func (s *commandSuite) TestIsolate(c *gc.C) {
+	var applied []*Config
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		if len(applied) > 0 {
+			config := *applied[len(applied)-1]
+			config.Members = append([]Member(nil), config.Members...)
+			return &config, nil
+		}
+		return &Config{
+			Name:    rsName,
+			Version: 1,
+			Members: []Member{
+				{Id: 1, Address: "1.2.3.4:37017"},
+				{Id: 2, Address: "1.2.3.5:37017"},
+				{Id: 3, Address: "1.2.3.6:37017"},
+				{Id: 4, Address: "1.2.3.7:37017"},
+			},
+		}, nil
+	})
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return &Status{Members: []MemberStatus{
+			{Id: 1, Address: "1.2.3.4:37017", Healthy: true, State: PrimaryState},
+			{Id: 2, Address: "1.2.3.5:37017", Healthy: true, State: SecondaryState},
+			{Id: 3, Address: "1.2.3.6:37017", Healthy: true, State: SecondaryState},
+			{Id: 4, Address: "1.2.3.7:37017", Healthy: true, State: SecondaryState},
+		}}, nil
+	})
+	s.PatchValue(&runCommand, func(session Runner, cmd interface{}, result interface{}) error {
+		if doc, ok := cmd.(bson.D); ok && doc[0].Name == "replSetReconfig" {
+			applied = append(applied, doc[0].Value.(*Config))
+		}
+		return nil
+	})
+
+	restore, err := Isolate(nil, "1.2.3.6:37017")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(applied, gc.HasLen, 1)
+	isolated := applied[0].Members[2]
+	c.Assert(isolated.Votes, gc.NotNil)
+	c.Check(*isolated.Votes, gc.Equals, 0)
+	c.Assert(isolated.Priority, gc.NotNil)
+	c.Check(*isolated.Priority, gc.Equals, 0.0)
+	c.Assert(isolated.Hidden, gc.NotNil)
+	c.Check(*isolated.Hidden, jc.IsTrue)
+
+	err = restore()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(applied, gc.HasLen, 2)
+	c.Check(applied[1].Members[2], jc.DeepEquals, Member{Id: 3, Address: "1.2.3.6:37017", delayField: slaveDelayField})
+}
+
+func (s *commandSuite) TestCordonPrimary(c *gc.C) {
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return &Status{Members: []MemberStatus{
+			{Id: 1, Address: "1.2.3.4:37017", Healthy: true, State: PrimaryState},
+		}}, nil
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+
+	_, err := Cordon(nil, "1.2.3.4:37017")
+	c.Check(err, gc.ErrorMatches, `cordoning primary "1.2.3.4:37017" not valid`)
+	c.Check(err, jc.Satisfies, errors.IsNotValid)
+	c.Check(s.commands, gc.HasLen, 0)
+}
+
+func (s *commandSuite) TestIsolateEvenVotingMembers(c *gc.C) {
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{
+			Name:    rsName,
+			Version: 1,
+			Members: []Member{
+				{Id: 1, Address: "1.2.3.4:37017"},
+				{Id: 2, Address: "1.2.3.5:37017"},
+				{Id: 3, Address: "1.2.3.6:37017"},
+			},
+		}, nil
+	})
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return &Status{Members: []MemberStatus{
+			{Id: 1, Address: "1.2.3.4:37017", Healthy: true, State: PrimaryState},
+			{Id: 2, Address: "1.2.3.5:37017", Healthy: true, State: SecondaryState},
+			{Id: 3, Address: "1.2.3.6:37017", Healthy: true, State: SecondaryState},
+		}}, nil
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+
+	_, err := Isolate(nil, "1.2.3.6:37017")
+	c.Check(err, gc.ErrorMatches, `cannot isolate "1.2.3.6:37017": it would leave an even number of voting members \(2\)`)
+	c.Check(err, jc.Satisfies, errors.IsNotValid)
+	c.Check(s.commands, gc.HasLen, 0)
+}
+
+func (s *commandSuite) TestIsolateRefusals(c *gc.C) {
+	yes := true
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{
+			Name:    rsName,
+			Version: 1,
+			Members: []Member{
+				{Id: 1, Address: "1.2.3.4:37017"},
+				{Id: 2, Address: "1.2.3.5:37017"},
+				{Id: 3, Address: "1.2.3.6:37017", Arbiter: &yes},
+			},
+		}, nil
+	})
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return &Status{Members: []MemberStatus{
+			{Id: 1, Address: "1.2.3.4:37017", Healthy: true, State: PrimaryState},
+			{Id: 2, Address: "1.2.3.5:37017", Healthy: true, State: SecondaryState},
+			{Id: 3, Address: "1.2.3.6:37017", Healthy: true, State: ArbiterState},
+		}}, nil
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+
+	_, err := Isolate(nil, "1.2.3.4:37017")
+	c.Check(err, gc.ErrorMatches, `isolating primary "1.2.3.4:37017" not valid`)
+	_, err = Isolate(nil, "1.2.3.6:37017")
+	c.Check(err, gc.ErrorMatches, `isolating arbiter "1.2.3.6:37017" not valid`)
+	_, err = Isolate(nil, "1.2.3.5:37017")
+	c.Check(err, gc.ErrorMatches, `cannot isolate "1.2.3.5:37017": new config would leave 1 of 2 votes on healthy data-bearing members, need 2`)
+	_, err = Isolate(nil, "1.2.3.7:37017")
+	c.Check(err, jc.Satisfies, errors.IsNotFound)
+	c.Check(s.commands, gc.HasLen, 0)
+}
+
+func (s *commandSuite) TestCurrentStatusStableRecoveryTimestamp(c *gc.C) {
+	stable := time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+	reply := bson.M{
+		"set": rsName,
+		"members": []bson.M{
+			{"_id": 1, "name": "1.2.3.4:37017", "health": true, "state": 1, "self": true},
+			{"_id": 2, "name": "1.2.3.5:37017", "health": true, "state": 2},
+		},
+		"lastStableRecoveryTimestamp": bson.MongoTimestamp(stable.Unix()<<32 | 3),
+	}
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return reply, nil
+	})
+	status, err := CurrentStatus(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(status.Members[0].StableRecoveryTimestamp, gc.Equals, stable)
+	c.Check(status.Members[1].StableRecoveryTimestamp.IsZero(), jc.IsTrue)
+
+	// Servers that do not report it leave it zero.
+	delete(reply, "lastStableRecoveryTimestamp")
+	status, err = CurrentStatus(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(status.Members[0].StableRecoveryTimestamp.IsZero(), jc.IsTrue)
+}
+
+func (s *commandSuite) TestDemotePrimary(c *gc.C) {
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{
+			Name:    rsName,
+			Version: 1,
+			Members: []Member{
+				{Id: 1, Address: "1.2.3.4:37017"},
+				{Id: 2, Address: "1.2.3.5:37017"},
+				{Id: 3, Address: "1.2.3.6:37017"},
+			},
+		}, nil
+	})
+	steppedDown := false
+	s.PatchValue(&stepDownPrimary, func(session *mgo.Session) error {
+		steppedDown = true
+		return nil
+	})
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		primary := 0
+		if steppedDown {
+			primary = 1
+		}
+		status := &Status{Members: []MemberStatus{
+			{Id: 1, Address: "1.2.3.4:37017", Healthy: true, State: SecondaryState},
+			{Id: 2, Address: "1.2.3.5:37017", Healthy: true, State: SecondaryState},
+			{Id: 3, Address: "1.2.3.6:37017", Healthy: true, State: SecondaryState},
+		}}
+		status.Members[primary].State = PrimaryState
+		return status, nil
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+
+	newPrimary, err := DemotePrimary(nil, time.Minute)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(newPrimary, gc.Equals, "1.2.3.5:37017")
+	c.Check(steppedDown, jc.IsTrue)
+	c.Assert(s.commands, gc.HasLen, 2)
+	config := s.commands[0].(bson.D)[0].Value.(*Config)
+	c.Assert(config.Members[0].Priority, gc.NotNil)
+	c.Check(*config.Members[0].Priority, gc.Equals, 0.0)
+	c.Check(config.Members[1].Priority, gc.IsNil)
+}
+
+func (s *commandSuite) TestDemotePrimaryNoElectableMember(c *gc.C) {
+	zero := 0.0
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{
+			Name:    rsName,
+			Version: 1,
+			Members: []Member{
+				{Id: 1, Address: "1.2.3.4:37017"},
+				{Id: 2, Address: "1.2.3.5:37017", Priority: &zero},
+				{Id: 3, Address: "1.2.3.6:37017"},
+			},
+		}, nil
+	})
+	s.PatchValue(&stepDownPrimary, func(session *mgo.Session) error {
+		c.Fatalf("unexpected step down")
+		return nil
+	})
+	s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+		return &Status{Members: []MemberStatus{
+			{Id: 1, Address: "1.2.3.4:37017", Healthy: true, State: PrimaryState},
+			{Id: 2, Address: "1.2.3.5:37017", Healthy: true, State: SecondaryState},
+			{Id: 3, Address: "1.2.3.6:37017", Healthy: false, State: DownState},
+		}}, nil
+	})
+	_, err := DemotePrimary(nil, time.Minute)
+	c.Check(err, gc.ErrorMatches, `cannot demote 1.2.3.4:37017: no other member can become primary`)
+}
+
+func (s *commandSuite) TestCurrentStatusInfoMessage(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{
+			"set": rsName,
+			"members": []bson.M{
+				{"_id": 1, "name": "1.2.3.4:37017", "health": true, "state": 1, "self": true, "infoMessage": ""},
+				{"_id": 2, "name": "1.2.3.5:37017", "health": true, "state": 2,
+					"infoMessage": "could not find member to sync from"},
+				{"_id": 3, "name": "1.2.3.6:37017", "health": true, "state": 2},
+			},
+		}, nil
+	})
+	status, err := CurrentStatus(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(status.Members[0].InfoMessage, gc.Equals, "")
+	c.Check(status.Members[1].InfoMessage, gc.Equals, "could not find member to sync from")
+	c.Check(status.Members[2].InfoMessage, gc.Equals, "")
+}
+
+func (s *commandSuite) TestReconfigRejectsNewlyAdded(c *gc.C) {
+	yes := true
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{
+			Name:    rsName,
+			Version: 1,
+			Members: []Member{
+				{Id: 1, Address: "1.2.3.4:37017"},
+				{Id: 2, Address: "1.2.3.5:37017", NewlyAdded: &yes},
+			},
+		}, nil
+	})
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+
+	err := Add(nil, Member{Address: "1.2.3.6:37017", NewlyAdded: &yes})
+	c.Check(err, gc.ErrorMatches, `setting newlyAdded on member "1.2.3.6:37017" not valid`)
+	c.Check(err, jc.Satisfies, errors.IsNotValid)
+	err = Set(nil, []Member{{Address: "1.2.3.4:37017", NewlyAdded: &yes}})
+	c.Check(err, jc.Satisfies, errors.IsNotValid)
+	c.Check(s.commands, gc.HasLen, 0)
+
+	// Carrying the flag over from the current config is fine.
+	members, err := CurrentMembers(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	err = Set(nil, members)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *commandSuite) TestDialPrimary(c *gc.C) {
+	for i, test := range []struct {
+		about        string
+		seen         bson.M
+		dialedMaster bool
+		err          string
+	}{{
+		about:        "primary found",
+		seen:         bson.M{"ismaster": false, "setName": rsName, "primary": "1.2.3.4:37017"},
+		dialedMaster: true,
+	}, {
+		about: "election in progress",
+		seen:  bson.M{"ismaster": false, "setName": rsName},
+		err:   "no primary elected",
+	}, {
+		about: "not initiated",
+		seen:  bson.M{"ismaster": false},
+		err:   "mongo master not configured",
+	}, {
+		about: "primary stepped down",
+		seen:  bson.M{"ismaster": false, "setName": rsName, "primary": "1.2.3.4:37017"},
+		err:   "1.2.3.4:37017 is no longer primary: no primary elected",
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		dialed := &mgo.Session{}
+		var dialedAddrs []string
+		s.PatchValue(&dialMember, func(addr string, timeout time.Duration) (*mgo.Session, error) {
+			dialedAddrs = append(dialedAddrs, addr)
+			return dialed, nil
+		})
+		s.PatchValue(&runCommand, func(session Runner, cmd interface{}, result interface{}) error {
+			c.Assert(cmd, gc.Equals, "isMaster")
+			doc := test.seen
+			if session == dialed {
+				doc = bson.M{"ismaster": test.dialedMaster, "setName": rsName}
+			}
+			data, err := bson.Marshal(doc)
+			c.Assert(err, jc.ErrorIsNil)
+			return bson.Unmarshal(data, result)
+		})
+		primarySession, err := DialPrimary(nil, time.Second)
+		if test.err != "" {
+			c.Check(err, gc.ErrorMatches, test.err)
+			c.Check(primarySession, gc.IsNil)
+			continue
+		}
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(primarySession, gc.Equals, dialed)
+		c.Check(dialedAddrs, jc.DeepEquals, []string{"1.2.3.4:37017"})
+	}
+}
+
+func (s *commandSuite) TestWritableFromMemberView(c *gc.C) {
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{Name: rsName, Version: 1, Members: []Member{
+			{Id: 1, Address: "1.2.3.4:37017"},
+			{Id: 2, Address: "1.2.3.5:37017"},
+			{Id: 3, Address: "1.2.3.6:37017"},
+		}}, nil
+	})
+	for i, test := range []struct {
+		about    string
+		isMaster bson.M
+		members  []MemberStatus
+		primary  string
+		writable bool
+	}{{
+		about:    "primary seen as healthy with a majority",
+		isMaster: bson.M{"ismaster": false, "secondary": true, "primary": "1.2.3.4:37017"},
+		members: []MemberStatus{
+			{Id: 1, Address: "1.2.3.4:37017", Healthy: true, State: PrimaryState},
+			{Id: 2, Address: "1.2.3.5:37017", Healthy: true, State: SecondaryState, Self: true},
+			{Id: 3, Address: "1.2.3.6:37017", Healthy: false, State: DownState},
+		},
+		primary:  "1.2.3.4:37017",
+		writable: true,
+	}, {
+		about:    "primary without a majority",
+		isMaster: bson.M{"ismaster": false, "secondary": true, "primary": "1.2.3.4:37017"},
+		members: []MemberStatus{
+			{Id: 1, Address: "1.2.3.4:37017", Healthy: true, State: PrimaryState},
+			{Id: 2, Address: "1.2.3.5:37017", Healthy: false, State: DownState},
+			{Id: 3, Address: "1.2.3.6:37017", Healthy: false, State: DownState},
+		},
+		primary: "1.2.3.4:37017",
+	}, {
+		about:    "primary seen as down",
+		isMaster: bson.M{"ismaster": false, "secondary": true, "primary": "1.2.3.4:37017"},
+		members: []MemberStatus{
+			{Id: 1, Address: "1.2.3.4:37017", Healthy: false, State: DownState},
+			{Id: 2, Address: "1.2.3.5:37017", Healthy: true, State: SecondaryState, Self: true},
+			{Id: 3, Address: "1.2.3.6:37017", Healthy: true, State: SecondaryState},
+		},
+		primary: "1.2.3.4:37017",
+	}, {
+		about:    "no primary",
+		isMaster: bson.M{"ismaster": false, "secondary": true},
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		s.patchCommands(c, func(name string) (bson.M, error) {
+			c.Assert(name, gc.Equals, "isMaster")
+			return test.isMaster, nil
+		})
+		members := test.members
+		s.PatchValue(&getCurrentStatus, func(session *mgo.Session) (*Status, error) {
+			return &Status{Members: members}, nil
+		})
+		primary, writable, err := WritableFromMemberView(nil)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(primary, gc.Equals, test.primary)
+		c.Check(writable, gc.Equals, test.writable)
+	}
+}
+
+func (s *commandSuite) TestRequireTags(c *gc.C) {
+	yes := true
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{Name: rsName, Version: 1, Members: []Member{
+			{Id: 1, Address: "1.2.3.4:37017", Tags: map[string]string{"region": "eu", "rack": "a"}},
+			{Id: 2, Address: "1.2.3.5:37017", Tags: map[string]string{"region": "eu"}},
+			{Id: 3, Address: "1.2.3.6:37017", Arbiter: &yes},
+		}}, nil
+	})
+	missing, err := RequireTags(nil, []string{"region", "rack"})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(missing, jc.DeepEquals, []string{"1.2.3.5:37017"})
+
+	missing, err = RequireTags(nil, []string{"region"})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(missing, gc.HasLen, 0)
+}
+
+func (s *commandSuite) TestReconfigRequiredTags(c *gc.C) {
+	s.patchCommands(c, func(name string) (bson.M, error) {
+		return bson.M{"ok": 1}, nil
+	})
+	opts := ReconfigOptions{RequiredTags: []string{"region", "rack"}}
+	err := AddWithOptions(nil, opts, Member{Address: "1.2.3.5:37017", Tags: map[string]string{"region": "eu"}})
+	c.Check(err, gc.ErrorMatches, `members 1.2.3.5:37017 without required tags region, rack not valid`)
+	c.Check(err, jc.Satisfies, errors.IsNotValid)
+	err = SetWithOptions(nil, opts, []Member{{Address: "1.2.3.4:37017"}})
+	c.Check(err, jc.Satisfies, errors.IsNotValid)
+	c.Check(s.commands, gc.HasLen, 0)
+
+	err = AddWithOptions(nil, opts, Member{Address: "1.2.3.5:37017", Tags: map[string]string{"region": "eu", "rack": "b"}})
+	c.Assert(err, jc.ErrorIsNil)
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"github.com/juju/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Runner runs commands against the admin database of a mongo server,
+// unmarshalling each reply into result. *mgo.Session implements it, and
+// other implementations can be used to mock the server, to instrument the
+// commands sent or to run them with another driver.
+//
+// Only ReadIsMaster, ReadStatus and ReadConfig accept a Runner; they are
+// the Runner variants of IsMaster, CurrentStatus and CurrentConfig. The
+// other functions still need an *mgo.Session, as they rely on its read
+// modes, on cloning it or on reading the local database directly.
+type Runner interface {
+	Run(cmd interface{}, result interface{}) error
+}
+
+var _ Runner = (*mgo.Session)(nil)
+
+// ReadStatus is like CurrentStatus, but runs the command through the
+// given runner.
+func ReadStatus(r Runner) (*Status, error) {
+	status, err := readStatus(r)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return status, checkPartialStatus(status)
+}
+
+// ReadConfig returns the config of the replica set as reported by
+// replSetGetConfig through the given runner, with its members sorted by
+// Id.
+func ReadConfig(r Runner) (*Config, error) {
+	var result struct {
+		Config Config `bson:"config"`
+	}
+	if err := runCommand(r, bson.D{{"replSetGetConfig", 1}}, &result); err != nil {
+		return nil, errors.Annotate(err, "cannot get replset config")
+	}
+	normalizeConfig(&result.Config)
+	return &result.Config, nil
+}
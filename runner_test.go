@@ -0,0 +1,92 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type runnerSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&runnerSuite{})
+
+// fakeRunner replies to each command with the document held for its name,
+// failing for commands it has no reply for.
+type fakeRunner map[string]bson.M
+
+func (r fakeRunner) Run(cmd interface{}, result interface{}) error {
+	name, ok := cmd.(string)
+	if !ok {
+		name = cmd.(bson.D)[0].Name
+	}
+	doc, ok := r[name]
+	if !ok {
+		return errors.Errorf("unexpected command %q", name)
+	}
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, result)
+}
+
+func (s *runnerSuite) TestReadIsMaster(c *gc.C) {
+	r := fakeRunner{"isMaster": {
+		"ismaster": true,
+		"me":       "::1:37017",
+		"primary":  "::1:37017",
+	}}
+	results, err := ReadIsMaster(r)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(results.IsMaster, jc.IsTrue)
+	c.Check(results.Address, gc.Equals, "[::1]:37017")
+}
+
+func (s *runnerSuite) TestReadStatus(c *gc.C) {
+	r := fakeRunner{
+		"replSetGetStatus": {
+			"set": rsName,
+			"members": []bson.M{
+				{"_id": 2, "name": "::1:37018", "health": 1, "state": 2},
+				{"_id": 1, "name": "1.2.3.4:37017", "health": 1, "state": 1, "self": true},
+			},
+		},
+	}
+	status, err := ReadStatus(r)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(status.Members, gc.HasLen, 2)
+	c.Check(status.Members[0].Address, gc.Equals, "1.2.3.4:37017")
+	c.Check(status.Members[1].Address, gc.Equals, "[::1]:37018")
+}
+
+func (s *runnerSuite) TestReadConfig(c *gc.C) {
+	r := fakeRunner{"replSetGetConfig": {
+		"config": bson.M{
+			"_id":     rsName,
+			"version": 3,
+			"members": []bson.M{
+				{"_id": 2, "host": "::1:37018"},
+				{"_id": 1, "host": "1.2.3.4:37017"},
+			},
+		},
+	}}
+	config, err := ReadConfig(r)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(config.Name, gc.Equals, rsName)
+	c.Check(config.Version, gc.Equals, 3)
+	c.Assert(config.Members, gc.HasLen, 2)
+	c.Check(config.Members[0].Address, gc.Equals, "1.2.3.4:37017")
+	c.Check(config.Members[1].Address, gc.Equals, "[::1]:37018")
+}
+
+func (s *runnerSuite) TestReadConfigError(c *gc.C) {
+	_, err := ReadConfig(fakeRunner{})
+	c.Check(err, gc.ErrorMatches, `cannot get replset config: unexpected command "replSetGetConfig"`)
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"sync"
+
+	"gopkg.in/mgo.v2"
+)
+
+// ReplicaSet gives access to a replica set through a session, serializing
+// the operations that change its config so that goroutines sharing it do
+// not race each other to the next config version.
+//
+// The serialization only coordinates callers within one process that use
+// the same ReplicaSet. Reconfigs made by other processes, or through the
+// package functions directly, can still conflict; ReconfigureAtVersion
+// can be used to detect such conflicts.
+type ReplicaSet struct {
+	session *mgo.Session
+
+	// mu is held for the duration of each reconfig.
+	mu sync.Mutex
+}
+
+// NewReplicaSet returns a ReplicaSet that uses the given session.
+func NewReplicaSet(session *mgo.Session) *ReplicaSet {
+	return &ReplicaSet{session: session}
+}
+
+// Session returns the session used by the replica set.
+func (rs *ReplicaSet) Session() *mgo.Session {
+	return rs.session
+}
+
+// Do calls f with the session of the replica set while holding its lock,
+// so that f does not run concurrently with any other reconfig made
+// through the ReplicaSet. It can be used to serialize operations that
+// have no ReplicaSet method, such as SetElectionTimeout.
+func (rs *ReplicaSet) Do(f func(session *mgo.Session) error) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return f(rs.session)
+}
+
+// Add is like the Add function, serialized with the other reconfigs made
+// through the ReplicaSet.
+func (rs *ReplicaSet) Add(members ...Member) error {
+	return rs.Do(func(session *mgo.Session) error {
+		return Add(session, members...)
+	})
+}
+
+// Remove is like the Remove function, serialized with the other reconfigs
+// made through the ReplicaSet.
+func (rs *ReplicaSet) Remove(addrs ...string) error {
+	return rs.Do(func(session *mgo.Session) error {
+		return Remove(session, addrs...)
+	})
+}
+
+// Set is like the Set function, serialized with the other reconfigs made
+// through the ReplicaSet.
+func (rs *ReplicaSet) Set(members []Member) error {
+	return rs.Do(func(session *mgo.Session) error {
+		return Set(session, members)
+	})
+}
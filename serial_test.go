@@ -0,0 +1,75 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *commandSuite) TestReplicaSetSerializesReconfigs(c *gc.C) {
+	// The mocked server rejects reconfigs that are not to the next
+	// version, as mongo does, and is slow to apply them, so that
+	// unserialized reconfigs would overlap and conflict.
+	var mu sync.Mutex
+	current := Config{Name: rsName, Version: 1, Members: []Member{{Id: 1, Address: "1.2.3.4:37017"}}}
+	inFlight, maxInFlight := 0, 0
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		config := current
+		config.Members = append([]Member(nil), current.Members...)
+		return &config, nil
+	})
+	s.PatchValue(&runCommand, func(session Runner, cmd interface{}, result interface{}) error {
+		doc, ok := cmd.(bson.D)
+		if !ok || doc[0].Name != "replSetReconfig" {
+			return nil
+		}
+		config := doc[0].Value.(*Config)
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		inFlight--
+		if config.Version != current.Version+1 {
+			return &mgo.QueryError{Code: 103, Message: "version conflict"}
+		}
+		current = *config
+		return nil
+	})
+
+	rs := NewReplicaSet(nil)
+	const n = 5
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- rs.Add(Member{Address: fmt.Sprintf("1.2.3.%d:37017", 10+i)})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Check(err, jc.ErrorIsNil)
+	}
+	c.Check(maxInFlight, gc.Equals, 1)
+	c.Check(current.Version, gc.Equals, n+1)
+	c.Check(current.Members, gc.HasLen, n+1)
+}
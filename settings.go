@@ -0,0 +1,267 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ReplicaSetSettings holds the settings document of a replica set config.
+//
+// See https://docs.mongodb.com/manual/reference/replica-configuration/#settings
+// for more details.
+type ReplicaSetSettings struct {
+	// ChainingAllowed holds whether secondaries may replicate from other
+	// secondaries rather than only from the primary.
+	// This value is optional; it defaults to true.
+	ChainingAllowed *bool `bson:"chainingAllowed,omitempty"`
+
+	// HeartbeatIntervalMillis holds the interval, in milliseconds, between
+	// the heartbeats that the members send each other. It is set by the
+	// server and cannot be changed.
+	HeartbeatIntervalMillis *int `bson:"heartbeatIntervalMillis,omitempty"`
+
+	// ElectionTimeoutMillis holds the time limit, in milliseconds, for
+	// detecting when a replica set's primary is unreachable.
+	// This value is optional; it defaults to 10000.
+	ElectionTimeoutMillis *int `bson:"electionTimeoutMillis,omitempty"`
+
+	// HeartbeatTimeoutSecs holds the number of seconds that the members
+	// wait for a heartbeat from each other before considering it
+	// unreachable.
+	// This value is optional; it defaults to 10.
+	HeartbeatTimeoutSecs *int `bson:"heartbeatTimeoutSecs,omitempty"`
+
+	// CatchUpTakeoverDelayMillis holds the time, in milliseconds, that a
+	// member that is more up to date than a newly elected primary waits
+	// before taking over from it. -1 disables catchup takeover.
+	// This value is optional; it defaults to 30000.
+	CatchUpTakeoverDelayMillis *int `bson:"catchUpTakeoverDelayMillis,omitempty"`
+
+	// CatchUpTimeoutMillis holds the time, in milliseconds, that a newly
+	// elected primary spends catching up with the writes of the other
+	// members before accepting writes. -1 means no limit.
+	// This value is optional; it defaults to -1.
+	CatchUpTimeoutMillis *int `bson:"catchUpTimeoutMillis,omitempty"`
+
+	// GetLastErrorDefaults holds the write concern used for writes that
+	// do not specify one, such as {"w": 1, "wtimeout": 0}. MongoDB 5.0
+	// and later ignore it.
+	GetLastErrorDefaults bson.M `bson:"getLastErrorDefaults,omitempty"`
+
+	// CustomWriteConcerns holds the custom write concerns that may be used
+	// as the "w" value of a write concern, keyed by name. Each one maps
+	// member tag names to the number of distinct values of that tag that
+	// the members acknowledging a write must have.
+	CustomWriteConcerns map[string]map[string]int `bson:"getLastErrorModes,omitempty"`
+
+	// ReplicaSetId holds the id that the server generated for the replica
+	// set when it was initiated. It cannot be changed.
+	ReplicaSetId bson.ObjectId `bson:"replicaSetId,omitempty"`
+
+	// Extra holds the settings that are not modelled by the fields above,
+	// so that they are preserved when the config is written back.
+	Extra bson.M `bson:",inline"`
+}
+
+// MinElectionTimeout is the smallest election timeout accepted by
+// SetElectionTimeout. Lower values risk elections being called whenever
+// the primary is briefly slow to respond.
+const MinElectionTimeout = time.Second
+
+// SetElectionTimeout sets the electionTimeoutMillis setting of the
+// session's replica set, leaving all other settings unchanged. It returns
+// an error if the timeout is lower than MinElectionTimeout.
+func SetElectionTimeout(session *mgo.Session, d time.Duration) error {
+	if d < MinElectionTimeout {
+		return errors.NotValidf("election timeout %v (minimum %v)", d, MinElectionTimeout)
+	}
+	millis := int(d / time.Millisecond)
+	return updateSettings("SetElectionTimeout", session, func(settings *ReplicaSetSettings) {
+		settings.ElectionTimeoutMillis = &millis
+	})
+}
+
+// MinHeartbeatTimeout is the smallest heartbeat timeout accepted by
+// SetHeartbeatTimeout.
+const MinHeartbeatTimeout = time.Second
+
+// SetHeartbeatTimeout sets the heartbeatTimeoutSecs setting of the
+// session's replica set, rounded up to the nearest second, leaving all
+// other settings unchanged. It returns an error if the timeout is lower
+// than MinHeartbeatTimeout. Raising it avoids spurious failovers on high
+// latency links, at the cost of slower detection of real failures.
+func SetHeartbeatTimeout(session *mgo.Session, d time.Duration) error {
+	if d < MinHeartbeatTimeout {
+		return errors.NotValidf("heartbeat timeout %v (minimum %v)", d, MinHeartbeatTimeout)
+	}
+	secs := int((d + time.Second - 1) / time.Second)
+	return updateSettings("SetHeartbeatTimeout", session, func(settings *ReplicaSetSettings) {
+		settings.HeartbeatTimeoutSecs = &secs
+	})
+}
+
+// DisableCatchUpTakeover may be passed to SetCatchUpTakeoverDelay to
+// disable catchup takeover.
+const DisableCatchUpTakeover time.Duration = -1
+
+// SetCatchUpTakeoverDelay sets the catchUpTakeoverDelayMillis setting of
+// the session's replica set, leaving all other settings unchanged. It
+// returns an error if the delay is negative, unless it is
+// DisableCatchUpTakeover.
+//
+// When a newly elected primary is still catching up with the latest
+// writes, a member that already has them takes over once this delay has
+// passed. This is separate from priority takeover: after the priority of
+// a member is raised, for instance with ApplyPriorityPolicy, it calls an
+// election as soon as it has caught up with the primary, regardless of
+// this setting. A shorter delay makes a failover to the most up to date
+// member quicker, at the cost of an extra election.
+func SetCatchUpTakeoverDelay(session *mgo.Session, d time.Duration) error {
+	millis := -1
+	if d != DisableCatchUpTakeover {
+		if d < 0 {
+			return errors.NotValidf("negative catchup takeover delay %v", d)
+		}
+		millis = int(d / time.Millisecond)
+	}
+	return updateSettings("SetCatchUpTakeoverDelay", session, func(settings *ReplicaSetSettings) {
+		settings.CatchUpTakeoverDelayMillis = &millis
+	})
+}
+
+// SetCustomWriteConcern defines a custom write concern with the given name
+// in the settings of the session's replica set, replacing any existing one
+// with the same name. The tag requirements map member tag names to the
+// number of distinct values of the tag that acknowledging members must
+// have; for example {"region": 2} requires writes to reach members in two
+// regions. All other settings are left unchanged.
+func SetCustomWriteConcern(session *mgo.Session, name string, tagRequirements map[string]int) error {
+	if name == "" {
+		return errors.NotValidf("empty write concern name")
+	}
+	if len(tagRequirements) == 0 {
+		return errors.NotValidf("write concern %q without tag requirements", name)
+	}
+	return updateSettings("SetCustomWriteConcern", session, func(settings *ReplicaSetSettings) {
+		concerns := make(map[string]map[string]int, len(settings.CustomWriteConcerns)+1)
+		for concernName, requirements := range settings.CustomWriteConcerns {
+			concerns[concernName] = requirements
+		}
+		requirements := make(map[string]int, len(tagRequirements))
+		for tag, count := range tagRequirements {
+			requirements[tag] = count
+		}
+		concerns[name] = requirements
+		settings.CustomWriteConcerns = concerns
+	})
+}
+
+// SetWriteConcernMajorityJournalDefault sets the
+// writeConcernMajorityJournalDefault field of the config of the session's
+// replica set, leaving everything else unchanged. When it is false, writes
+// with a majority write concern are acknowledged once they are in memory
+// on a majority of the members, which is required when members run
+// without journaling.
+func SetWriteConcernMajorityJournalDefault(session *mgo.Session, journal bool) error {
+	config, err := CurrentConfig(session)
+	if err != nil {
+		return err
+	}
+	oldconfig := *config
+	config.Version++
+	config.WriteConcernMajorityJournalDefault = &journal
+	return applyReplSetConfig("SetWriteConcernMajorityJournalDefault", session, &oldconfig, config)
+}
+
+// GetSettings returns the settings of the session's replica set. If the
+// config has no settings document, empty settings are returned.
+func GetSettings(session *mgo.Session) (*ReplicaSetSettings, error) {
+	config, err := CurrentConfig(session)
+	if err != nil {
+		return nil, err
+	}
+	settings := copySettings(config.Settings)
+	return &settings, nil
+}
+
+// UpdateSettings reconfigures the session's replica set, changing only its
+// settings: update is called with a copy of the current settings, which
+// it may change, and the result replaces the settings document. The
+// members and the rest of the config are left unchanged.
+//
+// The settings that the server does not allow to change, replicaSetId
+// and heartbeatIntervalMillis, must be left as they are; if update changes
+// them, an error satisfying errors.IsNotValid is returned and the replica
+// set is not reconfigured.
+func UpdateSettings(session *mgo.Session, update func(*ReplicaSetSettings)) error {
+	return updateSettings("UpdateSettings", session, update)
+}
+
+// updateSettings reconfigures the session's replica set, changing only its
+// settings by calling update with a copy of the current settings.
+func updateSettings(cmd string, session *mgo.Session, update func(*ReplicaSetSettings)) error {
+	config, err := CurrentConfig(session)
+	if err != nil {
+		return err
+	}
+	oldconfig := *config
+	config.Version++
+	settings := copySettings(config.Settings)
+	update(&settings)
+	if err := checkFixedSettings(copySettings(oldconfig.Settings), settings); err != nil {
+		return err
+	}
+	config.Settings = &settings
+	return applyReplSetConfig(cmd, session, &oldconfig, config)
+}
+
+// copySettings returns a copy of the given settings that can be changed
+// without changing them, or empty settings if they are nil.
+func copySettings(current *ReplicaSetSettings) ReplicaSetSettings {
+	if current == nil {
+		return ReplicaSetSettings{}
+	}
+	settings := *current
+	settings.Extra = copyDoc(current.Extra)
+	settings.GetLastErrorDefaults = copyDoc(current.GetLastErrorDefaults)
+	return settings
+}
+
+// copyDoc returns a shallow copy of the given document.
+func copyDoc(doc bson.M) bson.M {
+	if doc == nil {
+		return nil
+	}
+	copied := make(bson.M, len(doc))
+	for key, value := range doc {
+		copied[key] = value
+	}
+	return copied
+}
+
+// checkFixedSettings checks that the settings that the server does not
+// allow to change are the same in the old and new settings.
+func checkFixedSettings(old, updated ReplicaSetSettings) error {
+	if updated.ReplicaSetId != old.ReplicaSetId {
+		return errors.NotValidf("changing replicaSetId")
+	}
+	if !equalIntPtrs(updated.HeartbeatIntervalMillis, old.HeartbeatIntervalMillis) {
+		return errors.NotValidf("changing heartbeatIntervalMillis")
+	}
+	return nil
+}
+
+// equalIntPtrs reports whether a and b are both nil or point to the same
+// value.
+func equalIntPtrs(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
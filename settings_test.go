@@ -0,0 +1,328 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *MongoSuite) TestSetElectionTimeout(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	cfg, err := CurrentConfig(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cfg.Settings, gc.NotNil)
+	chainingAllowed := cfg.Settings.ChainingAllowed
+
+	err = SetElectionTimeout(session, 5*time.Second)
+	c.Assert(err, jc.ErrorIsNil)
+
+	cfg, err = CurrentConfig(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(cfg.Version, gc.Equals, 2)
+	c.Assert(cfg.Settings.ElectionTimeoutMillis, gc.NotNil)
+	c.Check(*cfg.Settings.ElectionTimeoutMillis, gc.Equals, 5000)
+	c.Check(cfg.Settings.ChainingAllowed, jc.DeepEquals, chainingAllowed)
+}
+
+func (s *MongoSuite) TestSetElectionTimeoutTooLow(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	err := SetElectionTimeout(session, 100*time.Millisecond)
+	c.Assert(err, gc.ErrorMatches, `election timeout 100ms \(minimum 1s\) not valid`)
+}
+
+func (s *MongoSuite) TestSetHeartbeatTimeout(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	err := SetHeartbeatTimeout(session, 20*time.Second)
+	c.Assert(err, jc.ErrorIsNil)
+
+	cfg, err := CurrentConfig(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(cfg.Version, gc.Equals, 2)
+	c.Assert(cfg.Settings.HeartbeatTimeoutSecs, gc.NotNil)
+	c.Check(*cfg.Settings.HeartbeatTimeoutSecs, gc.Equals, 20)
+}
+
+func (s *MongoSuite) TestSetHeartbeatTimeoutTooLow(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	err := SetHeartbeatTimeout(session, 500*time.Millisecond)
+	c.Assert(err, gc.ErrorMatches, `heartbeat timeout 500ms \(minimum 1s\) not valid`)
+}
+
+func (s *MongoSuite) TestSetCatchUpTakeoverDelay(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	err := SetElectionTimeout(session, 5*time.Second)
+	c.Assert(err, jc.ErrorIsNil)
+
+	for i, test := range []struct {
+		delay    time.Duration
+		expected int
+	}{
+		{5 * time.Second, 5000},
+		{DisableCatchUpTakeover, -1},
+	} {
+		c.Logf("test %d: %v", i, test.delay)
+		err = SetCatchUpTakeoverDelay(session, test.delay)
+		c.Assert(err, jc.ErrorIsNil)
+
+		cfg, err := CurrentConfig(session)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(cfg.Settings.CatchUpTakeoverDelayMillis, gc.NotNil)
+		c.Check(*cfg.Settings.CatchUpTakeoverDelayMillis, gc.Equals, test.expected)
+		// Other settings are preserved.
+		c.Assert(cfg.Settings.ElectionTimeoutMillis, gc.NotNil)
+		c.Check(*cfg.Settings.ElectionTimeoutMillis, gc.Equals, 5000)
+	}
+}
+
+func (s *MongoSuite) TestSetCustomWriteConcern(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	err := SetCustomWriteConcern(session, "twoRegions", map[string]int{"foo": 1})
+	c.Assert(err, jc.ErrorIsNil)
+
+	cfg, err := CurrentConfig(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(cfg.Settings.CustomWriteConcerns, jc.DeepEquals, map[string]map[string]int{
+		"twoRegions": {"foo": 1},
+	})
+}
+
+type settingsSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&settingsSuite{})
+
+func (s *MongoSuite) TestSetWriteConcernMajorityJournalDefault(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	err := SetWriteConcernMajorityJournalDefault(session, false)
+	c.Assert(err, jc.ErrorIsNil)
+
+	cfg, err := CurrentConfig(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cfg.WriteConcernMajorityJournalDefault, gc.NotNil)
+	c.Check(*cfg.WriteConcernMajorityJournalDefault, jc.IsFalse)
+
+	// A reconfig that does not touch it leaves it unchanged.
+	err = Set(session, cfg.Members)
+	c.Assert(err, jc.ErrorIsNil)
+	cfg, err = CurrentConfig(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cfg.WriteConcernMajorityJournalDefault, gc.NotNil)
+	c.Check(*cfg.WriteConcernMajorityJournalDefault, jc.IsFalse)
+
+	err = SetWriteConcernMajorityJournalDefault(session, true)
+	c.Assert(err, jc.ErrorIsNil)
+	cfg, err = CurrentConfig(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cfg.WriteConcernMajorityJournalDefault, gc.NotNil)
+	c.Check(*cfg.WriteConcernMajorityJournalDefault, jc.IsTrue)
+}
+
+func (s *settingsSuite) TestCustomWriteConcernsRoundTrip(c *gc.C) {
+	data, err := bson.Marshal(bson.M{
+		"getLastErrorModes": bson.M{"multiRegion": bson.M{"region": 2}},
+		"futureSetting":     true,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	var settings ReplicaSetSettings
+	err = bson.Unmarshal(data, &settings)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(settings.CustomWriteConcerns, jc.DeepEquals, map[string]map[string]int{
+		"multiRegion": {"region": 2},
+	})
+	c.Check(settings.Extra, jc.DeepEquals, bson.M{"futureSetting": true})
+
+	data, err = bson.Marshal(settings)
+	c.Assert(err, jc.ErrorIsNil)
+	var doc bson.M
+	err = bson.Unmarshal(data, &doc)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(doc, jc.DeepEquals, bson.M{
+		"getLastErrorModes": bson.M{"multiRegion": bson.M{"region": 2}},
+		"futureSetting":     true,
+	})
+}
+
+func (s *settingsSuite) TestSetCatchUpTakeoverDelayInvalid(c *gc.C) {
+	err := SetCatchUpTakeoverDelay(nil, -time.Second)
+	c.Check(err, gc.ErrorMatches, "negative catchup takeover delay -1s not valid")
+}
+
+func (s *settingsSuite) TestSetCustomWriteConcernInvalid(c *gc.C) {
+	err := SetCustomWriteConcern(nil, "", map[string]int{"region": 2})
+	c.Check(err, gc.ErrorMatches, "empty write concern name not valid")
+	err = SetCustomWriteConcern(nil, "multiRegion", nil)
+	c.Check(err, gc.ErrorMatches, `write concern "multiRegion" without tag requirements not valid`)
+}
+
+func (s *settingsSuite) TestWriteConcernMajorityJournalDefaultRoundTrip(c *gc.C) {
+	no := false
+	config := Config{
+		Name:                               "juju",
+		Version:                            1,
+		Members:                            []Member{{Id: 1, Address: "1.2.3.4:37017"}},
+		WriteConcernMajorityJournalDefault: &no,
+	}
+	data, err := bson.Marshal(config)
+	c.Assert(err, jc.ErrorIsNil)
+	var doc bson.M
+	c.Assert(bson.Unmarshal(data, &doc), jc.ErrorIsNil)
+	c.Check(doc["writeConcernMajorityJournalDefault"], gc.Equals, false)
+
+	var parsed Config
+	c.Assert(bson.Unmarshal(data, &parsed), jc.ErrorIsNil)
+	c.Assert(parsed.WriteConcernMajorityJournalDefault, gc.NotNil)
+	c.Check(*parsed.WriteConcernMajorityJournalDefault, jc.IsFalse)
+
+	// Configs that do not set it do not send it.
+	config.WriteConcernMajorityJournalDefault = nil
+	data, err = bson.Marshal(config)
+	c.Assert(err, jc.ErrorIsNil)
+	doc = nil
+	c.Assert(bson.Unmarshal(data, &doc), jc.ErrorIsNil)
+	_, ok := doc["writeConcernMajorityJournalDefault"]
+	c.Check(ok, jc.IsFalse)
+}
+
+func (s *MongoSuite) TestUpdateSettings(c *gc.C) {
+	session := s.root.MustDial()
+	defer session.Close()
+
+	settings, err := GetSettings(session)
+	c.Assert(err, jc.ErrorIsNil)
+	replicaSetId := settings.ReplicaSetId
+
+	err = UpdateSettings(session, func(settings *ReplicaSetSettings) {
+		chaining := false
+		settings.ChainingAllowed = &chaining
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	settings, err = GetSettings(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(settings.ChainingAllowed, gc.NotNil)
+	c.Check(*settings.ChainingAllowed, jc.IsFalse)
+	c.Check(settings.ReplicaSetId, gc.Equals, replicaSetId)
+
+	members, err := CurrentMembers(session)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(members, gc.HasLen, 1)
+}
+
+func (s *settingsSuite) TestSettingsRoundTrip(c *gc.C) {
+	id := bson.ObjectIdHex("5f1e8c3a9d3b2a0001a2b3c4")
+	data, err := bson.Marshal(bson.M{
+		"chainingAllowed":         false,
+		"heartbeatIntervalMillis": 2000,
+		"heartbeatTimeoutSecs":    10,
+		"electionTimeoutMillis":   10000,
+		"catchUpTimeoutMillis":    -1,
+		"getLastErrorDefaults":    bson.M{"w": 1, "wtimeout": 0},
+		"replicaSetId":            id,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	var settings ReplicaSetSettings
+	err = bson.Unmarshal(data, &settings)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(settings.ChainingAllowed, gc.NotNil)
+	c.Check(*settings.ChainingAllowed, jc.IsFalse)
+	c.Assert(settings.HeartbeatIntervalMillis, gc.NotNil)
+	c.Check(*settings.HeartbeatIntervalMillis, gc.Equals, 2000)
+	c.Assert(settings.CatchUpTimeoutMillis, gc.NotNil)
+	c.Check(*settings.CatchUpTimeoutMillis, gc.Equals, -1)
+	c.Check(settings.GetLastErrorDefaults, jc.DeepEquals, bson.M{"w": 1, "wtimeout": 0})
+	c.Check(settings.ReplicaSetId, gc.Equals, id)
+	c.Check(settings.Extra, gc.HasLen, 0)
+
+	data, err = bson.Marshal(settings)
+	c.Assert(err, jc.ErrorIsNil)
+	var doc bson.M
+	c.Assert(bson.Unmarshal(data, &doc), jc.ErrorIsNil)
+	c.Check(doc["chainingAllowed"], gc.Equals, false)
+	c.Check(doc["replicaSetId"], gc.Equals, id)
+}
+
+func (s *settingsSuite) TestUpdateSettingsFixed(c *gc.C) {
+	interval := 2000
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{
+			Name:    "juju",
+			Version: 1,
+			Members: []Member{{Id: 1, Address: "1.2.3.4:37017"}},
+			Settings: &ReplicaSetSettings{
+				HeartbeatIntervalMillis: &interval,
+				ReplicaSetId:            bson.ObjectIdHex("5f1e8c3a9d3b2a0001a2b3c4"),
+			},
+		}, nil
+	})
+	s.PatchValue(&runCommand, func(session Runner, cmd interface{}, result interface{}) error {
+		c.Fatalf("unexpected command %v", cmd)
+		return nil
+	})
+	err := UpdateSettings(nil, func(settings *ReplicaSetSettings) {
+		settings.ReplicaSetId = bson.NewObjectId()
+	})
+	c.Check(err, gc.ErrorMatches, "changing replicaSetId not valid")
+
+	err = UpdateSettings(nil, func(settings *ReplicaSetSettings) {
+		faster := 1000
+		settings.HeartbeatIntervalMillis = &faster
+	})
+	c.Check(err, gc.ErrorMatches, "changing heartbeatIntervalMillis not valid")
+}
+
+func (s *settingsSuite) TestSetCustomWriteConcernAnyVersion(c *gc.C) {
+	s.PatchValue(&CurrentConfig, func(session *mgo.Session) (*Config, error) {
+		return &Config{
+			Name:    "juju",
+			Version: 1,
+			Members: []Member{{Id: 1, Address: "1.2.3.4:37017"}},
+		}, nil
+	})
+	// Every server version names the setting getLastErrorModes.
+	for i, version := range [][]int{{4, 4, 1, 0}, {5, 0, 0, 0}, {7, 0, 2, 0}} {
+		c.Logf("test %d: %v", i, version)
+		s.PatchValue(&getBuildInfo, func(session *mgo.Session) (mgo.BuildInfo, error) {
+			return mgo.BuildInfo{VersionArray: version}, nil
+		})
+		var sent bson.M
+		s.PatchValue(&runCommand, func(session Runner, cmd interface{}, result interface{}) error {
+			data, err := bson.Marshal(cmd.(bson.D)[0].Value)
+			c.Assert(err, jc.ErrorIsNil)
+			var doc struct {
+				Settings bson.M `bson:"settings"`
+			}
+			c.Assert(bson.Unmarshal(data, &doc), jc.ErrorIsNil)
+			sent = doc.Settings
+			return nil
+		})
+		err := SetCustomWriteConcern(nil, "multiRegion", map[string]int{"region": 2})
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(sent, jc.DeepEquals, bson.M{
+			"getLastErrorModes": bson.M{"multiRegion": bson.M{"region": 2}},
+		})
+	}
+}
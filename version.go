@@ -0,0 +1,220 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+	"gopkg.in/mgo.v2"
+)
+
+// getBuildInfo is used to get the build information of the server, so
+// that version detection can be tested.
+var getBuildInfo = func(session *mgo.Session) (mgo.BuildInfo, error) {
+	return session.BuildInfo()
+}
+
+// Feature identifies a behaviour of the replica set commands that depends
+// on the version of the server.
+type Feature string
+
+const (
+	// FeatureHello is the hello command, which replaces isMaster.
+	FeatureHello Feature = "hello"
+
+	// FeatureSecondaryDelaySecs is the secondaryDelaySecs member field,
+	// which replaces slaveDelay.
+	FeatureSecondaryDelaySecs Feature = "secondaryDelaySecs"
+
+	// FeatureCommitmentStatus is the commitmentStatus option of
+	// replSetGetConfig.
+	FeatureCommitmentStatus Feature = "commitmentStatus"
+
+	// FeatureConfigTerm is the term of the replica set config.
+	FeatureConfigTerm Feature = "configTerm"
+
+	// FeatureWriteMajorityCount is the writeMajorityCount field of
+	// replSetGetStatus.
+	FeatureWriteMajorityCount Feature = "writeMajorityCount"
+
+	// FeatureHorizons is the horizons member field, used for split
+	// horizon DNS.
+	FeatureHorizons Feature = "horizons"
+
+	// FeatureCatchUpTakeover is the catchUpTakeoverDelayMillis setting.
+	FeatureCatchUpTakeover Feature = "catchUpTakeover"
+
+	// FeatureWriteConcernMajorityJournalDefault is the
+	// writeConcernMajorityJournalDefault config field.
+	FeatureWriteConcernMajorityJournalDefault Feature = "writeConcernMajorityJournalDefault"
+)
+
+// featureVersions holds the first server version that supports each
+// feature.
+var featureVersions = map[Feature][3]int{
+	FeatureHello:              {4, 4, 2},
+	FeatureSecondaryDelaySecs: {5, 0, 0},
+	FeatureCommitmentStatus:   {4, 4, 0},
+	FeatureConfigTerm:         {4, 4, 0},
+	FeatureWriteMajorityCount: {4, 2, 1},
+	FeatureHorizons:           {4, 2, 0},
+	FeatureCatchUpTakeover:    {4, 0, 0},
+
+	FeatureWriteConcernMajorityJournalDefault: {3, 4, 0},
+}
+
+// SupportsFeature reports whether the server that the given session is
+// connected to supports the given feature. It returns an error satisfying
+// errors.IsNotValid for an unknown feature.
+func SupportsFeature(session *mgo.Session, feature Feature) (bool, error) {
+	minimum, ok := featureVersions[feature]
+	if !ok {
+		return false, errors.NotValidf("feature %q", feature)
+	}
+	major, minor, patch, err := serverVersion(session)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return versionAtLeast([3]int{major, minor, patch}, minimum), nil
+}
+
+// featureSupported reports whether the server with the given build info
+// supports the given feature, which must be in featureVersions. A server
+// whose version cannot be parsed is assumed not to support it.
+func featureSupported(buildInfo mgo.BuildInfo, feature Feature) bool {
+	version, err := parseVersion(buildInfo)
+	if err != nil {
+		return false
+	}
+	return versionAtLeast(version, featureVersions[feature])
+}
+
+// serverVersion returns the version of the server that the given session
+// is connected to. It uses the versionArray reported by buildInfo,
+// falling back to parsing the version string if the array is missing.
+func serverVersion(session *mgo.Session) (major, minor, patch int, err error) {
+	buildInfo, err := getBuildInfo(session)
+	if err != nil {
+		return 0, 0, 0, errors.Annotate(err, "buildInfo")
+	}
+	version, err := parseVersion(buildInfo)
+	if err != nil {
+		return 0, 0, 0, errors.Trace(err)
+	}
+	return version[0], version[1], version[2], nil
+}
+
+// parseVersion returns the major, minor and patch version held in the
+// given build info.
+func parseVersion(buildInfo mgo.BuildInfo) ([3]int, error) {
+	var version [3]int
+	if len(buildInfo.VersionArray) > 0 {
+		copy(version[:], buildInfo.VersionArray)
+		return version, nil
+	}
+	// Ignore any suffix, as in "4.4.0-rc1".
+	number := buildInfo.Version
+	if i := strings.IndexAny(number, "-+ "); i >= 0 {
+		number = number[:i]
+	}
+	parts := strings.Split(number, ".")
+	if len(parts) > 3 {
+		parts = parts[:3]
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return version, errors.NotValidf("server version %q", buildInfo.Version)
+		}
+		version[i] = n
+	}
+	return version, nil
+}
+
+// versionAtLeast reports whether version is at least minimum.
+func versionAtLeast(version, minimum [3]int) bool {
+	for i := range version {
+		if version[i] != minimum[i] {
+			return version[i] > minimum[i]
+		}
+	}
+	return true
+}
+
+// ValidateConfigForServer checks that the server that the given session is
+// connected to supports every feature used by the given config, so that a
+// config it would reject can be caught before it is applied. It returns an
+// error satisfying errors.IsNotSupported naming the first unsupported
+// feature, such as "horizons require MongoDB 4.2+, server is 4.0.3".
+//
+// The member delay field is not checked, as it is renamed as the server
+// requires when the config is applied.
+func ValidateConfigForServer(session *mgo.Session, cfg Config) error {
+	buildInfo, err := getBuildInfo(session)
+	if err != nil {
+		return errors.Annotate(err, "buildInfo")
+	}
+	version, err := parseVersion(buildInfo)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return validateConfigForVersion(&cfg, version)
+}
+
+// configFeatures holds the features that a config may use, with a
+// description used in errors and a function reporting whether a config
+// uses the feature.
+var configFeatures = []struct {
+	feature     Feature
+	description string
+	used        func(cfg *Config) bool
+}{{
+	feature:     FeatureHorizons,
+	description: "horizons require",
+	used: func(cfg *Config) bool {
+		for _, member := range cfg.Members {
+			if _, ok := member.Extra["horizons"]; ok {
+				return true
+			}
+		}
+		return false
+	},
+}, {
+	feature:     FeatureCatchUpTakeover,
+	description: "catchUpTakeoverDelayMillis requires",
+	used: func(cfg *Config) bool {
+		return cfg.Settings != nil && cfg.Settings.CatchUpTakeoverDelayMillis != nil
+	},
+}, {
+	feature:     FeatureWriteConcernMajorityJournalDefault,
+	description: "writeConcernMajorityJournalDefault requires",
+	used: func(cfg *Config) bool {
+		return cfg.WriteConcernMajorityJournalDefault != nil
+	},
+}}
+
+// validateConfigForVersion implements ValidateConfigForServer for a server
+// with the given version.
+func validateConfigForVersion(cfg *Config, version [3]int) error {
+	for _, f := range configFeatures {
+		minimum := featureVersions[f.feature]
+		if f.used(cfg) && !versionAtLeast(version, minimum) {
+			return errors.NewNotSupported(nil, fmt.Sprintf("%s MongoDB %s+, server is %s",
+				f.description, formatVersion(minimum[:2]), formatVersion(version[:])))
+		}
+	}
+	return nil
+}
+
+// formatVersion returns the given version numbers joined by dots.
+func formatVersion(version []int) string {
+	parts := make([]string, len(version))
+	for i, n := range version {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,143 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type versionSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&versionSuite{})
+
+func (s *versionSuite) patchBuildInfo(buildInfo mgo.BuildInfo) {
+	s.PatchValue(&getBuildInfo, func(session *mgo.Session) (mgo.BuildInfo, error) {
+		return buildInfo, nil
+	})
+}
+
+func (s *versionSuite) TestServerVersion(c *gc.C) {
+	for i, test := range []struct {
+		buildInfo mgo.BuildInfo
+		expected  [3]int
+	}{
+		{mgo.BuildInfo{Version: "3.2.22", VersionArray: []int{3, 2, 22, 0}}, [3]int{3, 2, 22}},
+		{mgo.BuildInfo{Version: "4.4.1", VersionArray: []int{4, 4, 1, 0}}, [3]int{4, 4, 1}},
+		{mgo.BuildInfo{Version: "5.0.0", VersionArray: []int{5, 0, 0, 0}}, [3]int{5, 0, 0}},
+		{mgo.BuildInfo{Version: "7.0.2", VersionArray: []int{7, 0, 2, 0}}, [3]int{7, 0, 2}},
+		{mgo.BuildInfo{Version: "7.0.2-rc1"}, [3]int{7, 0, 2}},
+		{mgo.BuildInfo{Version: "6.0"}, [3]int{6, 0, 0}},
+	} {
+		c.Logf("test %d: %s", i, test.buildInfo.Version)
+		s.patchBuildInfo(test.buildInfo)
+		major, minor, patch, err := serverVersion(nil)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check([3]int{major, minor, patch}, gc.Equals, test.expected)
+	}
+}
+
+func (s *versionSuite) TestServerVersionInvalid(c *gc.C) {
+	s.patchBuildInfo(mgo.BuildInfo{Version: "bogus"})
+	_, _, _, err := serverVersion(nil)
+	c.Check(err, gc.ErrorMatches, `server version "bogus" not valid`)
+}
+
+func (s *versionSuite) TestSupportsFeature(c *gc.C) {
+	for i, test := range []struct {
+		version  []int
+		feature  Feature
+		expected bool
+	}{
+		{[]int{3, 2, 22, 0}, FeatureHello, false},
+		{[]int{3, 2, 22, 0}, FeatureCommitmentStatus, false},
+		{[]int{4, 4, 0, 0}, FeatureCommitmentStatus, true},
+		{[]int{4, 4, 0, 0}, FeatureHello, false},
+		{[]int{4, 4, 2, 0}, FeatureHello, true},
+		{[]int{4, 4, 2, 0}, FeatureSecondaryDelaySecs, false},
+		{[]int{5, 0, 0, 0}, FeatureSecondaryDelaySecs, true},
+		{[]int{7, 0, 2, 0}, FeatureWriteMajorityCount, true},
+		{[]int{7, 0, 2, 0}, FeatureConfigTerm, true},
+	} {
+		c.Logf("test %d: %v %s", i, test.version, test.feature)
+		s.patchBuildInfo(mgo.BuildInfo{VersionArray: test.version})
+		supported, err := SupportsFeature(nil, test.feature)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(supported, gc.Equals, test.expected)
+	}
+}
+
+func (s *versionSuite) TestSupportsFeatureUnknown(c *gc.C) {
+	s.patchBuildInfo(mgo.BuildInfo{VersionArray: []int{7, 0, 2, 0}})
+	_, err := SupportsFeature(nil, "bogus")
+	c.Check(err, jc.Satisfies, errors.IsNotValid)
+}
+
+func (s *versionSuite) TestValidateConfigForServer(c *gc.C) {
+	no := false
+	delay := time.Duration(60)
+	takeover := 1000
+	horizons := Member{Id: 1, Address: "1.2.3.4:37017", Extra: bson.M{
+		"horizons": bson.M{"external": "db.example.com:37017"},
+	}}
+	for i, test := range []struct {
+		about   string
+		version string
+		config  Config
+		err     string
+	}{{
+		about:   "plain config on an old server",
+		version: "3.2.0",
+		config:  Config{Members: []Member{{Id: 1, Address: "1.2.3.4:37017"}}},
+	}, {
+		about:   "delay field is renamed as needed",
+		version: "4.0.0",
+		config:  Config{Members: []Member{{Id: 1, Address: "1.2.3.4:37017", SlaveDelay: &delay}}},
+	}, {
+		about:   "horizons on 4.0",
+		version: "4.0.3",
+		config:  Config{Members: []Member{horizons}},
+		err:     "horizons require MongoDB 4.2\\+, server is 4.0.3",
+	}, {
+		about:   "horizons on 4.2",
+		version: "4.2.0",
+		config:  Config{Members: []Member{horizons}},
+	}, {
+		about:   "catchup takeover on 3.6",
+		version: "3.6.8",
+		config: Config{
+			Members:  []Member{{Id: 1, Address: "1.2.3.4:37017"}},
+			Settings: &ReplicaSetSettings{CatchUpTakeoverDelayMillis: &takeover},
+		},
+		err: "catchUpTakeoverDelayMillis requires MongoDB 4.0\\+, server is 3.6.8",
+	}, {
+		about:   "journal default on 3.2",
+		version: "3.2.22",
+		config: Config{
+			Members:                            []Member{{Id: 1, Address: "1.2.3.4:37017"}},
+			WriteConcernMajorityJournalDefault: &no,
+		},
+		err: "writeConcernMajorityJournalDefault requires MongoDB 3.4\\+, server is 3.2.22",
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		s.PatchValue(&getBuildInfo, func(session *mgo.Session) (mgo.BuildInfo, error) {
+			return mgo.BuildInfo{Version: test.version}, nil
+		})
+		err := ValidateConfigForServer(nil, test.config)
+		if test.err == "" {
+			c.Check(err, jc.ErrorIsNil)
+			continue
+		}
+		c.Check(err, gc.ErrorMatches, test.err)
+		c.Check(err, jc.Satisfies, errors.IsNotSupported)
+	}
+}
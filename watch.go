@@ -0,0 +1,168 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// Watch polls the status of the session's replica set every interval and
+// sends it on the returned status channel whenever it changes in a
+// meaningful way: when a member is added or removed, or when a member's
+// address, state or health changes. The first status read is always sent.
+// Errors getting the status are sent on the returned error channel, and
+// polling continues afterwards. A partial status is sent as a status, with
+// the members that could not be parsed in UnknownState.
+//
+// Callers should receive from both channels until they are closed, which
+// happens once ctx is done. Polling waits for each status to be received,
+// but an error is dropped if the previous one has not been received yet,
+// so that a caller that only receives statuses still gets them.
+func Watch(ctx context.Context, session *mgo.Session, interval time.Duration) (<-chan *Status, <-chan error) {
+	statuses := make(chan *Status)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(statuses)
+		defer close(errs)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var last *Status
+		for {
+			status, err := getCurrentStatus(session)
+			if isConnectionNotAvailable(err) {
+				logger.Errorf("DB connection dropped so reconnecting")
+				session.Refresh()
+			}
+			if IsPartialStatus(err) {
+				err = nil
+			}
+			if err != nil {
+				sendError(errs, err)
+			} else if last == nil || statusChanged(last, status) {
+				select {
+				case statuses <- status:
+				case <-ctx.Done():
+					return
+				}
+				last = status
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return statuses, errs
+}
+
+// PrimaryChange describes a change of primary observed by WatchPrimary.
+type PrimaryChange struct {
+	// From holds the address of the previous primary.
+	From string
+
+	// To holds the address of the new primary.
+	To string
+
+	// At holds the time at which the change was observed.
+	At time.Time
+}
+
+// WatchPrimary polls the status of the session's replica set every interval,
+// as Watch does, and sends a PrimaryChange on the returned channel each
+// time a member other than the last known primary becomes primary. Periods
+// without a primary, such as during an election, are not reported, so a
+// failover from one member to another results in a single change. Errors
+// getting the status are sent on the returned error channel.
+//
+// As with Watch, callers should receive from both channels until they are
+// closed, which happens once ctx is done, and an error is dropped if the
+// previous one has not been received yet.
+func WatchPrimary(ctx context.Context, session *mgo.Session, interval time.Duration) (<-chan PrimaryChange, <-chan error) {
+	changes := make(chan PrimaryChange)
+	errs := make(chan error, 1)
+	statuses, statusErrs := Watch(ctx, session, interval)
+	go func() {
+		defer close(changes)
+		defer close(errs)
+
+		var last string
+		for {
+			select {
+			case status, ok := <-statuses:
+				if !ok {
+					return
+				}
+				primary := primaryAddress(status)
+				if primary == "" || primary == last {
+					continue
+				}
+				if last != "" {
+					change := PrimaryChange{From: last, To: primary, At: time.Now()}
+					select {
+					case changes <- change:
+					case <-ctx.Done():
+						return
+					}
+				}
+				last = primary
+			case err, ok := <-statusErrs:
+				if !ok {
+					return
+				}
+				sendError(errs, err)
+			}
+		}
+	}()
+	return changes, errs
+}
+
+// sendError sends err on errs, which must be buffered, dropping it if the
+// previous error has not been received yet.
+func sendError(errs chan<- error, err error) {
+	select {
+	case errs <- err:
+	default:
+		logger.Debugf("dropping watch error, the previous one has not been received: %v", err)
+	}
+}
+
+// primaryAddress returns the address of the primary in the given status,
+// or the empty string if there is no primary.
+func primaryAddress(status *Status) string {
+	for _, member := range status.Members {
+		if member.State == PrimaryState {
+			return member.Address
+		}
+	}
+	return ""
+}
+
+// statusChanged reports whether the members of the given statuses differ
+// in membership, address, state or health.
+func statusChanged(old, new *Status) bool {
+	if len(old.Members) != len(new.Members) {
+		return true
+	}
+	oldMembers := make(map[int]MemberStatus)
+	for _, member := range old.Members {
+		oldMembers[member.Id] = member
+	}
+	for _, member := range new.Members {
+		oldMember, ok := oldMembers[member.Id]
+		if !ok {
+			return true
+		}
+		if oldMember.Address != member.Address ||
+			oldMember.State != member.State ||
+			oldMember.Healthy != member.Healthy {
+			return true
+		}
+	}
+	return false
+}
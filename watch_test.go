@@ -0,0 +1,192 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package replicaset
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2"
+)
+
+type watchSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&watchSuite{})
+
+// patchStatuses patches getCurrentStatus to return each of the given
+// statuses in turn, repeating the last one once they are exhausted.
+func (s *watchSuite) patchStatuses(statuses ...*Status) {
+	calls := 0
+	s.PatchValue(&getCurrentStatus,
+		func(session *mgo.Session) (*Status, error) {
+			status := statuses[len(statuses)-1]
+			if calls < len(statuses) {
+				status = statuses[calls]
+			}
+			calls++
+			if status == nil {
+				return nil, errors.New("boom")
+			}
+			return status, nil
+		},
+	)
+}
+
+func threeMemberStatus(primary int, healthy ...bool) *Status {
+	status := &Status{Name: rsName}
+	for i, h := range healthy {
+		member := MemberStatus{
+			Id:      i + 1,
+			Address: fmt.Sprintf("1.2.3.%d:37017", i+1),
+			Healthy: h,
+			State:   SecondaryState,
+		}
+		if i+1 == primary {
+			member.State = PrimaryState
+		}
+		status.Members = append(status.Members, member)
+	}
+	return status
+}
+
+func (s *watchSuite) TestWatchSendsOnlyChanges(c *gc.C) {
+	first := threeMemberStatus(1, true, true, true)
+	newPrimary := threeMemberStatus(2, true, true, true)
+	unhealthy := threeMemberStatus(2, true, true, false)
+	s.patchStatuses(
+		first,
+		threeMemberStatus(1, true, true, true),
+		newPrimary,
+		threeMemberStatus(2, true, true, true),
+		unhealthy,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	statuses, errs := Watch(ctx, nil, time.Millisecond)
+
+	for i, expected := range []*Status{first, newPrimary, unhealthy} {
+		select {
+		case status := <-statuses:
+			c.Check(status, gc.Equals, expected, gc.Commentf("event %d", i))
+		case err := <-errs:
+			c.Fatalf("unexpected error: %v", err)
+		case <-time.After(10 * time.Second):
+			c.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+	select {
+	case status := <-statuses:
+		c.Fatalf("unexpected status: %#v", status)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	for range statuses {
+	}
+	for range errs {
+	}
+}
+
+func (s *watchSuite) TestWatchSendsErrors(c *gc.C) {
+	status := threeMemberStatus(1, true, true, true)
+	s.patchStatuses(nil, status)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	statuses, errs := Watch(ctx, nil, time.Millisecond)
+
+	select {
+	case err := <-errs:
+		c.Check(err, gc.ErrorMatches, "boom")
+	case <-time.After(10 * time.Second):
+		c.Fatalf("timed out waiting for error")
+	}
+	select {
+	case obtained := <-statuses:
+		c.Check(obtained, gc.Equals, status)
+	case <-time.After(10 * time.Second):
+		c.Fatalf("timed out waiting for status")
+	}
+
+	cancel()
+	_, ok := <-statuses
+	c.Check(ok, jc.IsFalse)
+}
+
+func (s *watchSuite) TestWatchErrorsNotReceived(c *gc.C) {
+	status := threeMemberStatus(1, true, true, true)
+	s.patchStatuses(nil, nil, nil, status)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	statuses, errs := Watch(ctx, nil, time.Millisecond)
+
+	// Errors that are not received do not stop statuses from being sent.
+	select {
+	case obtained := <-statuses:
+		c.Check(obtained, gc.Equals, status)
+	case <-time.After(10 * time.Second):
+		c.Fatalf("timed out waiting for status")
+	}
+	err := <-errs
+	c.Check(err, gc.ErrorMatches, "boom")
+
+	cancel()
+	for range statuses {
+	}
+	for range errs {
+	}
+}
+
+func (s *watchSuite) TestWatchPrimary(c *gc.C) {
+	s.patchStatuses(
+		threeMemberStatus(1, true, true, true),
+		threeMemberStatus(1, true, true, false),
+		// An election, with no primary for a while.
+		threeMemberStatus(0, false, true, false),
+		threeMemberStatus(0, false, true, true),
+		threeMemberStatus(2, false, true, true),
+		threeMemberStatus(2, true, true, true),
+		threeMemberStatus(3, true, true, true),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, errs := WatchPrimary(ctx, nil, time.Millisecond)
+
+	for i, expected := range []PrimaryChange{
+		{From: "1.2.3.1:37017", To: "1.2.3.2:37017"},
+		{From: "1.2.3.2:37017", To: "1.2.3.3:37017"},
+	} {
+		select {
+		case change := <-changes:
+			c.Check(change.From, gc.Equals, expected.From, gc.Commentf("event %d", i))
+			c.Check(change.To, gc.Equals, expected.To, gc.Commentf("event %d", i))
+			c.Check(change.At.IsZero(), jc.IsFalse)
+		case err := <-errs:
+			c.Fatalf("unexpected error: %v", err)
+		case <-time.After(10 * time.Second):
+			c.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+	select {
+	case change := <-changes:
+		c.Fatalf("unexpected change: %#v", change)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	for range changes {
+	}
+	for range errs {
+	}
+}